@@ -0,0 +1,67 @@
+package sslmgr
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+
+	"golang.org/x/crypto/acme"
+)
+
+// ACMEConfig bundles the settings needed to obtain certificates from a
+// private ACME CA (e.g. Smallstep's step-ca) rather than Let's Encrypt
+type ACMEConfig struct {
+	// DirectoryURL is the https URL of the CA's ACME directory, e.g.
+	// "https://ca.internal:9000/acme/acme/directory" for step-ca's
+	// default ACME provisioner
+	// (REQUIRED)
+	DirectoryURL string
+
+	// RootCAs are the only roots trusted for connections to the CA
+	// Default behavior is to trust the system's root CAs
+	RootCAs *x509.CertPool
+
+	// AccountKey is the key of the ACME account certificates are obtained
+	// under
+	// Default behavior is to generate a new account key
+	AccountKey crypto.Signer
+
+	// ExternalAccountBinding binds the ACME account to an account the CA
+	// already knows of, for CAs requiring it
+	// Default behavior is not to bind the account
+	ExternalAccountBinding *acme.ExternalAccountBinding
+}
+
+// validate returns ErrInvalidACMEConfig if the config is incomplete
+func (a ACMEConfig) validate() error {
+	u, err := url.Parse(a.DirectoryURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%w: directory url %q must be an https url", ErrInvalidACMEConfig, a.DirectoryURL)
+	}
+	if eab := a.ExternalAccountBinding; eab != nil && (eab.KID == "" || len(eab.Key) == 0) {
+		return fmt.Errorf("%w: external account binding requires a key id and a key", ErrInvalidACMEConfig)
+	}
+	return nil
+}
+
+// NewServerWithACME returns a SecureServer with the given config applied,
+// obtaining its certificates from the ACME CA described by a. The ACME
+// settings of c must be left unset, and c must not disable ACME (e.g.
+// with Certificates, an OriginCertFile, DevMode or OfflineMode)
+func NewServerWithACME(c ServerConfig, a ACMEConfig) (*SecureServer, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	if c.ACMEDirectoryURL != "" || c.ACMEServerRootCAs != nil || c.AccountKey != nil || c.ACMEExternalAccountBinding != nil {
+		return nil, fmt.Errorf("%w: acme settings are set in both configs", ErrInvalidACMEConfig)
+	}
+	if c.Certificates != nil || c.OriginCertFile != "" || c.OriginKeyFile != "" || c.DevMode || c.OfflineMode || c.ReadOnlyCache {
+		return nil, fmt.Errorf("%w: certificates are never obtained through acme with this server config", ErrInvalidACMEConfig)
+	}
+	c.ACMEDirectoryURL = a.DirectoryURL
+	c.ACMEServerRootCAs = a.RootCAs
+	c.AccountKey = a.AccountKey
+	c.ACMEExternalAccountBinding = a.ExternalAccountBinding
+	return NewServer(c)
+}
@@ -0,0 +1,75 @@
+package sslmgr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestNewServerWithACME(t *testing.T) {
+	Convey("Test NewServerWithACME()", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		roots := x509.NewCertPool()
+		eab := &acme.ExternalAccountBinding{KID: "kid", Key: []byte("secret")}
+		config := func() ServerConfig {
+			return ServerConfig{
+				Handler:   http.NotFoundHandler(),
+				Hostnames: []string{"app.internal"},
+				CertCache: newMemCache(),
+			}
+		}
+		acmeConfig := ACMEConfig{
+			DirectoryURL:           "https://ca.internal:9000/acme/acme/directory",
+			RootCAs:                roots,
+			AccountKey:             key,
+			ExternalAccountBinding: eab,
+		}
+		Convey("Test ACME Settings Are Applied", func() {
+			ss, err := NewServerWithACME(config(), acmeConfig)
+			So(err, ShouldBeNil)
+			So(ss.certMgr.Client.DirectoryURL, ShouldEqual, acmeConfig.DirectoryURL)
+			So(ss.certMgr.Client.Key, ShouldEqual, key)
+			So(ss.certMgr.Client.HTTPClient, ShouldNotBeNil)
+			So(ss.certMgr.ExternalAccountBinding, ShouldEqual, eab)
+			So(ss.newRenewalManager().(*autocert.Manager).ExternalAccountBinding, ShouldEqual, eab)
+		})
+		Convey("Test Invalid ACME Configs Are Rejected", func() {
+			for _, a := range []ACMEConfig{
+				{},
+				{DirectoryURL: "http://ca.internal/directory"},
+				{DirectoryURL: "https:///directory"},
+				{DirectoryURL: "https://ca.internal/directory", ExternalAccountBinding: &acme.ExternalAccountBinding{KID: "kid"}},
+			} {
+				_, err := NewServerWithACME(config(), a)
+				So(errors.Is(err, ErrInvalidACMEConfig), ShouldBeTrue)
+			}
+		})
+		Convey("Test Conflicting Server Configs Are Rejected", func() {
+			for _, mutate := range []func(*ServerConfig){
+				func(c *ServerConfig) { c.ACMEDirectoryURL = "https://acme.yourdomain.io/directory" },
+				func(c *ServerConfig) { c.AccountKey = key },
+				func(c *ServerConfig) { c.OfflineMode = true },
+				func(c *ServerConfig) { c.Certificates = testTLSConfig("app.internal").Certificates },
+				func(c *ServerConfig) { c.Certificates = []tls.Certificate{} },
+				func(c *ServerConfig) { c.OriginCertFile = "origin.pem" },
+				func(c *ServerConfig) { c.OriginKeyFile = "origin.key" },
+				func(c *ServerConfig) { c.DevMode = true },
+			} {
+				c := config()
+				mutate(&c)
+				_, err := NewServerWithACME(c, acmeConfig)
+				So(errors.Is(err, ErrInvalidACMEConfig), ShouldBeTrue)
+			}
+		})
+	})
+}
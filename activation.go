@@ -0,0 +1,68 @@
+package sslmgr
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd
+const listenFDsStart = 3
+
+// inheritedListeners reconstructs the listeners passed to the process
+// through the systemd socket activation protocol, i.e. as the LISTEN_FDS
+// file descriptors following firstFD. Listeners are keyed by the port
+// they are bound to (e.g. ":443"). The protocol's environment variables
+// are unset so that they are not inherited by child processes. Should any
+// file descriptor not be a TCP listener, the listeners reconstructed from
+// the others are closed
+func inheritedListeners(firstFD int) (map[string]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil // the listeners (if any) are not meant for us
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %s", err)
+	}
+	listeners := make(map[string]net.Listener, n)
+	for fd := firstFD; fd < firstFD+n; fd++ {
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("LISTEN_FD_%d", fd))
+		l, err := net.FileListener(f)
+		f.Close() // the listener holds a duplicate
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("inherited file descriptor %d is not a listener: %s", fd, err)
+		}
+		tcpAddr, ok := l.Addr().(*net.TCPAddr)
+		if !ok {
+			l.Close()
+			closeListeners(listeners)
+			return nil, fmt.Errorf("inherited file descriptor %d is not a tcp listener", fd)
+		}
+		listeners[fmt.Sprintf(":%d", tcpAddr.Port)] = l
+	}
+	return listeners, nil
+}
+
+// closeListeners closes and forgets every listener of listeners
+func closeListeners(listeners map[string]net.Listener) {
+	for addr, l := range listeners {
+		l.Close()
+		delete(listeners, addr)
+	}
+}
+
+// closeUnclaimed closes the inherited listeners no configured port was
+// matched to, which would otherwise remain open for the process' lifetime
+func (ss *SecureServer) closeUnclaimed() {
+	for addr := range ss.inherited {
+		ss.logger.Printf("[sslmgr] closing inherited listener %s, which no configured port claimed", addr)
+	}
+	closeListeners(ss.inherited)
+}
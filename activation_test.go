@@ -0,0 +1,122 @@
+package sslmgr
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// passListener simulates socket activation of l, returning the file
+// descriptor it is passed as
+func passListener(t *testing.T, l net.Listener) int {
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	return fd
+}
+
+func TestActivation(t *testing.T) {
+	Convey("Test inheritedListeners()", t, func() {
+		Convey("Test Listener Is Reconstructed", func() {
+			l, err := net.Listen("tcp", ":0")
+			So(err, ShouldBeNil)
+			defer l.Close()
+			fd := passListener(t, l)
+			listeners, err := inheritedListeners(fd)
+			So(err, ShouldBeNil)
+			So(listeners, ShouldHaveLength, 1)
+			inherited := listeners[portOf(l)]
+			So(inherited, ShouldNotBeNil)
+			defer inherited.Close()
+			So(os.Getenv("LISTEN_FDS"), ShouldBeEmpty)
+		})
+		Convey("Test Listeners For Another Process Are Ignored", func() {
+			t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+			t.Setenv("LISTEN_FDS", "1")
+			listeners, err := inheritedListeners(listenFDsStart)
+			So(err, ShouldBeNil)
+			So(listeners, ShouldBeEmpty)
+		})
+		Convey("Test Listeners Are Closed When A Later Descriptor Fails", func() {
+			l, err := net.Listen("tcp", ":0")
+			So(err, ShouldBeNil)
+			r, w, err := os.Pipe()
+			So(err, ShouldBeNil)
+			defer r.Close()
+			defer w.Close()
+			// descriptors are passed consecutively, so find two free ones
+			var st syscall.Stat_t
+			fd := 100
+			for syscall.Fstat(fd, &st) == nil || syscall.Fstat(fd+1, &st) == nil {
+				fd += 2
+			}
+			passed := passListener(t, l)
+			So(syscall.Dup3(passed, fd, 0), ShouldBeNil)
+			syscall.Close(passed)
+			So(syscall.Dup3(int(r.Fd()), fd+1, 0), ShouldBeNil)
+			t.Setenv("LISTEN_FDS", "2")
+			_, err = inheritedListeners(fd)
+			So(err, ShouldNotBeNil)
+			l.Close()
+			rebound, err := net.Listen("tcp", portOf(l))
+			So(err, ShouldBeNil)
+			rebound.Close()
+		})
+	})
+	Convey("Test Inherited Listener Is Served", t, func() {
+		l, err := net.Listen("tcp", ":0")
+		So(err, ShouldBeNil)
+		ss, err := NewServer(ServerConfig{
+			Handler:      http.NotFoundHandler(),
+			Hostnames:    []string{"yourdomain.io"},
+			HTTPPort:     portOf(l),
+			ServeSSLFunc: ServeSSLNever,
+			// binding rather than using the inherited listener fails
+			ListenConfig: &net.ListenConfig{
+				Control: func(network, address string, c syscall.RawConn) error {
+					return syscall.EADDRINUSE
+				},
+			},
+		})
+		So(err, ShouldBeNil)
+		ss.inherited = map[string]net.Listener{portOf(l): l}
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		resp, err := http.Get("http://localhost" + portOf(l))
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+		So(resp.StatusCode, ShouldEqual, http.StatusNotFound)
+	})
+	Convey("Test Unclaimed Inherited Listeners Are Closed", t, func() {
+		unclaimed, err := net.Listen("tcp", ":0")
+		So(err, ShouldBeNil)
+		logger := &testLogger{}
+		ss, err := NewServer(ServerConfig{
+			Handler:      http.NotFoundHandler(),
+			Hostnames:    []string{"yourdomain.io"},
+			HTTPPort:     freePort(),
+			ServeSSLFunc: ServeSSLNever,
+			Logger:       logger,
+		})
+		So(err, ShouldBeNil)
+		ss.inherited = map[string]net.Listener{portOf(unclaimed): unclaimed}
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		_, err = unclaimed.Accept()
+		So(err, ShouldNotBeNil)
+		So(logger.String(), ShouldContainSubstring, "closing inherited listener "+portOf(unclaimed))
+	})
+}
@@ -0,0 +1,473 @@
+package sslmgr
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// CacheObserver is notified of every operation performed against the
+// server's certificate cache. A nil error passed to OnCacheGet along with
+// hit == false denotes a plain cache miss
+type CacheObserver interface {
+	OnCacheGet(key string, hit bool, err error, d time.Duration)
+	OnCachePut(key string, err error, d time.Duration)
+	OnCacheDelete(key string, err error, d time.Duration)
+}
+
+// NewCacheLogger returns a CacheObserver which logs every cache operation
+func NewCacheLogger(l Logger) CacheObserver {
+	return &cacheLogger{logger: l}
+}
+
+type cacheLogger struct {
+	logger Logger
+}
+
+func (cl *cacheLogger) OnCacheGet(key string, hit bool, err error, d time.Duration) {
+	cl.logger.Printf("[sslmgr] cache get key=%s hit=%t err=%v took=%s", key, hit, err, d)
+}
+
+func (cl *cacheLogger) OnCachePut(key string, err error, d time.Duration) {
+	cl.logger.Printf("[sslmgr] cache put key=%s err=%v took=%s", key, err, d)
+}
+
+func (cl *cacheLogger) OnCacheDelete(key string, err error, d time.Duration) {
+	cl.logger.Printf("[sslmgr] cache delete key=%s err=%v took=%s", key, err, d)
+}
+
+// observedCache reports every operation on the wrapped cache to an observer
+type observedCache struct {
+	autocert.Cache
+	observer CacheObserver
+}
+
+func (oc *observedCache) Get(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	data, err := oc.Cache.Get(ctx, key)
+	if err == autocert.ErrCacheMiss {
+		oc.observer.OnCacheGet(key, false, nil, time.Since(start))
+	} else {
+		oc.observer.OnCacheGet(key, err == nil, err, time.Since(start))
+	}
+	return data, err
+}
+
+func (oc *observedCache) Put(ctx context.Context, key string, data []byte) error {
+	start := time.Now()
+	err := oc.Cache.Put(ctx, key, data)
+	oc.observer.OnCachePut(key, err, time.Since(start))
+	return err
+}
+
+func (oc *observedCache) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := oc.Cache.Delete(ctx, key)
+	oc.observer.OnCacheDelete(key, err, time.Since(start))
+	return err
+}
+
+// httpTokenSuffix ends the cache keys autocert stores HTTP-01 tokens at
+const httpTokenSuffix = "+http-01"
+
+// tokenStoreCache stores the HTTP-01 challenge tokens autocert caches in
+// a separate store, and everything else in the wrapped cache
+type tokenStoreCache struct {
+	autocert.Cache
+	tokens autocert.Cache
+}
+
+// storeFor returns the store key belongs in
+func (tc *tokenStoreCache) storeFor(key string) autocert.Cache {
+	if strings.HasSuffix(key, httpTokenSuffix) {
+		return tc.tokens
+	}
+	return tc.Cache
+}
+
+func (tc *tokenStoreCache) Get(ctx context.Context, key string) ([]byte, error) {
+	return tc.storeFor(key).Get(ctx, key)
+}
+
+func (tc *tokenStoreCache) Put(ctx context.Context, key string, data []byte) error {
+	return tc.storeFor(key).Put(ctx, key, data)
+}
+
+func (tc *tokenStoreCache) Delete(ctx context.Context, key string) error {
+	return tc.storeFor(key).Delete(ctx, key)
+}
+
+// readOnlyCache refuses writes to the wrapped cache
+type readOnlyCache struct {
+	autocert.Cache
+}
+
+func (rc *readOnlyCache) Put(ctx context.Context, key string, data []byte) error {
+	return ErrReadOnlyCache
+}
+
+func (rc *readOnlyCache) Delete(ctx context.Context, key string) error {
+	return ErrReadOnlyCache
+}
+
+// NewEncryptedCache returns a cache which encrypts values with AES-256-GCM
+// before writing them to inner, and decrypts them when read back. This
+// protects the private keys autocert caches (e.g. in plaintext files with
+// autocert.DirCache) at rest. The key must be 32 bytes long, and is best
+// kept apart from the cache (e.g. in a KMS or the environment), and
+// ErrInvalidCacheKey is returned for any other length.
+// Entries written to inner by anything other than an encrypted cache with
+// the same key fail to be read with ErrCacheDecryption
+func NewEncryptedCache(inner autocert.Cache, key []byte) (autocert.Cache, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%w: got %d bytes", ErrInvalidCacheKey, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedCache{Cache: inner, aead: aead}, nil
+}
+
+// encryptedCache encrypts the values stored in the wrapped cache. Each
+// value is sealed under a random nonce, which is stored in front of it,
+// and bound to its cache key so that entries cannot be swapped around
+type encryptedCache struct {
+	autocert.Cache
+	aead cipher.AEAD
+}
+
+func (ec *encryptedCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := ec.Cache.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	size := ec.aead.NonceSize()
+	if len(data) < size {
+		return nil, fmt.Errorf("%w: %s", ErrCacheDecryption, key)
+	}
+	plain, err := ec.aead.Open(nil, data[:size], data[size:], []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCacheDecryption, key)
+	}
+	return plain, nil
+}
+
+func (ec *encryptedCache) Put(ctx context.Context, key string, data []byte) error {
+	nonce := make([]byte, ec.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	return ec.Cache.Put(ctx, key, ec.aead.Seal(nonce, nonce, data, []byte(key)))
+}
+
+// NewCachingCache returns a cache which keeps the values read from inner
+// in memory for the given ttl, so that repeated reads (e.g. on every cold
+// handshake) do not each reach a remote store such as S3. Writes and
+// deletions go through to inner, and update the values kept in memory.
+// Cache misses are never kept, and a non-positive ttl returns inner as is.
+// Note that values written to inner by other instances are only seen once
+// the ttl of the value kept in memory elapses
+func NewCachingCache(inner autocert.Cache, ttl time.Duration) autocert.Cache {
+	if ttl <= 0 {
+		return inner
+	}
+	return &cachingCache{Cache: inner, ttl: ttl, entries: make(map[string]cachingEntry)}
+}
+
+// cachingCache memoizes the values read from the wrapped cache
+type cachingCache struct {
+	autocert.Cache
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachingEntry
+}
+
+// cachingEntry is a value kept in memory by a cachingCache until expires
+type cachingEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+func (cc *cachingCache) Get(ctx context.Context, key string) ([]byte, error) {
+	cc.mu.Lock()
+	entry, ok := cc.entries[key]
+	cc.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.data, nil
+	}
+	data, err := cc.Cache.Get(ctx, key)
+	if err != nil {
+		cc.forget(key)
+		return nil, err
+	}
+	cc.remember(key, data)
+	return data, nil
+}
+
+func (cc *cachingCache) Put(ctx context.Context, key string, data []byte) error {
+	if err := cc.Cache.Put(ctx, key, data); err != nil {
+		cc.forget(key)
+		return err
+	}
+	cc.remember(key, data)
+	return nil
+}
+
+func (cc *cachingCache) Delete(ctx context.Context, key string) error {
+	cc.forget(key)
+	return cc.Cache.Delete(ctx, key)
+}
+
+// remember keeps data in memory as the value of key for the ttl
+func (cc *cachingCache) remember(key string, data []byte) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.entries[key] = cachingEntry{data: data, expires: time.Now().Add(cc.ttl)}
+}
+
+// forget drops the value of key kept in memory, if any
+func (cc *cachingCache) forget(key string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	delete(cc.entries, key)
+}
+
+// cachedCert reads the certificate autocert stored for the given host
+// straight from the cache, without ever contacting the ACME server.
+// Both the ECDSA and RSA entries autocert may have written are tried
+func cachedCert(ctx context.Context, cache autocert.Cache, host string) (*tls.Certificate, error) {
+	var lastErr error
+	for _, key := range []string{host, host + "+rsa"} {
+		data, err := cache.Get(ctx, key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		cert, err := decodeCachedCert(data)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if err := verifyCachedCert(cert.Leaf, host, time.Now()); err != nil {
+			lastErr = err
+			continue
+		}
+		return cert, nil
+	}
+	return nil, lastErr
+}
+
+// decodeCachedCert parses a cache entry in the format autocert uses:
+// a PEM encoded private key followed by the PEM encoded certificate chain
+func decodeCachedCert(data []byte) (*tls.Certificate, error) {
+	priv, rest := pem.Decode(data)
+	if priv == nil || !strings.Contains(priv.Type, "PRIVATE") {
+		return nil, errors.New("cache entry does not begin with a private key")
+	}
+	key, err := parsePrivateKey(priv.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{PrivateKey: key}
+	for len(rest) > 0 {
+		var b *pem.Block
+		if b, rest = pem.Decode(rest); b == nil {
+			break
+		}
+		cert.Certificate = append(cert.Certificate, b.Bytes)
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("cache entry contains no certificates")
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// encodeCachedCert serializes a certificate in the format autocert uses,
+// returning the cache key autocert will look it up by for host
+func encodeCachedCert(host string, cert *tls.Certificate) (string, []byte, error) {
+	var buf bytes.Buffer
+	key := host
+	switch priv := cert.PrivateKey.(type) {
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(priv)
+		if err != nil {
+			return "", nil, err
+		}
+		pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	case *rsa.PrivateKey:
+		pem.Encode(&buf, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+		key = host + "+rsa"
+	default:
+		return "", nil, errors.New("private key must be ECDSA or RSA")
+	}
+	for _, der := range cert.Certificate {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	return key, buf.Bytes(), nil
+}
+
+// verifyCachedCert checks that a leaf certificate is usable for host at now
+func verifyCachedCert(leaf *x509.Certificate, host string, now time.Time) error {
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("certificate for %s is not valid yet", host)
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("certificate for %s has expired", host)
+	}
+	return leaf.VerifyHostname(host)
+}
+
+// parsePrivateKey parses a DER encoded private key in any of the
+// encodings autocert may have used to store it
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return key, nil
+		case *ecdsa.PrivateKey:
+			return key, nil
+		default:
+			return nil, errors.New("unknown private key type in PKCS#8 wrapping")
+		}
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("failed to parse private key")
+}
+
+// cacheHealthCheck returns a background task verifying the cached
+// certificate of each of the server's hostnames every interval, and
+// reporting the outcome to onHealth
+func (ss *SecureServer) cacheHealthCheck(interval time.Duration, onHealth func(string, bool, error)) func(context.Context) error {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, host := range ss.hostnames {
+				_, err := cachedCert(ctx, ss.certMgr.Cache, host)
+				onHealth(host, err == nil, err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// cacheWriteCooldown is the time certificate issuance is suspended for
+// after the CacheWriteFailureLimit is reached
+const cacheWriteCooldown = 10 * time.Minute
+
+// writeBreaker counts consecutive failed cache writes, and suspends
+// certificate issuance once they reach its limit (if any), until the
+// cacheWriteCooldown elapses or a write succeeds
+type writeBreaker struct {
+	sync.Mutex
+	limit     int
+	failures  int
+	trippedAt time.Time
+	logger    Logger
+}
+
+// record resets the failure count on success, and otherwise increments
+// it, tripping the breaker when it reaches the limit
+func (wb *writeBreaker) record(err error) {
+	wb.Lock()
+	defer wb.Unlock()
+	if err == nil {
+		wb.failures = 0
+		wb.trippedAt = time.Time{}
+		return
+	}
+	wb.failures++
+	if wb.limit > 0 && wb.failures >= wb.limit && wb.trippedAt.IsZero() {
+		wb.trippedAt = time.Now()
+		wb.logger.Printf("[sslmgr] ERROR %d consecutive cache writes failed, suspending certificate issuance for %s", wb.failures, cacheWriteCooldown)
+	}
+}
+
+// allow returns ErrCacheWriteFailing while the breaker is tripped
+func (wb *writeBreaker) allow() error {
+	wb.Lock()
+	defer wb.Unlock()
+	if wb.trippedAt.IsZero() {
+		return nil
+	}
+	if time.Since(wb.trippedAt) < cacheWriteCooldown {
+		return ErrCacheWriteFailing
+	}
+	// let issuance be attempted again, tripping again on further failures
+	wb.failures = 0
+	wb.trippedAt = time.Time{}
+	return nil
+}
+
+// guard returns a copy of client (or of autocert's default client, if
+// nil) whose requests to the CA fail while the breaker is tripped
+func (wb *writeBreaker) guard(client *acme.Client) *acme.Client {
+	return wrapACMETransport(client, func(transport http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			if err := wb.allow(); err != nil {
+				return nil, err
+			}
+			return transport.RoundTrip(r)
+		})
+	})
+}
+
+// roundTripperFunc adapts a function to the http.RoundTripper interface
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// writeFailureCache reports failed writes to the underlying cache, and
+// records their outcome with a writeBreaker
+type writeFailureCache struct {
+	autocert.Cache
+	breaker *writeBreaker
+	onError func(key string, err error)
+}
+
+func (wc *writeFailureCache) Put(ctx context.Context, key string, data []byte) error {
+	err := wc.Cache.Put(ctx, key, data)
+	wc.breaker.record(err)
+	if err != nil {
+		wc.breaker.logger.Printf("[sslmgr] ERROR failed to write %s to the cache: %s", key, err)
+		wc.onError(key, err)
+	}
+	return err
+}
@@ -0,0 +1,437 @@
+package sslmgr
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// memCache is an in-memory autocert.Cache for tests
+type memCache struct {
+	sync.Mutex
+	data map[string][]byte
+}
+
+func newMemCache() *memCache {
+	return &memCache{data: make(map[string][]byte)}
+}
+
+func (m *memCache) Get(ctx context.Context, key string) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	if d, ok := m.data[key]; ok {
+		return d, nil
+	}
+	return nil, autocert.ErrCacheMiss
+}
+
+func (m *memCache) Put(ctx context.Context, key string, data []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.data[key] = data
+	return nil
+}
+
+func (m *memCache) Delete(ctx context.Context, key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+// testCertPEM returns a PEM encoded self-signed certificate and key for host
+// valid between notBefore and notAfter
+func testCertPEM(host string, notBefore, notAfter time.Time) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+// testCacheEntry returns a cache entry for host in autocert's format
+func testCacheEntry(host string, notBefore, notAfter time.Time) []byte {
+	certPEM, keyPEM := testCertPEM(host, notBefore, notAfter)
+	return bytes.Join([][]byte{keyPEM, certPEM}, nil)
+}
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	Convey("Test cachedCert()", t, func() {
+		Convey("Test Valid Entry Is Returned", func() {
+			cache := newMemCache()
+			cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+			cert, err := cachedCert(context.Background(), cache, "yourdomain.io")
+			So(err, ShouldBeNil)
+			So(cert, ShouldNotBeNil)
+			So(cert.Leaf.DNSNames, ShouldResemble, []string{"yourdomain.io"})
+		})
+		Convey("Test RSA Entry Is Found", func() {
+			cache := newMemCache()
+			cache.Put(context.Background(), "yourdomain.io+rsa", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+			cert, err := cachedCert(context.Background(), cache, "yourdomain.io")
+			So(err, ShouldBeNil)
+			So(cert, ShouldNotBeNil)
+		})
+		Convey("Test Missing Entry", func() {
+			cert, err := cachedCert(context.Background(), newMemCache(), "yourdomain.io")
+			So(cert, ShouldBeNil)
+			So(err, ShouldEqual, autocert.ErrCacheMiss)
+		})
+		Convey("Test Expired Entry", func() {
+			cache := newMemCache()
+			cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-2*time.Hour), now.Add(-time.Hour)))
+			cert, err := cachedCert(context.Background(), cache, "yourdomain.io")
+			So(cert, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Test Hostname Mismatch", func() {
+			cache := newMemCache()
+			cache.Put(context.Background(), "yourdomain.io", testCacheEntry("otherdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+			cert, err := cachedCert(context.Background(), cache, "yourdomain.io")
+			So(cert, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Test observedCache", t, func() {
+		var ops []string
+		obs := &funcObserver{record: func(op string) { ops = append(ops, op) }}
+		cache := &observedCache{Cache: newMemCache(), observer: obs}
+		ctx := context.Background()
+		cache.Get(ctx, "yourdomain.io")
+		cache.Put(ctx, "yourdomain.io", []byte("data"))
+		cache.Get(ctx, "yourdomain.io")
+		cache.Delete(ctx, "yourdomain.io")
+		So(ops, ShouldResemble, []string{
+			"get yourdomain.io hit=false err=<nil>",
+			"put yourdomain.io err=<nil>",
+			"get yourdomain.io hit=true err=<nil>",
+			"delete yourdomain.io err=<nil>",
+		})
+	})
+	Convey("Test ChallengeTokenStore", t, func() {
+		ctx := context.Background()
+		certs, tokens := newMemCache(), newMemCache()
+		newServer := func() *SecureServer {
+			ss, err := NewServer(ServerConfig{
+				Handler:             http.NotFoundHandler(),
+				Hostnames:           []string{"yourdomain.io"},
+				CertCache:           newMemCache(),
+				ChallengeTokenStore: tokens,
+			})
+			So(err, ShouldBeNil)
+			return ss
+		}
+		tc := &tokenStoreCache{Cache: certs, tokens: tokens}
+		Convey("Test Tokens Are Routed To The Token Store", func() {
+			So(tc.Put(ctx, "token+http-01", []byte("token.thumbprint")), ShouldBeNil)
+			So(tc.Put(ctx, "yourdomain.io", []byte("cert")), ShouldBeNil)
+			_, err := certs.Get(ctx, "token+http-01")
+			So(err, ShouldEqual, autocert.ErrCacheMiss)
+			_, err = tokens.Get(ctx, "yourdomain.io")
+			So(err, ShouldEqual, autocert.ErrCacheMiss)
+			So(tc.Delete(ctx, "token+http-01"), ShouldBeNil)
+			_, err = tokens.Get(ctx, "token+http-01")
+			So(err, ShouldEqual, autocert.ErrCacheMiss)
+		})
+		Convey("Test Another Instance's Challenge Is Answered", func() {
+			tokens.Put(ctx, "token+http-01", []byte("token.thumbprint"))
+			rec := httptest.NewRecorder()
+			newServer().certMgr.HTTPHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://yourdomain.io/.well-known/acme-challenge/token", nil))
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldEqual, "token.thumbprint")
+		})
+	})
+	Convey("Test NewEncryptedCache()", t, func() {
+		ctx := context.Background()
+		key := bytes.Repeat([]byte{1}, 32)
+		inner := newMemCache()
+		cache, err := NewEncryptedCache(inner, key)
+		So(err, ShouldBeNil)
+		So(cache.Put(ctx, "yourdomain.io", []byte("private key")), ShouldBeNil)
+		Convey("Test Values Are Encrypted At Rest", func() {
+			data, err := inner.Get(ctx, "yourdomain.io")
+			So(err, ShouldBeNil)
+			So(bytes.Contains(data, []byte("private key")), ShouldBeFalse)
+		})
+		Convey("Test Values Are Decrypted On Get", func() {
+			data, err := cache.Get(ctx, "yourdomain.io")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "private key")
+		})
+		Convey("Test Misses Are Passed Through", func() {
+			_, err := cache.Get(ctx, "otherdomain.io")
+			So(err, ShouldEqual, autocert.ErrCacheMiss)
+		})
+		Convey("Test Wrong Key Fails To Decrypt", func() {
+			other, err := NewEncryptedCache(inner, bytes.Repeat([]byte{2}, 32))
+			So(err, ShouldBeNil)
+			_, err = other.Get(ctx, "yourdomain.io")
+			So(errors.Is(err, ErrCacheDecryption), ShouldBeTrue)
+		})
+		Convey("Test Swapped Entries Fail To Decrypt", func() {
+			data, _ := inner.Get(ctx, "yourdomain.io")
+			inner.Put(ctx, "otherdomain.io", data)
+			_, err := cache.Get(ctx, "otherdomain.io")
+			So(errors.Is(err, ErrCacheDecryption), ShouldBeTrue)
+		})
+		Convey("Test Plaintext Entries Fail To Decrypt", func() {
+			inner.Put(ctx, "otherdomain.io", []byte("short"))
+			_, err := cache.Get(ctx, "otherdomain.io")
+			So(errors.Is(err, ErrCacheDecryption), ShouldBeTrue)
+		})
+		Convey("Test Short Key Is Rejected", func() {
+			_, err := NewEncryptedCache(inner, key[:16])
+			So(errors.Is(err, ErrInvalidCacheKey), ShouldBeTrue)
+		})
+		Convey("Test Offline Server Serves Encrypted Cache", func() {
+			now := time.Now()
+			cache.Put(ctx, "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+			ss, err := NewServer(ServerConfig{
+				Handler:     http.NotFoundHandler(),
+				Hostnames:   []string{"yourdomain.io"},
+				CertCache:   cache,
+				OfflineMode: true,
+			})
+			So(err, ShouldBeNil)
+			cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+			So(err, ShouldBeNil)
+			So(cert, ShouldNotBeNil)
+		})
+	})
+	Convey("Test NewCachingCache()", t, func() {
+		ctx := context.Background()
+		inner := newMemCache()
+		inner.Put(ctx, "yourdomain.io", []byte("v1"))
+		cache := NewCachingCache(inner, 100*time.Millisecond)
+		data, err := cache.Get(ctx, "yourdomain.io")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "v1")
+		inner.Put(ctx, "yourdomain.io", []byte("v2"))
+		Convey("Test Values Are Kept For The TTL", func() {
+			data, err := cache.Get(ctx, "yourdomain.io")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "v1")
+		})
+		Convey("Test Values Are Read Again Once The TTL Elapses", func() {
+			time.Sleep(150 * time.Millisecond)
+			data, err := cache.Get(ctx, "yourdomain.io")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "v2")
+		})
+		Convey("Test Puts Write Through", func() {
+			So(cache.Put(ctx, "yourdomain.io", []byte("v3")), ShouldBeNil)
+			stored, _ := inner.Get(ctx, "yourdomain.io")
+			So(string(stored), ShouldEqual, "v3")
+			data, err := cache.Get(ctx, "yourdomain.io")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "v3")
+		})
+		Convey("Test Deletes Write Through", func() {
+			So(cache.Delete(ctx, "yourdomain.io"), ShouldBeNil)
+			_, err := inner.Get(ctx, "yourdomain.io")
+			So(err, ShouldEqual, autocert.ErrCacheMiss)
+			_, err = cache.Get(ctx, "yourdomain.io")
+			So(err, ShouldEqual, autocert.ErrCacheMiss)
+		})
+		Convey("Test Misses Are Not Kept", func() {
+			_, err := cache.Get(ctx, "otherdomain.io")
+			So(err, ShouldEqual, autocert.ErrCacheMiss)
+			inner.Put(ctx, "otherdomain.io", []byte("v1"))
+			data, err := cache.Get(ctx, "otherdomain.io")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "v1")
+		})
+		Convey("Test Failed Puts Are Not Kept", func() {
+			failing := NewCachingCache(&failingCache{memCache: inner, failing: true}, time.Minute)
+			So(failing.Put(ctx, "otherdomain.io", []byte("v1")), ShouldNotBeNil)
+			_, err := failing.Get(ctx, "otherdomain.io")
+			So(err, ShouldEqual, autocert.ErrCacheMiss)
+		})
+		Convey("Test Non-Positive TTL Returns Inner", func() {
+			So(NewCachingCache(inner, 0), ShouldEqual, inner)
+		})
+	})
+	Convey("Test decodeCachedCert()", t, func() {
+		Convey("Test Corrupt Entry", func() {
+			cert, err := decodeCachedCert([]byte("not pem"))
+			So(cert, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Test Entry Without Certificates", func() {
+			_, keyPEM := testCertPEM("yourdomain.io", now, now.Add(time.Hour))
+			cert, err := decodeCachedCert(keyPEM)
+			So(cert, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// funcObserver records cache operations as strings
+type funcObserver struct {
+	record func(string)
+}
+
+func (fo *funcObserver) OnCacheGet(key string, hit bool, err error, d time.Duration) {
+	fo.record(fmt.Sprintf("get %s hit=%t err=%v", key, hit, err))
+}
+
+func (fo *funcObserver) OnCachePut(key string, err error, d time.Duration) {
+	fo.record(fmt.Sprintf("put %s err=%v", key, err))
+}
+
+func (fo *funcObserver) OnCacheDelete(key string, err error, d time.Duration) {
+	fo.record(fmt.Sprintf("delete %s err=%v", key, err))
+}
+
+func TestCacheHealthCheck(t *testing.T) {
+	Convey("Test CacheHealthCheckInterval", t, func() {
+		now := time.Now()
+		cache := newMemCache()
+		cache.Put(context.Background(), "a.yourdomain.io", testCacheEntry("a.yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+		cache.Put(context.Background(), "b.yourdomain.io", testCacheEntry("b.yourdomain.io", now.Add(-2*time.Hour), now.Add(-time.Hour)))
+		cache.Put(context.Background(), "c.yourdomain.io", []byte("garbage"))
+		var mu sync.Mutex
+		checks := make(map[string]int)
+		failures := make(map[string]error)
+		ss, err := NewServer(ServerConfig{
+			Handler:                  http.NotFoundHandler(),
+			Hostnames:                []string{"a.yourdomain.io", "b.yourdomain.io", "c.yourdomain.io"},
+			CertCache:                cache,
+			HTTPPort:                 freePort(),
+			ServeSSLFunc:             ServeSSLNever,
+			CacheHealthCheckInterval: 10 * time.Millisecond,
+			OnCacheHealth: func(host string, ok bool, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				checks[host]++
+				if !ok {
+					failures[host] = err
+				}
+			},
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		time.Sleep(50 * time.Millisecond)
+		ss.TriggerShutdown("test")
+		<-ss.done
+		mu.Lock()
+		n := checks["a.yourdomain.io"]
+		So(n, ShouldBeGreaterThan, 1)
+		So(failures, ShouldHaveLength, 2)
+		So(failures, ShouldContainKey, "b.yourdomain.io")
+		So(failures, ShouldContainKey, "c.yourdomain.io")
+		mu.Unlock()
+		// checks stop on shutdown
+		time.Sleep(30 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		So(checks["a.yourdomain.io"], ShouldEqual, n)
+	})
+}
+
+// failingCache is a memCache whose writes fail while failing is set
+type failingCache struct {
+	*memCache
+	failing bool
+}
+
+func (fc *failingCache) Put(ctx context.Context, key string, data []byte) error {
+	if fc.failing {
+		return errors.New("disk full")
+	}
+	return fc.memCache.Put(ctx, key, data)
+}
+
+func TestCacheWriteFailures(t *testing.T) {
+	Convey("Test Cache Write Failures", t, func() {
+		cache := &failingCache{memCache: newMemCache(), failing: true}
+		logger := &testLogger{}
+		var failedKeys []string
+		ss, err := NewServer(ServerConfig{
+			Handler:                http.NotFoundHandler(),
+			Hostnames:              []string{"yourdomain.io"},
+			CertCache:              cache,
+			Logger:                 logger,
+			CacheWriteFailureLimit: 2,
+			OnCacheWriteError: func(key string, err error) {
+				failedKeys = append(failedKeys, key)
+			},
+		})
+		So(err, ShouldBeNil)
+		ca := httptest.NewServer(http.NotFoundHandler())
+		defer ca.Close()
+		get := func() error {
+			resp, err := ss.certMgr.Client.HTTPClient.Get(ca.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			return err
+		}
+		put := func() error {
+			return ss.certMgr.Cache.Put(context.Background(), "yourdomain.io", []byte("cert"))
+		}
+		Convey("Test Failed Writes Are Reported And Logged", func() {
+			So(put(), ShouldNotBeNil)
+			So(failedKeys, ShouldResemble, []string{"yourdomain.io"})
+			So(logger.String(), ShouldContainSubstring, "failed to write yourdomain.io to the cache: disk full")
+		})
+		Convey("Test Issuance Is Suspended After Repeated Failures", func() {
+			So(put(), ShouldNotBeNil)
+			So(get(), ShouldBeNil)
+			So(put(), ShouldNotBeNil)
+			So(errors.Is(get(), ErrCacheWriteFailing), ShouldBeTrue)
+			So(logger.String(), ShouldContainSubstring, "suspending certificate issuance")
+			Convey("Test A Successful Write Resumes Issuance", func() {
+				cache.failing = false
+				So(put(), ShouldBeNil)
+				So(get(), ShouldBeNil)
+			})
+		})
+		Convey("Test Issuance Resumes After The Cooldown", func() {
+			breaker := &writeBreaker{limit: 1, logger: logger}
+			breaker.record(errors.New("disk full"))
+			So(breaker.allow(), ShouldEqual, ErrCacheWriteFailing)
+			breaker.trippedAt = time.Now().Add(-cacheWriteCooldown)
+			So(breaker.allow(), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,68 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+)
+
+// CertInfo describes the certificate cached for a hostname, for
+// diagnosing why it may not validate
+type CertInfo struct {
+	Host         string
+	Subject      string
+	Issuer       string
+	SerialNumber string
+	DNSNames     []string
+	NotBefore    time.Time
+	NotAfter     time.Time
+	// Chain is the full certificate chain, leaf first
+	Chain []*x509.Certificate
+	// OCSPStapled reports whether an OCSP response is stapled to the
+	// certificate. Note that autocert does not staple OCSP responses
+	OCSPStapled bool
+	// ValidationError is the reason the certificate can not be served
+	// for Host (e.g. it has expired), or nil if it can
+	ValidationError error
+}
+
+// CertInfo returns diagnostics about the certificate cached for host,
+// even if it is no longer (or not yet) valid. The ECDSA certificate is
+// described when both an ECDSA and RSA certificate are cached
+func (ss *SecureServer) CertInfo(host string) (*CertInfo, error) {
+	host = normalizeHost(host)
+	var lastErr error
+	for _, key := range []string{host, host + "+rsa"} {
+		data, err := ss.certMgr.Cache.Get(context.Background(), key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		cert, err := decodeCachedCert(data)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		info := &CertInfo{
+			Host:            host,
+			Subject:         cert.Leaf.Subject.String(),
+			Issuer:          cert.Leaf.Issuer.String(),
+			SerialNumber:    cert.Leaf.SerialNumber.String(),
+			DNSNames:        cert.Leaf.DNSNames,
+			NotBefore:       cert.Leaf.NotBefore,
+			NotAfter:        cert.Leaf.NotAfter,
+			Chain:           []*x509.Certificate{cert.Leaf},
+			OCSPStapled:     len(cert.OCSPStaple) > 0,
+			ValidationError: verifyCachedCert(cert.Leaf, host, time.Now()),
+		}
+		for _, der := range cert.Certificate[1:] {
+			intermediate, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, err
+			}
+			info.Chain = append(info.Chain, intermediate)
+		}
+		return info, nil
+	}
+	return nil, lastErr
+}
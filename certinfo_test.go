@@ -0,0 +1,51 @@
+package sslmgr
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestCertInfo(t *testing.T) {
+	Convey("Test CertInfo()", t, func() {
+		now := time.Now().Truncate(time.Second)
+		cache := newMemCache()
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io", "expired.io"},
+			CertCache: cache,
+		})
+		So(err, ShouldBeNil)
+		Convey("Test Cached Chain Is Described", func() {
+			certPEM, keyPEM := testCertPEM("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour))
+			issuerPEM, _ := testCertPEM("issuer", now.Add(-time.Hour), now.Add(time.Hour))
+			cache.Put(context.Background(), "yourdomain.io", bytes.Join([][]byte{keyPEM, certPEM, issuerPEM}, nil))
+			info, err := ss.CertInfo("YourDomain.io")
+			So(err, ShouldBeNil)
+			So(info.Host, ShouldEqual, "yourdomain.io")
+			So(info.Subject, ShouldEqual, "CN=yourdomain.io")
+			So(info.DNSNames, ShouldResemble, []string{"yourdomain.io"})
+			So(info.NotAfter, ShouldEqual, now.Add(time.Hour).UTC())
+			So(info.Chain, ShouldHaveLength, 2)
+			So(info.Chain[1].Subject.CommonName, ShouldEqual, "issuer")
+			So(info.OCSPStapled, ShouldBeFalse)
+			So(info.ValidationError, ShouldBeNil)
+		})
+		Convey("Test Expired Certificate Is Described", func() {
+			cache.Put(context.Background(), "expired.io+rsa", testCacheEntry("expired.io", now.Add(-2*time.Hour), now.Add(-time.Hour)))
+			info, err := ss.CertInfo("expired.io")
+			So(err, ShouldBeNil)
+			So(info.ValidationError, ShouldNotBeNil)
+		})
+		Convey("Test Missing Certificate", func() {
+			info, err := ss.CertInfo("yourdomain.io")
+			So(info, ShouldBeNil)
+			So(err, ShouldEqual, autocert.ErrCacheMiss)
+		})
+	})
+}
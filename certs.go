@@ -0,0 +1,505 @@
+package sslmgr
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeClient returns the ACME client used to obtain certificates, as
+// configured by the ACME settings of c. A nil client is returned when
+// none is set, leaving autocert to use its default client
+func acmeClient(c ServerConfig) *acme.Client {
+	customHTTP := c.ACMEResolver != nil || c.ACMEDialTimeout > 0 || c.ACMERequestTimeout > 0 || c.ACMEServerRootCAs != nil
+	if !customHTTP && c.AccountKey == nil && c.ACMEDirectoryURL == "" {
+		return nil
+	}
+	client := &acme.Client{
+		DirectoryURL: autocert.DefaultACMEDirectory,
+		Key:          c.AccountKey,
+	}
+	if c.ACMEDirectoryURL != "" {
+		client.DirectoryURL = c.ACMEDirectoryURL
+	}
+	if customHTTP {
+		dialTimeout := c.ACMEDialTimeout
+		if dialTimeout <= 0 {
+			dialTimeout = 30 * time.Second
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: 30 * time.Second,
+			Resolver:  c.ACMEResolver,
+		}).DialContext
+		if c.ACMEServerRootCAs != nil {
+			transport.TLSClientConfig = &tls.Config{RootCAs: c.ACMEServerRootCAs}
+		}
+		client.HTTPClient = &http.Client{Transport: transport}
+		if c.ACMERequestTimeout > 0 {
+			client.HTTPClient.Timeout = c.ACMERequestTimeout
+		}
+	}
+	return client
+}
+
+// wrapACMETransport returns a copy of client (or of autocert's default
+// client, if nil) whose requests to the CA go through wrap's transport
+func wrapACMETransport(client *acme.Client, wrap func(http.RoundTripper) http.RoundTripper) *acme.Client {
+	wrapped := &acme.Client{DirectoryURL: autocert.DefaultACMEDirectory}
+	if client != nil {
+		wrapped = &acme.Client{
+			DirectoryURL: client.DirectoryURL,
+			Key:          client.Key,
+			HTTPClient:   client.HTTPClient,
+		}
+	}
+	httpClient := http.Client{}
+	if wrapped.HTTPClient != nil {
+		httpClient = *wrapped.HTTPClient
+	}
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	httpClient.Transport = wrap(transport)
+	wrapped.HTTPClient = &httpClient
+	return wrapped
+}
+
+// withoutTLSALPN01 returns a copy of client (or of autocert's default
+// client, if nil) to which the CA appears never to offer TLS-ALPN-01
+// challenges. autocert always tries them first otherwise, even though
+// they fail when the server refuses to answer them
+func withoutTLSALPN01(client *acme.Client) *acme.Client {
+	return wrapACMETransport(client, func(transport http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := transport.RoundTrip(r)
+			if err != nil {
+				return nil, err
+			}
+			return dropChallenges(resp, ChallengeTLSALPN01)
+		})
+	})
+}
+
+// dropChallenges removes the challenges of type typ from resp, should it
+// describe an ACME authorization
+func dropChallenges(resp *http.Response, typ string) (*http.Response, error) {
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var authz map[string]json.RawMessage
+	var challenges []json.RawMessage
+	if json.Unmarshal(body, &authz) == nil && json.Unmarshal(authz["challenges"], &challenges) == nil {
+		kept := challenges[:0]
+		for _, c := range challenges {
+			var challenge struct{ Type string }
+			if json.Unmarshal(c, &challenge) == nil && challenge.Type == typ {
+				continue
+			}
+			kept = append(kept, c)
+		}
+		if len(kept) < len(challenges) {
+			authz["challenges"], _ = json.Marshal(kept)
+			body, _ = json.Marshal(authz)
+		}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return resp, nil
+}
+
+// tosPrompt wraps prompt so that every acceptance of the Terms of Service
+// of the CA at directoryURL is logged and reported to onAccepted
+func tosPrompt(prompt func(string) bool, directoryURL string, logger Logger, onAccepted func(string, time.Time)) func(string) bool {
+	return func(tosURL string) bool {
+		if !prompt(tosURL) {
+			return false
+		}
+		at := time.Now()
+		logger.Printf("[sslmgr] accepted terms of service %s of %s at %s", tosURL, directoryURL, at.Format(time.RFC3339))
+		onAccepted(tosURL, at)
+		return true
+	}
+}
+
+// ACME challenge types which may be selected in the ChallengeTypes
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+)
+
+// parseChallengeTypes reports which challenge types are selected, all of
+// them being selected by a nil list
+func parseChallengeTypes(types []string) (http01, tlsALPN01 bool, err error) {
+	if types == nil {
+		return true, true, nil
+	}
+	if len(types) == 0 {
+		return false, false, ErrInvalidChallengeTypes
+	}
+	for _, typ := range types {
+		switch typ {
+		case ChallengeHTTP01:
+			http01 = true
+		case ChallengeTLSALPN01:
+			tlsALPN01 = true
+		default:
+			return false, false, fmt.Errorf("%w: unknown type %q", ErrInvalidChallengeTypes, typ)
+		}
+	}
+	return http01, tlsALPN01, nil
+}
+
+// isChallengeHello reports whether hello is that of a TLS-ALPN-01 challenge
+func isChallengeHello(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+// certFetcher obtains the certificates served in TLS handshakes. It is
+// the server's autocert.Manager, unless a fake is injected by tests
+type certFetcher interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// CertManager returns the server's certificate manager, so that other
+// listeners (e.g. a gRPC server) may share its certificates and cache
+// through its GetCertificate or TLSConfig rather than obtaining their own.
+// Certificates are only issued while ACME challenges can be answered:
+// HTTP-01 challenges by the server's own HTTP listener, or TLS-ALPN-01
+// challenges by any listener using the manager's TLSConfig
+func (ss *SecureServer) CertManager() *autocert.Manager {
+	return ss.certMgr
+}
+
+// getCertificate is the tls.Config.GetCertificate hook used by the server
+func (ss *SecureServer) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := ss.selectCertificate(hello)
+	if cert != nil && ss.expiryWarningThreshold > 0 && !isChallengeHello(hello) {
+		ss.checkExpiry(normalizeHost(hello.ServerName), cert)
+	}
+	if cert != nil && ss.logCertFingerprints && !isChallengeHello(hello) {
+		ss.logNewCert(normalizeHost(hello.ServerName), cert)
+	}
+	return cert, err
+}
+
+// logNewCert logs the fingerprint, serial number and SANs of cert, unless
+// it was logged before
+func (ss *SecureServer) logNewCert(host string, cert *tls.Certificate) {
+	if len(cert.Certificate) == 0 {
+		return
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	fingerprint := hex.EncodeToString(sum[:])
+	if _, logged := ss.loggedCerts.LoadOrStore(fingerprint, struct{}{}); logged {
+		return
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return
+		}
+	}
+	sans := append([]string(nil), leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	ss.logCert(CertEvent{
+		Host:         host,
+		Fingerprint:  fingerprint,
+		SerialNumber: leaf.SerialNumber.Text(16),
+		SANs:         sans,
+		NotBefore:    leaf.NotBefore,
+		NotAfter:     leaf.NotAfter,
+	})
+}
+
+// checkExpiry reports cert to the OnExpiryWarning hook if it expires
+// within the ExpiryWarningThreshold
+func (ss *SecureServer) checkExpiry(host string, cert *tls.Certificate) {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return
+		}
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return
+		}
+	}
+	if expiresIn := time.Until(leaf.NotAfter); expiresIn < ss.expiryWarningThreshold {
+		ss.onExpiryWarning(host, expiresIn)
+	}
+}
+
+// selectCertificate returns the certificate to serve for hello
+func (ss *SecureServer) selectCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if ss.certSelector != nil {
+		if cert, ok := ss.certSelector(hello); ok {
+			return cert, nil
+		}
+	}
+	if cert := ss.originCert(hello); cert != nil {
+		// reloaded on SIGUSR1, unlike the tls.Config's Certificates
+		return cert, nil
+	}
+	if ss.certificates != nil {
+		if origin := ss.origin.Load(); origin != nil && len(ss.certificates) == 0 {
+			// the only certificate, served whatever the hostname requested
+			return origin, nil
+		}
+		// served from the tls.Config's Certificates
+		return nil, nil
+	}
+	if ss.offline || ss.readOnlyCache {
+		return ss.getCachedCertificate(hello)
+	}
+	if !ss.tlsALPN01 && isChallengeHello(hello) {
+		return nil, errors.New("tls-alpn-01 challenges are disabled")
+	}
+	cert, err := ss.certFetcher.GetCertificate(hello)
+	if host := normalizeHost(hello.ServerName); ss.isConfiguredHost(host) {
+		ss.renewals.record(host, err, ss.onRenewalFailure)
+	}
+	if err == nil && !isChallengeHello(hello) {
+		cert = ss.freshestCert(hello, cert)
+	}
+	if err != nil && !isChallengeHello(hello) {
+		if stale := ss.staleCertificate(hello); stale != nil {
+			return stale, nil
+		}
+	}
+	return cert, err
+}
+
+// staleCertificate returns the certificate cached for hello's hostname
+// if it is still valid (even if due for renewal) and supported by hello,
+// reporting it to the OnServingStaleCert hook, or nil otherwise
+func (ss *SecureServer) staleCertificate(hello *tls.ClientHelloInfo) *tls.Certificate {
+	host := normalizeHost(hello.ServerName)
+	if host == "" {
+		return nil
+	}
+	ctx := hello.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ss.certMgr.HostPolicy(ctx, host); err != nil {
+		return nil
+	}
+	cert, err := cachedCert(ctx, ss.certMgr.Cache, host)
+	if err != nil || hello.SupportsCertificate(cert) != nil {
+		return nil
+	}
+	ss.onServingStaleCert(host, time.Until(cert.Leaf.NotAfter))
+	return cert
+}
+
+// getCachedCertificate serves certificates exclusively from the cache,
+// never reaching autocert's issuance path
+func (ss *SecureServer) getCachedCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := normalizeHost(hello.ServerName)
+	if host == "" {
+		return nil, errors.New("missing server name")
+	}
+	ctx := hello.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ss.certMgr.HostPolicy(ctx, host); err != nil {
+		return nil, err
+	}
+	cert, err := cachedCert(ctx, ss.certMgr.Cache, host)
+	if err != nil {
+		miss := ErrOfflineCacheMiss
+		if !ss.offline {
+			miss = ErrReadOnlyCacheMiss
+		}
+		return nil, fmt.Errorf("%w: %s: %s", miss, host, err)
+	}
+	return cert, nil
+}
+
+// normalizeHost returns the canonical form of a hostname
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// isConfiguredHost reports whether host is one of the server's hostnames
+func (ss *SecureServer) isConfiguredHost(host string) bool {
+	for _, h := range ss.hostnames {
+		if normalizeHost(h) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// failureTracker counts consecutive certificate failures per hostname
+type failureTracker struct {
+	sync.Mutex
+	failures map[string]int
+}
+
+// record resets host's count on success, and otherwise increments it
+// and reports the failure to the given hook
+func (ft *failureTracker) record(host string, err error, hook func(string, error, int)) {
+	ft.Lock()
+	if ft.failures == nil {
+		ft.failures = make(map[string]int)
+	}
+	if err == nil {
+		delete(ft.failures, host)
+		ft.Unlock()
+		return
+	}
+	ft.failures[host]++
+	n := ft.failures[host]
+	ft.Unlock()
+	hook(host, err, n)
+}
+
+// ImportCert writes a PEM encoded certificate (chain) and private key for
+// host into the certificate cache, in the format autocert expects, so that
+// it is served instead of issuing a new one. This allows a new instance to
+// be seeded from a shared secret store for an instant warm start
+func (ss *SecureServer) ImportCert(ctx context.Context, host string, certPEM, keyPEM []byte) error {
+	host = normalizeHost(host)
+	if err := ss.certMgr.HostPolicy(ctx, host); err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if err := verifyCachedCert(cert.Leaf, host, time.Now()); err != nil {
+		return err
+	}
+	key, data, err := encodeCachedCert(host, &cert)
+	if err != nil {
+		return err
+	}
+	return ss.certMgr.Cache.Put(ctx, key, data)
+}
+
+// PrimeCerts obtains a certificate for every configured hostname, either
+// from the cache or by requesting one from the CA, so that the first
+// handshake for each hostname does not pay the cost of issuance.
+// The server must be serving for ACME challenges to be answered.
+// The returned error describes every hostname which could not be primed
+func (ss *SecureServer) PrimeCerts(ctx context.Context) error {
+	failures := ss.primeCerts(ctx)
+	var errs []error
+	for _, host := range ss.hostnames {
+		if err, failed := failures[host]; failed {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// primeCerts obtains a certificate for each configured hostname and
+// returns the errors encountered, keyed by hostname
+func (ss *SecureServer) primeCerts(ctx context.Context) map[string]error {
+	return ss.forEachHost(ctx, ss.primeCert)
+}
+
+// forEachHost calls fn for each configured hostname, up to PrimeConcurrency
+// at a time and paced by the PrimeRateLimit, and returns the errors
+// encountered, keyed by hostname
+func (ss *SecureServer) forEachHost(ctx context.Context, fn func(context.Context, string) error) map[string]error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failures := make(map[string]error)
+	slots := make(chan struct{}, ss.primeConcurrency)
+	for i, host := range ss.hostnames {
+		if i > 0 && ss.primeRateLimit > 0 {
+			select {
+			case <-time.After(ss.primeRateLimit):
+			case <-ctx.Done():
+			}
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			// select picks at random when both are ready, so check ctx
+			// for fn never to start once it is done
+			mu.Lock()
+			failures[host] = err
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := fn(ctx, host); err != nil {
+				mu.Lock()
+				failures[host] = err
+				mu.Unlock()
+			}
+		}(host)
+	}
+	wg.Wait()
+	return failures
+}
+
+// primeCert obtains the ECDSA certificate every modern client negotiates
+// for host, giving up when ctx is done, and verifies it is served when
+// VerifyPrimedCerts is set. Certificate issuance itself carries on in the
+// background regardless
+func (ss *SecureServer) primeCert(ctx context.Context, host string) error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := ss.getCertificate(ecdsaHello(host))
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if err != nil || !ss.verifyPrimedCerts {
+			return err
+		}
+		return ss.verifyServedCert(ctx, host)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ecdsaHello returns a minimal ClientHelloInfo for host supporting ECDSA
+// certificates, for which autocert obtains (and caches at the host's own
+// key) an ECDSA certificate rather than its RSA fallback
+func ecdsaHello(host string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:       host,
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:  []tls.CurveID{tls.CurveP256},
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+}
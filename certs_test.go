@@ -0,0 +1,632 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestCerts(t *testing.T) {
+	now := time.Now()
+	Convey("Test PrimeCerts()", t, func() {
+		cache := newMemCache()
+		cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+		ss, err := NewServer(ServerConfig{
+			Handler:     http.NotFoundHandler(),
+			Hostnames:   []string{"yourdomain.io", "otherdomain.io"},
+			CertCache:   cache,
+			OfflineMode: true,
+		})
+		So(err, ShouldBeNil)
+		err = ss.PrimeCerts(context.Background())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "otherdomain.io")
+		So(err.Error(), ShouldNotContainSubstring, "yourdomain.io")
+	})
+	Convey("Test PrimeCerts() Obtains The ECDSA Certificate", t, func() {
+		cache := newMemCache()
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io"},
+			CertCache: cache,
+		})
+		So(err, ShouldBeNil)
+		// cache certificates at the key autocert would, which depends on
+		// whether the hello supports ECDSA
+		ss = withCertManager(ss, certFetcherFunc(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			key := hello.ServerName + "+rsa"
+			for _, scheme := range hello.SignatureSchemes {
+				if scheme == tls.ECDSAWithP256AndSHA256 {
+					key = hello.ServerName
+				}
+			}
+			cache.Put(context.Background(), key, testCacheEntry(hello.ServerName, now.Add(-time.Hour), now.Add(time.Hour)))
+			return &tls.Certificate{}, nil
+		}))
+		So(ss.PrimeCerts(context.Background()), ShouldBeNil)
+		_, err = cache.Get(context.Background(), "yourdomain.io")
+		So(err, ShouldBeNil)
+		_, err = cache.Get(context.Background(), "yourdomain.io+rsa")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Test PrimeCerts() Concurrency And Pacing", t, func() {
+		hosts := []string{"a.yourdomain.io", "b.yourdomain.io", "c.yourdomain.io", "d.yourdomain.io"}
+		var mu sync.Mutex
+		var active, maxActive int
+		var starts []time.Time
+		newServer := func(concurrency int, rateLimit time.Duration) *SecureServer {
+			ss, err := NewServer(ServerConfig{
+				Handler:          http.NotFoundHandler(),
+				Hostnames:        hosts,
+				PrimeConcurrency: concurrency,
+				PrimeRateLimit:   rateLimit,
+				CertSelector: func(hello *tls.ClientHelloInfo) (*tls.Certificate, bool) {
+					mu.Lock()
+					active++
+					if active > maxActive {
+						maxActive = active
+					}
+					starts = append(starts, time.Now())
+					mu.Unlock()
+					time.Sleep(50 * time.Millisecond)
+					mu.Lock()
+					active--
+					mu.Unlock()
+					return &tls.Certificate{}, true
+				},
+			})
+			So(err, ShouldBeNil)
+			return ss
+		}
+		Convey("Test Hostnames Are Primed One At A Time By Default", func() {
+			So(newServer(0, 0).PrimeCerts(context.Background()), ShouldBeNil)
+			So(maxActive, ShouldEqual, 1)
+		})
+		Convey("Test Hostnames Are Primed In Parallel", func() {
+			So(newServer(2, 0).PrimeCerts(context.Background()), ShouldBeNil)
+			So(maxActive, ShouldEqual, 2)
+		})
+		Convey("Test Priming Is Paced", func() {
+			So(newServer(4, 30*time.Millisecond).PrimeCerts(context.Background()), ShouldBeNil)
+			So(starts, ShouldHaveLength, 4)
+			for i := 1; i < len(starts); i++ {
+				So(starts[i].Sub(starts[i-1]), ShouldBeGreaterThanOrEqualTo, 25*time.Millisecond)
+			}
+		})
+		Convey("Test Canceled Priming Reports Every Hostname", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			err := newServer(1, time.Second).PrimeCerts(ctx)
+			So(err, ShouldNotBeNil)
+			for _, host := range hosts[1:] {
+				So(err.Error(), ShouldContainSubstring, host)
+			}
+		})
+		Convey("Test Nothing Starts Once Canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			ss := newServer(len(hosts), 0)
+			for i := 0; i < 10; i++ {
+				failures := ss.forEachHost(ctx, func(context.Context, string) error {
+					t.Error("fn was called with a canceled context")
+					return nil
+				})
+				So(failures, ShouldHaveLength, len(hosts))
+			}
+		})
+	})
+	Convey("Test Start() With RequireCertsOnStart", t, func() {
+		newServer := func(cache *memCache) *SecureServer {
+			ss, err := NewServer(ServerConfig{
+				Handler:             http.NotFoundHandler(),
+				Hostnames:           []string{"yourdomain.io"},
+				CertCache:           cache,
+				OfflineMode:         true,
+				HTTPPort:            ":0",
+				HTTPSPort:           ":0",
+				RequireCertsOnStart: true,
+				CertStartTimeout:    time.Second,
+			})
+			So(err, ShouldBeNil)
+			return ss
+		}
+		Convey("Test Start Fails Without Certificates", func() {
+			ss := newServer(newMemCache())
+			So(ss.Start(), ShouldEqual, ErrNoCertificates)
+		})
+		Convey("Test Start Succeeds With Certificates", func() {
+			cache := newMemCache()
+			cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+			ss := newServer(cache)
+			So(ss.Start(), ShouldBeNil)
+			So(ss.close(), ShouldBeNil)
+		})
+	})
+	Convey("Test Start() With ConfirmCertsOnStart", t, func() {
+		logger := &testLogger{}
+		httpPort, httpsPort := freePort(), freePort()
+		newServer := func(sslRequired bool) *SecureServer {
+			ss, err := NewServer(ServerConfig{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusTeapot)
+				}),
+				Hostnames:           []string{"yourdomain.io"},
+				CertCache:           newMemCache(),
+				OfflineMode:         true,
+				HTTPPort:            httpPort,
+				HTTPSPort:           httpsPort,
+				PlainHTTPMode:       RedirectToHTTPS,
+				Logger:              logger,
+				ConfirmCertsOnStart: true,
+				SSLRequired:         sslRequired,
+				CertStartTimeout:    time.Second,
+			})
+			So(err, ShouldBeNil)
+			return ss
+		}
+		Convey("Test Server Falls Back To Plain HTTP", func() {
+			ss := newServer(false)
+			So(ss.Start(), ShouldBeNil)
+			defer ss.close()
+			So(logger.String(), ShouldContainSubstring, "falling back to serving plain HTTP only")
+			resp, err := http.Get("http://localhost" + httpPort)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusTeapot)
+			_, err = net.Dial("tcp", "localhost"+httpsPort)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Test Start Fails When SSL Is Required", func() {
+			ss := newServer(true)
+			So(ss.Start(), ShouldEqual, ErrNoCertificates)
+		})
+	})
+	Convey("Test failureTracker", t, func() {
+		var counts []int
+		hook := func(host string, err error, n int) { counts = append(counts, n) }
+		ft := &failureTracker{}
+		ft.record("yourdomain.io", errors.New("fail"), hook)
+		ft.record("yourdomain.io", errors.New("fail"), hook)
+		ft.record("otherdomain.io", errors.New("fail"), hook)
+		ft.record("yourdomain.io", nil, hook)
+		ft.record("yourdomain.io", errors.New("fail"), hook)
+		So(counts, ShouldResemble, []int{1, 2, 1, 1})
+	})
+	Convey("Test OnRenewalFailure", t, func() {
+		ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}))
+		defer ca.Close()
+		var failures []int
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io"},
+			CertCache: newMemCache(),
+			OnRenewalFailure: func(host string, err error, n int) {
+				So(host, ShouldEqual, "yourdomain.io")
+				failures = append(failures, n)
+			},
+		})
+		So(err, ShouldBeNil)
+		ss.certMgr.Client = unreachableCA()
+		ss.getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+		ss.getCertificate(&tls.ClientHelloInfo{ServerName: "YourDomain.io"})
+		ss.getCertificate(&tls.ClientHelloInfo{ServerName: "otherdomain.io"})
+		So(failures, ShouldResemble, []int{1, 2})
+	})
+	Convey("Test OnServingStaleCert", t, func() {
+		now := time.Now()
+		cache := newMemCache()
+		var stale []string
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io", "otherdomain.io", "expired.io"},
+			CertCache: cache,
+			OnServingStaleCert: func(host string, expiresIn time.Duration) {
+				So(expiresIn, ShouldBeGreaterThan, 0)
+				stale = append(stale, host)
+			},
+		})
+		So(err, ShouldBeNil)
+		ss = withCertManager(ss, certFetcherFunc(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return nil, errors.New("ca is down")
+		}))
+		cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-80*24*time.Hour), now.Add(24*time.Hour)))
+		cache.Put(context.Background(), "expired.io", testCacheEntry("expired.io", now.Add(-2*time.Hour), now.Add(-time.Hour)))
+		hello := func(host string) *tls.ClientHelloInfo {
+			return &tls.ClientHelloInfo{
+				ServerName:        host,
+				SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+				SupportedCurves:   []tls.CurveID{tls.CurveP256},
+				SupportedVersions: []uint16{tls.VersionTLS13},
+			}
+		}
+		Convey("Test Valid Cached Certificate Is Served", func() {
+			cert, err := ss.getCertificate(hello("yourdomain.io"))
+			So(err, ShouldBeNil)
+			So(cert.Leaf.DNSNames, ShouldContain, "yourdomain.io")
+			So(stale, ShouldResemble, []string{"yourdomain.io"})
+		})
+		Convey("Test Handshake Fails Without A Valid Cached Certificate", func() {
+			_, err := ss.getCertificate(hello("otherdomain.io"))
+			So(err, ShouldNotBeNil)
+			_, err = ss.getCertificate(hello("expired.io"))
+			So(err, ShouldNotBeNil)
+			So(stale, ShouldBeEmpty)
+		})
+	})
+	Convey("Test OnExpiryWarning", t, func() {
+		cache := newMemCache()
+		cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(48*time.Hour)))
+		cache.Put(context.Background(), "otherdomain.io", testCacheEntry("otherdomain.io", now.Add(-time.Hour), now.Add(30*24*time.Hour)))
+		warnings := make(map[string]time.Duration)
+		newServer := func(threshold time.Duration) *SecureServer {
+			ss, err := NewServer(ServerConfig{
+				Handler:                http.NotFoundHandler(),
+				Hostnames:              []string{"yourdomain.io", "otherdomain.io"},
+				CertCache:              cache,
+				OfflineMode:            true,
+				ExpiryWarningThreshold: threshold,
+				OnExpiryWarning: func(host string, expiresIn time.Duration) {
+					warnings[host] = expiresIn
+				},
+			})
+			So(err, ShouldBeNil)
+			return ss
+		}
+		Convey("Test Certificates Expiring Soon Are Reported", func() {
+			ss := newServer(7 * 24 * time.Hour)
+			for _, host := range []string{"YourDomain.io", "otherdomain.io"} {
+				_, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: host})
+				So(err, ShouldBeNil)
+			}
+			So(warnings, ShouldHaveLength, 1)
+			So(warnings["yourdomain.io"], ShouldBeBetween, 47*time.Hour, 48*time.Hour)
+		})
+		Convey("Test Expiry Is Not Checked By Default", func() {
+			_, err := newServer(0).getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+			So(err, ShouldBeNil)
+			So(warnings, ShouldBeEmpty)
+		})
+		Convey("Test Certificates Without Leaf Are Checked", func() {
+			certPEM, keyPEM := testCertPEM("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour))
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			So(err, ShouldBeNil)
+			cert.Leaf = nil
+			newServer(7*24*time.Hour).checkExpiry("yourdomain.io", &cert)
+			So(warnings["yourdomain.io"], ShouldBeLessThanOrEqualTo, time.Hour)
+		})
+	})
+	Convey("Test LogCertFingerprints", t, func() {
+		cache := newMemCache()
+		cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+		cache.Put(context.Background(), "otherdomain.io", testCacheEntry("otherdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+		newServer := func(logger Logger, enabled bool) *SecureServer {
+			ss, err := NewServer(ServerConfig{
+				Handler:             http.NotFoundHandler(),
+				Hostnames:           []string{"yourdomain.io", "otherdomain.io"},
+				CertCache:           cache,
+				OfflineMode:         true,
+				Logger:              logger,
+				LogCertFingerprints: enabled,
+			})
+			So(err, ShouldBeNil)
+			return ss
+		}
+		Convey("Test Each Certificate Is Logged Once", func() {
+			logger := &testLogger{}
+			ss := newServer(logger, true)
+			var served *tls.Certificate
+			for _, host := range []string{"yourdomain.io", "YourDomain.io", "yourdomain.io", "otherdomain.io"} {
+				cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: host})
+				So(err, ShouldBeNil)
+				if served == nil {
+					served = cert
+				}
+			}
+			sum := sha256.Sum256(served.Certificate[0])
+			So(logger.lines, ShouldHaveLength, 2)
+			So(logger.lines[0], ShouldStartWith, "[sslmgr] serving certificate host=yourdomain.io sha256="+hex.EncodeToString(sum[:])+" serial="+served.Leaf.SerialNumber.Text(16)+" sans=yourdomain.io ")
+			So(logger.lines[1], ShouldContainSubstring, "host=otherdomain.io")
+		})
+		Convey("Test Events Reach CertLogger", func() {
+			logger := &certLogger{}
+			_, err := newServer(logger, true).getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+			So(err, ShouldBeNil)
+			So(logger.events, ShouldHaveLength, 1)
+			So(logger.events[0].SANs, ShouldResemble, []string{"yourdomain.io"})
+			So(logger.lines, ShouldBeEmpty)
+		})
+		Convey("Test Certificates Are Not Logged By Default", func() {
+			logger := &testLogger{}
+			_, err := newServer(logger, false).getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+			So(err, ShouldBeNil)
+			So(logger.lines, ShouldBeEmpty)
+		})
+	})
+	Convey("Test ImportCert()", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:     http.NotFoundHandler(),
+			Hostnames:   []string{"yourdomain.io", "otherdomain.io"},
+			CertCache:   newMemCache(),
+			OfflineMode: true,
+		})
+		So(err, ShouldBeNil)
+		certPEM, keyPEM := testCertPEM("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour))
+		Convey("Test Imported Cert Is Served", func() {
+			So(ss.ImportCert(context.Background(), "yourdomain.io", certPEM, keyPEM), ShouldBeNil)
+			cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+			So(err, ShouldBeNil)
+			So(cert.Certificate, ShouldHaveLength, 1)
+		})
+		Convey("Test Hostname Mismatch Is Rejected", func() {
+			So(ss.ImportCert(context.Background(), "otherdomain.io", certPEM, keyPEM), ShouldNotBeNil)
+		})
+		Convey("Test Unapproved Hostname Is Rejected", func() {
+			So(ss.ImportCert(context.Background(), "notmydomain.io", certPEM, keyPEM), ShouldNotBeNil)
+		})
+		Convey("Test Mismatched Key Is Rejected", func() {
+			_, otherKeyPEM := testCertPEM("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour))
+			So(ss.ImportCert(context.Background(), "yourdomain.io", certPEM, otherKeyPEM), ShouldNotBeNil)
+		})
+	})
+	Convey("Test acmeClient()", t, func() {
+		Convey("Test Default Client Without Resolver", func() {
+			So(acmeClient(ServerConfig{}), ShouldBeNil)
+		})
+		Convey("Test Resolver Is Used For ACME Connections", func() {
+			resolved := make(chan struct{}, 1)
+			client := acmeClient(ServerConfig{ACMEResolver: &net.Resolver{
+				PreferGo: true,
+				Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+					select {
+					case resolved <- struct{}{}:
+					default:
+					}
+					return nil, errors.New("no dns in tests")
+				},
+			}})
+			So(client, ShouldNotBeNil)
+			So(client.DirectoryURL, ShouldEqual, autocert.DefaultACMEDirectory)
+			_, err := client.HTTPClient.Get("http://acme.yourdomain.io/directory")
+			So(err, ShouldNotBeNil)
+			So(resolved, ShouldHaveLength, 1)
+		})
+		Convey("Test AccountKey Is Used", func() {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			So(err, ShouldBeNil)
+			ss, err := NewServer(ServerConfig{
+				Handler:    http.NotFoundHandler(),
+				Hostnames:  []string{"yourdomain.io"},
+				AccountKey: key,
+			})
+			So(err, ShouldBeNil)
+			So(ss.certMgr.Client.Key, ShouldEqual, key)
+			So(ss.certMgr.Client.HTTPClient, ShouldBeNil)
+		})
+		Convey("Test Timeouts Are Applied", func() {
+			client := acmeClient(ServerConfig{ACMEDialTimeout: time.Second, ACMERequestTimeout: 5 * time.Second})
+			So(client, ShouldNotBeNil)
+			So(client.HTTPClient.Timeout, ShouldEqual, 5*time.Second)
+		})
+		Convey("Test ACMEServerRootCAs Are The Only Trusted Roots", func() {
+			ca := httptest.NewTLSServer(http.NotFoundHandler())
+			defer ca.Close()
+			pinned := x509.NewCertPool()
+			pinned.AddCert(ca.Certificate())
+			client := acmeClient(ServerConfig{ACMEDirectoryURL: ca.URL + "/directory", ACMEServerRootCAs: pinned})
+			So(client.DirectoryURL, ShouldEqual, ca.URL+"/directory")
+			resp, err := client.HTTPClient.Get(ca.URL)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			client = acmeClient(ServerConfig{ACMEServerRootCAs: x509.NewCertPool()})
+			_, err = client.HTTPClient.Get(ca.URL)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Test Request Timeout Bounds Hung Requests", func() {
+			hung := make(chan struct{})
+			ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-hung
+			}))
+			defer ca.Close()
+			defer close(hung)
+			client := acmeClient(ServerConfig{ACMERequestTimeout: 50 * time.Millisecond})
+			start := time.Now()
+			_, err := client.HTTPClient.Get(ca.URL)
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+	})
+	Convey("Test OnTOSAccepted", t, func() {
+		logger := &testLogger{}
+		var accepted []string
+		newServer := func(prompt func(string) bool) *SecureServer {
+			ss, err := NewServer(ServerConfig{
+				Handler:   http.NotFoundHandler(),
+				Hostnames: []string{"yourdomain.io"},
+				Logger:    logger,
+				Prompt:    prompt,
+				OnTOSAccepted: func(tosURL string, at time.Time) {
+					So(at, ShouldHappenWithin, time.Minute, time.Now())
+					accepted = append(accepted, tosURL)
+				},
+			})
+			So(err, ShouldBeNil)
+			return ss
+		}
+		Convey("Test Acceptance Is Reported And Logged", func() {
+			So(newServer(nil).certMgr.Prompt("https://ca.io/tos"), ShouldBeTrue)
+			So(accepted, ShouldResemble, []string{"https://ca.io/tos"})
+			So(logger.String(), ShouldContainSubstring, "https://ca.io/tos")
+			So(logger.String(), ShouldContainSubstring, autocert.DefaultACMEDirectory)
+		})
+		Convey("Test Refusal Is Not Reported", func() {
+			refuse := func(string) bool { return false }
+			So(newServer(refuse).certMgr.Prompt("https://ca.io/tos"), ShouldBeFalse)
+			So(accepted, ShouldBeEmpty)
+			So(logger.String(), ShouldBeEmpty)
+		})
+	})
+	Convey("Test CertManager()", t, func() {
+		now := time.Now()
+		cache := newMemCache()
+		cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io"},
+			CertCache: cache,
+		})
+		So(err, ShouldBeNil)
+		mgr := ss.CertManager()
+		So(mgr, ShouldEqual, ss.certMgr)
+		cert, err := mgr.TLSConfig().GetCertificate(&tls.ClientHelloInfo{
+			ServerName:       "yourdomain.io",
+			SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			SupportedCurves:  []tls.CurveID{tls.CurveP256},
+			CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		})
+		So(err, ShouldBeNil)
+		So(cert.Leaf.DNSNames, ShouldResemble, []string{"yourdomain.io"})
+	})
+	// offersHTTP01 reports whether autocert attempts HTTP-01 challenges,
+	// which it does once its HTTPHandler was built
+	offersHTTP01 := func(m *autocert.Manager) bool {
+		return reflect.ValueOf(m).Elem().FieldByName("tryHTTP01").Bool()
+	}
+	Convey("Test ChallengeTypes", t, func() {
+		newServer := func(types []string) (*SecureServer, error) {
+			return NewServer(ServerConfig{
+				Handler:        http.NotFoundHandler(),
+				Hostnames:      []string{"yourdomain.io"},
+				ChallengeTypes: types,
+			})
+		}
+		Convey("Test Both Types By Default", func() {
+			ss, err := newServer(nil)
+			So(err, ShouldBeNil)
+			So(ss.http01, ShouldBeTrue)
+			So(ss.tlsConfig().NextProtos, ShouldContain, acme.ALPNProto)
+		})
+		Convey("Test TLS-ALPN-01 Only", func() {
+			ss, err := newServer([]string{ChallengeTLSALPN01})
+			So(err, ShouldBeNil)
+			So(ss.http01, ShouldBeFalse)
+			So(offersHTTP01(ss.certMgr), ShouldBeFalse)
+			So(ss.tlsConfig().NextProtos, ShouldContain, acme.ALPNProto)
+		})
+		Convey("Test HTTP-01 Is Only Offered When Selected With An HTTPChallengePort", func() {
+			newServer := func(types []string) *SecureServer {
+				ss, err := NewServer(ServerConfig{
+					Handler:           http.NotFoundHandler(),
+					Hostnames:         []string{"yourdomain.io"},
+					ChallengeTypes:    types,
+					HTTPChallengePort: freePort(),
+				})
+				So(err, ShouldBeNil)
+				return ss
+			}
+			ss := newServer([]string{ChallengeTLSALPN01})
+			So(ss.challengeServer, ShouldBeNil)
+			So(offersHTTP01(ss.certMgr), ShouldBeFalse)
+			ss = newServer(nil)
+			So(ss.challengeServer, ShouldNotBeNil)
+			So(offersHTTP01(ss.certMgr), ShouldBeTrue)
+		})
+		Convey("Test HTTP-01 Only", func() {
+			ss, err := newServer([]string{ChallengeHTTP01})
+			So(err, ShouldBeNil)
+			So(ss.http01, ShouldBeTrue)
+			So(ss.tlsConfig().NextProtos, ShouldNotContain, acme.ALPNProto)
+			_, err = ss.getCertificate(&tls.ClientHelloInfo{
+				ServerName:      "yourdomain.io",
+				SupportedProtos: []string{acme.ALPNProto},
+			})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Test TLS-ALPN-01 Authorizations Are Hidden From autocert", func() {
+			ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, `{"status":"pending","challenges":[`+
+					`{"type":"tls-alpn-01","url":"https://ca/1"},`+
+					`{"type":"http-01","url":"https://ca/2"}]}`)
+			}))
+			defer ca.Close()
+			authz := func(ss *SecureServer) string {
+				httpClient := http.DefaultClient
+				if ss.certMgr.Client != nil && ss.certMgr.Client.HTTPClient != nil {
+					httpClient = ss.certMgr.Client.HTTPClient
+				}
+				resp, err := httpClient.Get(ca.URL)
+				So(err, ShouldBeNil)
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				So(err, ShouldBeNil)
+				So(resp.ContentLength, ShouldEqual, len(body))
+				return string(body)
+			}
+			ss, err := newServer([]string{ChallengeHTTP01})
+			So(err, ShouldBeNil)
+			So(authz(ss), ShouldNotContainSubstring, ChallengeTLSALPN01)
+			So(authz(ss), ShouldContainSubstring, ChallengeHTTP01)
+			ss, err = newServer(nil)
+			So(err, ShouldBeNil)
+			So(authz(ss), ShouldContainSubstring, ChallengeTLSALPN01)
+		})
+		Convey("Test Invalid Types Are Rejected", func() {
+			_, err := newServer([]string{})
+			So(err, ShouldEqual, ErrInvalidChallengeTypes)
+			_, err = newServer([]string{ChallengeHTTP01, "dns-01"})
+			So(errors.Is(err, ErrInvalidChallengeTypes), ShouldBeTrue)
+		})
+	})
+}
+
+// withCertManager makes ss obtain the certificates it serves from f
+// rather than from the ACME CA, e.g. with a fakeCertManager
+func withCertManager(ss *SecureServer, f certFetcher) *SecureServer {
+	ss.certFetcher = f
+	ss.newRenewer = func() certFetcher { return f }
+	return ss
+}
+
+// fakeCertManager issues self-signed certificates on demand, each one
+// valid from a second after the previous one
+type fakeCertManager struct {
+	sync.Mutex
+	issued []string
+}
+
+func (fm *fakeCertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	fm.Lock()
+	fm.issued = append(fm.issued, hello.ServerName)
+	notBefore := time.Now().Add(-time.Hour).Add(time.Duration(len(fm.issued)) * time.Second)
+	fm.Unlock()
+	certPEM, keyPEM := testCertPEM(hello.ServerName, notBefore, time.Now().Add(time.Hour))
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	return &cert, err
+}
+
+// unreachableCA returns an ACME client whose requests fail immediately
+func unreachableCA() *acme.Client {
+	ca := httptest.NewServer(http.NotFoundHandler())
+	ca.Close()
+	return &acme.Client{DirectoryURL: ca.URL}
+}
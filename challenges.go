@@ -0,0 +1,177 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// challengePathPrefix is the path under which HTTP-01 challenges are served
+const challengePathPrefix = "/.well-known/acme-challenge/"
+
+// alpnTokenSuffix ends the cache keys autocert stores TLS-ALPN-01
+// challenge certificates at
+const alpnTokenSuffix = "+token"
+
+// pendingChallenge is an HTTP-01 challenge token autocert has cached
+type pendingChallenge struct {
+	created     time.Time
+	requests    int
+	lastRequest time.Time
+}
+
+// challengeTracker keeps track of the HTTP-01 challenge tokens autocert
+// has pending, which it does not expose, and of the challenge requests
+// received for them, as well as of the hostnames with a TLS-ALPN-01
+// challenge pending
+type challengeTracker struct {
+	sync.Mutex
+	pending map[string]*pendingChallenge
+	unknown int // requests for tokens which were not pending
+	alpn    map[string]bool
+}
+
+// setALPN records whether host has a TLS-ALPN-01 challenge pending
+func (ct *challengeTracker) setALPN(host string, pending bool) {
+	ct.Lock()
+	defer ct.Unlock()
+	if ct.alpn == nil {
+		ct.alpn = make(map[string]bool)
+	}
+	if pending {
+		ct.alpn[host] = true
+	} else {
+		delete(ct.alpn, host)
+	}
+}
+
+// alpnPending reports whether host has a TLS-ALPN-01 challenge pending
+func (ct *challengeTracker) alpnPending(host string) bool {
+	ct.Lock()
+	defer ct.Unlock()
+	return ct.alpn[host]
+}
+
+// put records token as pending
+func (ct *challengeTracker) put(token string) {
+	ct.Lock()
+	defer ct.Unlock()
+	if ct.pending == nil {
+		ct.pending = make(map[string]*pendingChallenge)
+	}
+	ct.pending[token] = &pendingChallenge{created: time.Now()}
+}
+
+// remove records token as no longer pending
+func (ct *challengeTracker) remove(token string) {
+	ct.Lock()
+	defer ct.Unlock()
+	delete(ct.pending, token)
+}
+
+// request records a challenge request for token
+func (ct *challengeTracker) request(token string) {
+	ct.Lock()
+	defer ct.Unlock()
+	pc, ok := ct.pending[token]
+	if !ok {
+		ct.unknown++
+		return
+	}
+	pc.requests++
+	pc.lastRequest = time.Now()
+}
+
+// observe wraps h, the HTTP-01 challenge handler, recording every
+// challenge request it receives
+func (ct *challengeTracker) observe(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := strings.TrimPrefix(r.URL.Path, challengePathPrefix); token != r.URL.Path {
+			ct.request(token)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// challengeTrackingCache records the HTTP-01 challenge tokens autocert
+// puts in and deletes from the cache
+type challengeTrackingCache struct {
+	autocert.Cache
+	tracker *challengeTracker
+}
+
+func (cc *challengeTrackingCache) Put(ctx context.Context, key string, data []byte) error {
+	if err := cc.Cache.Put(ctx, key, data); err != nil {
+		return err
+	}
+	if token := strings.TrimSuffix(key, httpTokenSuffix); token != key {
+		cc.tracker.put(token)
+	}
+	if host := strings.TrimSuffix(key, alpnTokenSuffix); host != key {
+		cc.tracker.setALPN(normalizeHost(host), true)
+	}
+	return nil
+}
+
+func (cc *challengeTrackingCache) Delete(ctx context.Context, key string) error {
+	if token := strings.TrimSuffix(key, httpTokenSuffix); token != key {
+		cc.tracker.remove(token)
+	}
+	if host := strings.TrimSuffix(key, alpnTokenSuffix); host != key {
+		cc.tracker.setALPN(normalizeHost(host), false)
+	}
+	return cc.Cache.Delete(ctx, key)
+}
+
+// alpnChallengePending reports whether a TLS-ALPN-01 challenge is pending
+// for hello's hostname, whether by this server or by another instance
+// sharing its cache
+func (ss *SecureServer) alpnChallengePending(hello *tls.ClientHelloInfo) bool {
+	host := normalizeHost(hello.ServerName)
+	if ss.challenges.alpnPending(host) {
+		return true
+	}
+	ctx := hello.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, err := ss.certMgr.Cache.Get(ctx, host+alpnTokenSuffix)
+	return err == nil
+}
+
+// ChallengeDebugHandler returns a handler reporting, in plain text, the
+// HTTP-01 challenge tokens autocert currently has pending along with the
+// challenge requests received for each, and the number of challenge
+// requests received for tokens which were not pending. A pending token
+// the CA never requested points at the CA not reaching this server. The
+// handler is meant to be mounted on an internal listener of the user's
+// choosing
+func (ss *SecureServer) ChallengeDebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ss.challenges.Lock()
+		defer ss.challenges.Unlock()
+		tokens := make([]string, 0, len(ss.challenges.pending))
+		for token := range ss.challenges.pending {
+			tokens = append(tokens, token)
+		}
+		sort.Strings(tokens)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "pending tokens: %d\n", len(tokens))
+		for _, token := range tokens {
+			pc := ss.challenges.pending[token]
+			fmt.Fprintf(w, "%s created=%s requests=%d", token, pc.created.Format(time.RFC3339), pc.requests)
+			if pc.requests > 0 {
+				fmt.Fprintf(w, " last_request=%s", pc.lastRequest.Format(time.RFC3339))
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "requests for unknown tokens: %d\n", ss.challenges.unknown)
+	})
+}
@@ -0,0 +1,101 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/acme"
+)
+
+func TestChallengeDebugHandler(t *testing.T) {
+	Convey("Test ChallengeDebugHandler()", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io"},
+			CertCache: newMemCache(),
+		})
+		So(err, ShouldBeNil)
+		report := func() string {
+			rec := httptest.NewRecorder()
+			ss.ChallengeDebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			body, _ := io.ReadAll(rec.Body)
+			return string(body)
+		}
+		challenge := func(token string) {
+			h := ss.challenges.observe(ss.certMgr.HTTPHandler(nil))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://yourdomain.io"+challengePathPrefix+token, nil))
+		}
+		Convey("Test No Pending Tokens", func() {
+			So(report(), ShouldContainSubstring, "pending tokens: 0\n")
+		})
+		Convey("Test Cached Tokens Are Reported As Pending", func() {
+			So(ss.certMgr.Cache.Put(context.Background(), "tok1"+httpTokenSuffix, []byte("tok1.key")), ShouldBeNil)
+			So(ss.certMgr.Cache.Put(context.Background(), "yourdomain.io", []byte("cert")), ShouldBeNil)
+			body := report()
+			So(body, ShouldContainSubstring, "pending tokens: 1\n")
+			So(body, ShouldContainSubstring, "tok1 created=")
+			So(body, ShouldContainSubstring, "requests=0")
+		})
+		Convey("Test Challenge Requests Are Counted", func() {
+			So(ss.certMgr.Cache.Put(context.Background(), "tok1"+httpTokenSuffix, []byte("tok1.key")), ShouldBeNil)
+			challenge("tok1")
+			challenge("tok1")
+			challenge("tok2")
+			body := report()
+			So(body, ShouldContainSubstring, "requests=2 last_request=")
+			So(body, ShouldContainSubstring, "requests for unknown tokens: 1\n")
+		})
+		Convey("Test Deleted Tokens Are No Longer Pending", func() {
+			So(ss.certMgr.Cache.Put(context.Background(), "tok1"+httpTokenSuffix, []byte("tok1.key")), ShouldBeNil)
+			So(ss.certMgr.Cache.Delete(context.Background(), "tok1"+httpTokenSuffix), ShouldBeNil)
+			So(report(), ShouldContainSubstring, "pending tokens: 0\n")
+		})
+	})
+}
+
+func TestRestrictChallengeALPN(t *testing.T) {
+	Convey("Test RestrictChallengeALPN", t, func() {
+		cache := newMemCache()
+		ss, err := NewServer(ServerConfig{
+			Handler:               http.NotFoundHandler(),
+			Hostnames:             []string{"yourdomain.io"},
+			CertCache:             cache,
+			RestrictChallengeALPN: true,
+		})
+		So(err, ShouldBeNil)
+		config := ss.tlsConfig()
+		So(config.NextProtos, ShouldNotContain, acme.ALPNProto)
+		challengeConfig := func() *tls.Config {
+			c, err := config.GetConfigForClient(&tls.ClientHelloInfo{
+				ServerName:      "yourdomain.io",
+				SupportedProtos: []string{acme.ALPNProto},
+			})
+			So(err, ShouldBeNil)
+			return c
+		}
+		Convey("Test Challenge Protocol Is Not Negotiated Without A Challenge", func() {
+			So(challengeConfig(), ShouldBeNil)
+		})
+		Convey("Test Challenge Protocol Is Negotiated While A Challenge Is Pending", func() {
+			So(ss.certMgr.Cache.Put(context.Background(), "yourdomain.io+token", []byte("cert")), ShouldBeNil)
+			So(challengeConfig().NextProtos, ShouldContain, acme.ALPNProto)
+			So(config.NextProtos, ShouldNotContain, acme.ALPNProto)
+			So(ss.certMgr.Cache.Delete(context.Background(), "yourdomain.io+token"), ShouldBeNil)
+			So(challengeConfig(), ShouldBeNil)
+		})
+		Convey("Test Challenges Pending On Another Instance Are Seen", func() {
+			So(cache.Put(context.Background(), "yourdomain.io+token", []byte("cert")), ShouldBeNil)
+			So(challengeConfig().NextProtos, ShouldContain, acme.ALPNProto)
+		})
+		Convey("Test Regular Handshakes Are Unaffected", func() {
+			c, err := config.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+			So(err, ShouldBeNil)
+			So(c, ShouldBeNil)
+		})
+	})
+}
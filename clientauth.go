@@ -0,0 +1,116 @@
+package sslmgr
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"golang.org/x/crypto/acme"
+)
+
+// tlsConfig returns the TLS configuration of the HTTPS listener
+func (ss *SecureServer) tlsConfig() *tls.Config {
+	nextProtos := []string{"h2", "http/1.1"}
+	if ss.tlsALPN01 && !ss.restrictChallengeALPN {
+		// TLS-ALPN-01 challenges are answered by autocert's GetCertificate
+		nextProtos = append(nextProtos, acme.ALPNProto)
+	}
+	config := &tls.Config{
+		GetCertificate:        ss.getCertificate,
+		Certificates:          ss.certificates,
+		NextProtos:            nextProtos,
+		ClientAuth:            ss.clientAuth,
+		ClientCAs:             ss.clientCAs,
+		VerifyPeerCertificate: ss.clientCertVerifier,
+	}
+	if ss.getConfigForClient != nil || ss.restrictChallengeALPN || ss.hostTLSOverrides != nil {
+		config.GetConfigForClient = ss.configForClient(config)
+	}
+	return config
+}
+
+// configForClient returns the GetConfigForClient hook of the base config.
+// It negotiates the TLS-ALPN-01 challenge protocol only while a challenge
+// is pending with the RestrictChallengeALPN set, and wraps the
+// GetConfigForClient (if any) so that the configs it returns obtain
+// certificates, negotiate protocols and authenticate clients as sslmgr's
+// does, unless they say otherwise. The HostTLSOverrides apply when it
+// returns no config, and otherwise fill in the settings it leaves unset
+func (ss *SecureServer) configForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	nextProtos := base.NextProtos
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if isChallengeHello(hello) {
+			if !ss.restrictChallengeALPN || !ss.tlsALPN01 || !ss.alpnChallengePending(hello) {
+				return nil, nil
+			}
+			config := base.Clone()
+			config.GetConfigForClient = nil
+			config.NextProtos = append(nextProtos[:len(nextProtos):len(nextProtos)], acme.ALPNProto)
+			return config, nil
+		}
+		if ss.getConfigForClient == nil {
+			return ss.hostTLSConfig(base, hello), nil
+		}
+		config, err := ss.getConfigForClient(hello)
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			return ss.hostTLSConfig(base, hello), nil
+		}
+		config = config.Clone()
+		if config.GetCertificate == nil && len(config.Certificates) == 0 {
+			config.GetCertificate = ss.getCertificate
+			config.Certificates = ss.certificates
+		}
+		if len(config.NextProtos) == 0 {
+			config.NextProtos = nextProtos
+		}
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = base.ClientAuth
+		}
+		if config.ClientCAs == nil {
+			config.ClientCAs = base.ClientCAs
+		}
+		if config.VerifyPeerCertificate == nil {
+			config.VerifyPeerCertificate = base.VerifyPeerCertificate
+		}
+		if opts, ok := ss.hostTLSOverrides[normalizeHost(hello.ServerName)]; ok {
+			opts.fill(config)
+		}
+		return config, nil
+	}
+}
+
+// RevocationListVerifier returns a ClientCertVerifier rejecting client
+// certificates revoked by any of the given certificate revocation lists.
+// CRLs are matched to certificates by issuer, and must have already been
+// checked to be signed by it (see x509.RevocationList.CheckSignatureFrom)
+func RevocationListVerifier(crls ...*x509.RevocationList) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				if err := checkRevoked(cert, crls); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// checkRevoked returns an error if cert is revoked by any of the crls
+func checkRevoked(cert *x509.Certificate, crls []*x509.RevocationList) error {
+	for _, crl := range crls {
+		if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) {
+			continue
+		}
+		for _, revoked := range crl.RevokedCertificateEntries {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("certificate %s (serial %s) has been revoked", cert.Subject, cert.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package sslmgr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testClientCert returns a client certificate with the given serial
+// number issued by the given CA
+func testClientCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, serial int64) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		panic(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientAuth(t *testing.T) {
+	Convey("Test RevocationListVerifier()", t, func() {
+		caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		caTmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "client ca"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+		So(err, ShouldBeNil)
+		ca, err := x509.ParseCertificate(caDER)
+		So(err, ShouldBeNil)
+		crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:                    big.NewInt(1),
+			ThisUpdate:                time.Now(),
+			NextUpdate:                time.Now().Add(time.Hour),
+			RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(666), RevocationTime: time.Now()}},
+		}, ca, caKey)
+		So(err, ShouldBeNil)
+		crl, err := x509.ParseRevocationList(crlDER)
+		So(err, ShouldBeNil)
+
+		pool := x509.NewCertPool()
+		pool.AddCert(ca)
+		serverCert := testTLSConfig("yourdomain.io").Certificates[0]
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io"},
+			CertSelector: func(*tls.ClientHelloInfo) (*tls.Certificate, bool) {
+				return &serverCert, true
+			},
+			ClientAuth:         tls.RequireAndVerifyClientCert,
+			ClientCAs:          pool,
+			ClientCertVerifier: RevocationListVerifier(crl),
+		})
+		So(err, ShouldBeNil)
+		handshake := func(clientCert tls.Certificate) error {
+			serverConn, clientConn := net.Pipe()
+			defer serverConn.Close()
+			defer clientConn.Close()
+			serverErr := make(chan error, 1)
+			go func() {
+				serverErr <- tls.Server(serverConn, ss.tlsConfig()).Handshake()
+			}()
+			tls.Client(clientConn, &tls.Config{
+				ServerName:         "yourdomain.io",
+				InsecureSkipVerify: true,
+				Certificates:       []tls.Certificate{clientCert},
+			}).Handshake()
+			clientConn.Close()
+			return <-serverErr
+		}
+		Convey("Test Valid Client Certificate Is Accepted", func() {
+			So(handshake(testClientCert(ca, caKey, 42)), ShouldBeNil)
+		})
+		Convey("Test Revoked Client Certificate Is Rejected", func() {
+			err := handshake(testClientCert(ca, caKey, 666))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "revoked")
+		})
+	})
+}
+
+func TestGetConfigForClient(t *testing.T) {
+	Convey("Test GetConfigForClient", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:      http.NotFoundHandler(),
+			Hostnames:    []string{"yourdomain.io", "strict.yourdomain.io"},
+			Certificates: testTLSConfig("yourdomain.io").Certificates,
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				if hello.ServerName != "strict.yourdomain.io" {
+					return nil, nil
+				}
+				return &tls.Config{MinVersion: tls.VersionTLS13}, nil
+			},
+		})
+		So(err, ShouldBeNil)
+		handshake := func(serverName string, maxVersion uint16) (tls.ConnectionState, error) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				defer server.Close()
+				tls.Server(server, ss.tlsConfig()).Handshake()
+			}()
+			conn := tls.Client(client, &tls.Config{
+				ServerName:         serverName,
+				MaxVersion:         maxVersion,
+				NextProtos:         []string{"h2"},
+				InsecureSkipVerify: true,
+			})
+			err := conn.Handshake()
+			return conn.ConnectionState(), err
+		}
+		Convey("Test Returned Config Is Applied", func() {
+			_, err := handshake("strict.yourdomain.io", tls.VersionTLS12)
+			So(err, ShouldNotBeNil)
+			state, err := handshake("strict.yourdomain.io", tls.VersionTLS13)
+			So(err, ShouldBeNil)
+			So(state.PeerCertificates, ShouldNotBeEmpty)
+			So(state.NegotiatedProtocol, ShouldEqual, "h2")
+		})
+		Convey("Test Nil Config Keeps sslmgr's", func() {
+			_, err := handshake("yourdomain.io", tls.VersionTLS12)
+			So(err, ShouldBeNil)
+		})
+		Convey("Test Returned Config Keeps Client Authentication", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:      http.NotFoundHandler(),
+				Hostnames:    []string{"strict.yourdomain.io"},
+				Certificates: testTLSConfig("strict.yourdomain.io").Certificates,
+				ClientAuth:   tls.RequireAnyClientCert,
+				GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+					return &tls.Config{MinVersion: tls.VersionTLS13}, nil
+				},
+			})
+			So(err, ShouldBeNil)
+			handshake := func(certs []tls.Certificate) error {
+				client, server := net.Pipe()
+				result := make(chan error, 1)
+				go func() {
+					defer server.Close()
+					result <- tls.Server(server, ss.tlsConfig()).Handshake()
+				}()
+				conn := tls.Client(client, &tls.Config{
+					ServerName:         "strict.yourdomain.io",
+					Certificates:       certs,
+					InsecureSkipVerify: true,
+				})
+				conn.Handshake()
+				// read the server's verdict on the client certificate
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				conn.Read(make([]byte, 1))
+				client.Close()
+				return <-result
+			}
+			So(handshake(nil), ShouldNotBeNil)
+			So(handshake(testTLSConfig("client").Certificates), ShouldBeNil)
+		})
+		Convey("Test Challenge Hellos Are Not Passed On", func() {
+			config, err := ss.tlsConfig().GetConfigForClient(&tls.ClientHelloInfo{
+				ServerName:      "strict.yourdomain.io",
+				SupportedProtos: []string{"acme-tls/1"},
+			})
+			So(err, ShouldBeNil)
+			So(config, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,216 @@
+package sslmgr
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// defaultCompressionMinSize is the smallest response body compressed when
+// no MinSize is configured. Smaller bodies don't compress meaningfully
+const defaultCompressionMinSize = 1024
+
+// defaultCompressibleTypes are the content types compressed when no
+// ContentTypes allowlist is configured
+var defaultCompressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// CompressionConfig holds configuration for transparent response compression
+type CompressionConfig struct {
+	// Enabled turns on gzip/deflate compression of eligible responses
+	// for clients advertising support through Accept-Encoding
+	Enabled bool
+
+	// MinSize is the smallest response body, in bytes, worth compressing
+	// Default value is 1024
+	MinSize int
+
+	// ContentTypes lists the content types (or prefixes such as "text/")
+	// eligible for compression. Types which are already compressed,
+	// like images and archives, should not be listed
+	// Default value is text, JSON, JavaScript, XML and SVG
+	ContentTypes []string
+}
+
+// withCompression compresses eligible responses with the encoding preferred
+// by the client, passing everything else (including protocol upgrades such
+// as WebSockets) through untouched
+func withCompression(h http.Handler, c CompressionConfig) http.Handler {
+	if c.MinSize <= 0 {
+		c.MinSize = defaultCompressionMinSize
+	}
+	if len(c.ContentTypes) == 0 {
+		c.ContentTypes = defaultCompressibleTypes
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, config: c, encoding: encoding}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding returns the supported encoding the client accepts,
+// preferring gzip, or an empty string if there is none
+func acceptedEncoding(header string) string {
+	var deflate bool
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(fields) > 1 && strings.ReplaceAll(strings.TrimSpace(fields[1]), " ", "") == "q=0" {
+			continue
+		}
+		switch name {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+// compressWriter buffers the start of a response until it can decide
+// whether the response is worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	config   CompressionConfig
+	encoding string
+	status   int
+	buf      bytes.Buffer
+	decided  bool
+	out      io.Writer
+	closer   io.WriteCloser
+	hijacked bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.decided {
+		return cw.out.Write(p)
+	}
+	cw.buf.Write(p)
+	if cw.buf.Len() >= cw.config.MinSize {
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends whatever has been written so far to the client
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide()
+	}
+	if f, ok := cw.closer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection, after which nothing
+// is written to the response
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(cw.ResponseWriter).Hijack()
+	if err == nil {
+		cw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide writes the response header, compressing the body from then
+// on if the response is eligible, and writes out any buffered body
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.out = cw.ResponseWriter
+	if cw.compressible() {
+		hdr := cw.Header()
+		hdr.Del("Content-Length")
+		hdr.Set("Content-Encoding", cw.encoding)
+		if cw.encoding == "gzip" {
+			cw.closer = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.closer, _ = flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+		}
+		cw.out = cw.closer
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	_, err := cw.out.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+	return err
+}
+
+// compressible reports whether the response should be compressed
+func (cw *compressWriter) compressible() bool {
+	if cw.buf.Len() < cw.config.MinSize {
+		return false
+	}
+	if cw.status < http.StatusOK || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+	hdr := cw.Header()
+	if hdr.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := hdr.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(cw.buf.Bytes())
+		hdr.Set("Content-Type", contentType)
+	}
+	for _, allowed := range cw.config.ContentTypes {
+		if strings.HasPrefix(contentType, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// close flushes any buffered body and terminates the compressed stream
+func (cw *compressWriter) close() {
+	if cw.hijacked {
+		return
+	}
+	if !cw.decided {
+		if cw.status == 0 && cw.buf.Len() == 0 {
+			return // nothing was written, let net/http write its default
+		}
+		cw.decide()
+	}
+	if cw.closer != nil {
+		cw.closer.Close()
+	}
+}
@@ -0,0 +1,92 @@
+package sslmgr
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompression(t *testing.T) {
+	body := strings.Repeat("hello world ", 200)
+	serve := func(h http.Handler, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		withCompression(h, CompressionConfig{Enabled: true}).ServeHTTP(rec, req)
+		return rec
+	}
+	text := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, body)
+	})
+	Convey("Test withCompression()", t, func() {
+		Convey("Test Eligible Response Is Gzipped", func() {
+			rec := serve(text, "deflate, gzip")
+			So(rec.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+			So(rec.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+			zr, err := gzip.NewReader(rec.Body)
+			So(err, ShouldBeNil)
+			decoded, err := io.ReadAll(zr)
+			So(err, ShouldBeNil)
+			So(string(decoded), ShouldEqual, body)
+		})
+		Convey("Test Deflate Is Used When Gzip Is Refused", func() {
+			rec := serve(text, "gzip;q=0, deflate")
+			So(rec.Header().Get("Content-Encoding"), ShouldEqual, "deflate")
+		})
+		Convey("Test Client Without Support", func() {
+			rec := serve(text, "")
+			So(rec.Header().Get("Content-Encoding"), ShouldBeEmpty)
+			So(rec.Body.String(), ShouldEqual, body)
+		})
+		Convey("Test Small Response Is Not Compressed", func() {
+			rec := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, "tiny")
+			}), "gzip")
+			So(rec.Code, ShouldEqual, http.StatusCreated)
+			So(rec.Header().Get("Content-Encoding"), ShouldBeEmpty)
+			So(rec.Body.String(), ShouldEqual, "tiny")
+		})
+		Convey("Test Compressed Content Type Is Skipped", func() {
+			rec := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				io.WriteString(w, body)
+			}), "gzip")
+			So(rec.Header().Get("Content-Encoding"), ShouldBeEmpty)
+			So(rec.Body.String(), ShouldEqual, body)
+		})
+		Convey("Test Already Encoded Response Is Skipped", func() {
+			rec := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Encoding", "br")
+				io.WriteString(w, body)
+			}), "gzip")
+			So(rec.Header().Get("Content-Encoding"), ShouldEqual, "br")
+			So(rec.Body.String(), ShouldEqual, body)
+		})
+		Convey("Test Upgrades Are Skipped", func() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			rec := httptest.NewRecorder()
+			withCompression(text, CompressionConfig{Enabled: true}).ServeHTTP(rec, req)
+			So(rec.Header().Get("Content-Encoding"), ShouldBeEmpty)
+			So(rec.Body.String(), ShouldEqual, body)
+		})
+		Convey("Test Connections Can Be Hijacked", func() {
+			body, err := hijackedBody(withCompression(hijackingHandler, CompressionConfig{Enabled: true}))
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "hijacked")
+		})
+	})
+}
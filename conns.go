@@ -0,0 +1,182 @@
+package sslmgr
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connTracker counts the server's open connections through
+// the http.Server.ConnState callback. With an idleTimeout set, it also
+// closes new connections on which no request is received in time, and
+// with trackHijacked set it keeps track of hijacked connections until
+// they are closed
+type connTracker struct {
+	open          int64
+	progressed    int64 // unix nanoseconds of the last completed request
+	idleTimeout   time.Duration
+	idle          sync.Map // net.Conn -> *time.Timer
+	trackHijacked bool
+	hijackedOpen  int64
+	hijacked      sync.Map // *hijackableConn -> struct{}
+}
+
+// trackConnState is the http.Server.ConnState hook used by the server
+func (ct *connTracker) trackConnState(c net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		atomic.AddInt64(&ct.open, 1)
+		if ct.idleTimeout > 0 {
+			ct.idle.Store(c, time.AfterFunc(ct.idleTimeout, func() { c.Close() }))
+		}
+	case http.StateActive:
+		ct.stopIdleTimer(c)
+	case http.StateIdle:
+		ct.progress()
+	case http.StateHijacked, http.StateClosed:
+		if state == http.StateHijacked && ct.trackHijacked {
+			if hc := asHijackable(c); hc != nil {
+				ct.hijacked.Store(hc, struct{}{})
+				atomic.AddInt64(&ct.hijackedOpen, 1)
+			}
+		}
+		atomic.AddInt64(&ct.open, -1)
+		ct.stopIdleTimer(c)
+		ct.progress()
+	}
+}
+
+// stopIdleTimer spares c from being closed for never receiving a request
+func (ct *connTracker) stopIdleTimer(c net.Conn) {
+	if ct.idleTimeout == 0 {
+		return
+	}
+	if t, ok := ct.idle.LoadAndDelete(c); ok {
+		t.(*time.Timer).Stop()
+	}
+}
+
+// openConns returns the number of connections currently open
+func (ct *connTracker) openConns() int {
+	return int(atomic.LoadInt64(&ct.open))
+}
+
+// hijackedConns returns the number of hijacked connections still open
+func (ct *connTracker) hijackedConns() int {
+	return int(atomic.LoadInt64(&ct.hijackedOpen))
+}
+
+// closeHijacked closes every hijacked connection still open
+func (ct *connTracker) closeHijacked() {
+	ct.hijacked.Range(func(c, _ any) bool {
+		c.(*hijackableConn).Close()
+		return true
+	})
+}
+
+// awaitHijacked waits for every hijacked connection to be closed, giving
+// up when ctx is done
+func (ct *connTracker) awaitHijacked(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for ct.hijackedConns() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// hijackableListener wraps accepted connections so that the connTracker
+// can tell when they are closed after being hijacked, which net/http
+// stops reporting
+type hijackableListener struct {
+	net.Listener
+	conns *connTracker
+}
+
+// Accept returns the next connection, wrapped in a hijackableConn
+func (hl *hijackableListener) Accept() (net.Conn, error) {
+	c, err := hl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &hijackableConn{Conn: c, conns: hl.conns}, nil
+}
+
+// hijackableConn is a connection accepted by a hijackableListener
+type hijackableConn struct {
+	net.Conn
+	conns *connTracker
+}
+
+// Close closes the connection, no longer counting it as hijacked
+func (hc *hijackableConn) Close() error {
+	if _, ok := hc.conns.hijacked.LoadAndDelete(hc); ok {
+		atomic.AddInt64(&hc.conns.hijackedOpen, -1)
+		hc.conns.progress()
+	}
+	return hc.Conn.Close()
+}
+
+// asHijackable returns the hijackableConn underlying c, looking through
+// TLS connections, or nil if there is none
+func asHijackable(c net.Conn) *hijackableConn {
+	for c != nil {
+		if hc, ok := c.(*hijackableConn); ok {
+			return hc
+		}
+		inner, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		c = inner.NetConn()
+	}
+	return nil
+}
+
+// ActiveConnections returns the number of connections currently open on
+// the server's HTTP and HTTPS listeners (including the Listeners, but not
+// the HTTPChallengePort). A connection is counted from the moment it is
+// accepted (http.StateNew), through serving requests (http.StateActive)
+// and waiting for the next one (http.StateIdle), until it is closed
+// (http.StateClosed) or hijacked (http.StateHijacked, e.g. WebSockets)
+func (ss *SecureServer) ActiveConnections() int {
+	return ss.conns.openConns()
+}
+
+// progress records that a request just completed
+func (ct *connTracker) progress() {
+	atomic.StoreInt64(&ct.progressed, time.Now().UnixNano())
+}
+
+// untilStalled returns a context which is canceled with cause
+// context.DeadlineExceeded once d elapses without a request completing
+func (ct *connTracker) untilStalled(d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	ct.progress()
+	go func() {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				last := time.Unix(0, atomic.LoadInt64(&ct.progressed))
+				if wait := time.Until(last.Add(d)); wait > 0 {
+					timer.Reset(wait)
+					continue
+				}
+				cancel(context.DeadlineExceeded)
+				return
+			}
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
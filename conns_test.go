@@ -0,0 +1,300 @@
+package sslmgr
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConns(t *testing.T) {
+	Convey("Test connTracker", t, func() {
+		ct := &connTracker{}
+		ct.trackConnState(nil, http.StateNew)
+		ct.trackConnState(nil, http.StateNew)
+		ct.trackConnState(nil, http.StateActive)
+		ct.trackConnState(nil, http.StateIdle)
+		So(ct.openConns(), ShouldEqual, 2)
+		ct.trackConnState(nil, http.StateHijacked)
+		ct.trackConnState(nil, http.StateClosed)
+		So(ct.openConns(), ShouldEqual, 0)
+	})
+	Convey("Test ActiveConnections()", t, func() {
+		port := freePort()
+		release := make(chan struct{})
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-release
+			}),
+			Hostnames:    []string{"yourdomain.io"},
+			HTTPPort:     port,
+			ServeSSLFunc: func() bool { return false },
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		So(ss.ActiveConnections(), ShouldEqual, 0)
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		done := make(chan struct{})
+		for i := 0; i < 2; i++ {
+			go func() {
+				if resp, err := client.Get("http://localhost" + port); err == nil {
+					resp.Body.Close()
+				}
+				done <- struct{}{}
+			}()
+		}
+		for ss.ActiveConnections() < 2 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(ss.ActiveConnections(), ShouldEqual, 2)
+		close(release)
+		<-done
+		<-done
+		for start := time.Now(); ss.ActiveConnections() > 0 && time.Since(start) < time.Second; {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(ss.ActiveConnections(), ShouldEqual, 0)
+	})
+	Convey("Test OnDrainTimeout", t, func() {
+		remaining := make(chan int, 1)
+		port := freePort()
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(time.Second)
+			}),
+			Hostnames:           []string{"yourdomain.io"},
+			HTTPPort:            port,
+			ServeSSLFunc:        func() bool { return false },
+			GracefulnessTimeout: 100 * time.Millisecond,
+			OnDrainTimeout: func(n int) {
+				remaining <- n
+			},
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		go http.Get("http://localhost" + port)
+		time.Sleep(100 * time.Millisecond)
+		ss.TriggerShutdown("test")
+		select {
+		case n := <-remaining:
+			So(n, ShouldEqual, 1)
+		case <-time.After(5 * time.Second):
+			t.Fatal("OnDrainTimeout was not called")
+		}
+	})
+	Convey("Test ShutdownAttempts", t, func() {
+		logger := &testLogger{}
+		var errs []error
+		port := freePort()
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				d, _ := time.ParseDuration(r.URL.Query().Get("sleep"))
+				time.Sleep(d)
+			}),
+			Hostnames:           []string{"yourdomain.io"},
+			HTTPPort:            port,
+			ServeSSLFunc:        func() bool { return false },
+			Logger:              logger,
+			GracefulnessTimeout: 100 * time.Millisecond,
+			ShutdownAttempts:    3,
+			ShutdownRetryDelay:  20 * time.Millisecond,
+			GracefulShutdownErrHandler: func(err error) {
+				errs = append(errs, err)
+			},
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		shutdown := func(sleep string) error {
+			result := make(chan error, 1)
+			go func() {
+				resp, err := client.Get("http://localhost" + port + "/?sleep=" + sleep)
+				if err == nil {
+					resp.Body.Close()
+				}
+				result <- err
+			}()
+			time.Sleep(50 * time.Millisecond)
+			ss.TriggerShutdown("test")
+			<-ss.done
+			return <-result
+		}
+		Convey("Test Stragglers Finish On A Later Attempt", func() {
+			So(shutdown("250ms"), ShouldBeNil)
+			So(errs, ShouldBeEmpty)
+			So(logger.String(), ShouldContainSubstring, "graceful shutdown attempt 1 of 3 timed out with 1 connections open")
+		})
+		Convey("Test Connections Are Closed Once Every Attempt Timed Out", func() {
+			So(shutdown("5s"), ShouldNotBeNil)
+			So(errs, ShouldHaveLength, 1)
+			So(logger.String(), ShouldContainSubstring, "graceful shutdown attempt 3 of 3 timed out, closing 1 remaining connections")
+		})
+	})
+	Convey("Test DrainHijacked", t, func() {
+		hijacked := make(chan net.Conn, 1)
+		notified := make(chan int, 1)
+		remaining := make(chan int, 1)
+		var errs []error
+		port := freePort()
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c, _, err := http.NewResponseController(w).Hijack()
+				if err == nil {
+					hijacked <- c
+				}
+			}),
+			Hostnames:           []string{"yourdomain.io"},
+			HTTPPort:            port,
+			ServeSSLFunc:        func() bool { return false },
+			GracefulnessTimeout: 300 * time.Millisecond,
+			DrainHijacked:       true,
+			OnDrainHijacked:     func(open int) { notified <- open },
+			OnDrainTimeout:      func(n int) { remaining <- n },
+			GracefulShutdownErrHandler: func(err error) {
+				errs = append(errs, err)
+			},
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		conn, err := net.Dial("tcp", "localhost"+port)
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: yourdomain.io\r\n\r\n"))
+		So(err, ShouldBeNil)
+		server := <-hijacked
+		So(ss.conns.hijackedConns(), ShouldEqual, 1)
+		Convey("Test Shutdown Waits For Hijacked Connections", func() {
+			start := time.Now()
+			ss.TriggerShutdown("test")
+			So(<-notified, ShouldEqual, 1)
+			time.Sleep(100 * time.Millisecond)
+			server.Close()
+			<-ss.done
+			So(errs, ShouldBeEmpty)
+			So(time.Since(start), ShouldBeBetween, 100*time.Millisecond, 300*time.Millisecond)
+			So(ss.conns.hijackedConns(), ShouldEqual, 0)
+		})
+		Convey("Test Shutdown Gives Up On Hijacked Connections", func() {
+			defer server.Close()
+			ss.TriggerShutdown("test")
+			<-ss.done
+			So(errs, ShouldHaveLength, 1)
+			So(errors.Is(errs[0], context.DeadlineExceeded), ShouldBeTrue)
+			So(<-remaining, ShouldEqual, 1)
+		})
+	})
+	Convey("Test connTracker.untilStalled()", t, func() {
+		ct := &connTracker{}
+		ctx, cancel := ct.untilStalled(100 * time.Millisecond)
+		defer cancel()
+		for i := 0; i < 4; i++ {
+			time.Sleep(50 * time.Millisecond)
+			ct.trackConnState(nil, http.StateIdle)
+		}
+		So(ctx.Err(), ShouldBeNil)
+		select {
+		case <-ctx.Done():
+			So(context.Cause(ctx), ShouldEqual, context.DeadlineExceeded)
+		case <-time.After(5 * time.Second):
+			t.Fatal("stalled context was not canceled")
+		}
+	})
+	Convey("Test DrainProgressBased", t, func() {
+		newServer := func(mode DrainMode) (*SecureServer, string) {
+			port := freePort()
+			ss, err := NewServer(ServerConfig{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					d, _ := time.ParseDuration(r.URL.Query().Get("sleep"))
+					time.Sleep(d)
+				}),
+				Hostnames:           []string{"yourdomain.io"},
+				HTTPPort:            port,
+				ServeSSLFunc:        func() bool { return false },
+				GracefulnessTimeout: 200 * time.Millisecond,
+				DrainMode:           mode,
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			return ss, port
+		}
+		// requests complete every 100ms, the last well past the timeout
+		drain := func(ss *SecureServer, port string) error {
+			for _, d := range []string{"150ms", "250ms", "350ms", "450ms"} {
+				go http.Get("http://localhost" + port + "/?sleep=" + d)
+			}
+			time.Sleep(50 * time.Millisecond)
+			return ss.drain(ss.gracefulnessTimeout)
+		}
+		Convey("Test Fixed Timeout Gives Up On Active Requests", func() {
+			ss, port := newServer(DrainFixedTimeout)
+			defer ss.close()
+			So(errors.Is(drain(ss, port), context.DeadlineExceeded), ShouldBeTrue)
+		})
+		Convey("Test Progressing Requests Are Waited For", func() {
+			ss, port := newServer(DrainProgressBased)
+			defer ss.close()
+			So(drain(ss, port), ShouldBeNil)
+		})
+		Convey("Test Stalled Requests Are Given Up On", func() {
+			ss, port := newServer(DrainProgressBased)
+			defer ss.close()
+			go http.Get("http://localhost" + port + "/?sleep=5s")
+			time.Sleep(50 * time.Millisecond)
+			start := time.Now()
+			So(errors.Is(ss.drain(ss.gracefulnessTimeout), context.DeadlineExceeded), ShouldBeTrue)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+	})
+	Convey("Test ConnIdleTimeout", t, func() {
+		port := freePort()
+		ss, err := NewServer(ServerConfig{
+			Handler:         http.NotFoundHandler(),
+			Hostnames:       []string{"yourdomain.io"},
+			HTTPPort:        port,
+			ServeSSLFunc:    func() bool { return false },
+			ReadTimeout:     time.Minute,
+			ConnIdleTimeout: 100 * time.Millisecond,
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		Convey("Test Silent Connection Is Closed", func() {
+			conn, err := net.Dial("tcp", "localhost"+port)
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			start := time.Now()
+			_, err = conn.Read(make([]byte, 1))
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+		})
+		Convey("Test Connection Outlives Timeout Once Active", func() {
+			tr := &http.Transport{}
+			defer tr.CloseIdleConnections()
+			client := &http.Client{Transport: tr}
+			resp, err := client.Get("http://localhost" + port)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			time.Sleep(200 * time.Millisecond)
+			resp, err = client.Get("http://localhost" + port)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(ss.conns.openConns(), ShouldEqual, 1)
+		})
+	})
+}
+
+// freePort returns a port, in ":port" form, which was free at the time
+func freePort() string {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		panic(err)
+	}
+	defer l.Close()
+	return portOf(l)
+}
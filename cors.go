@@ -0,0 +1,136 @@
+package sslmgr
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCORSMethods are the methods allowed cross-origin when no
+// AllowedMethods are configured
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// CORSConfig holds configuration for answering cross-origin requests
+type CORSConfig struct {
+	// Enabled turns on answering CORS preflight requests and adding
+	// CORS headers to responses to cross-origin requests
+	Enabled bool
+
+	// AllowedOrigins lists the origins (e.g. "https://app.example.com")
+	// allowed to make cross-origin requests, or "*" for any origin.
+	// Preflight requests from other origins are rejected with a 403
+	// Forbidden, and other requests from them get no CORS headers
+	AllowedOrigins []string
+
+	// AllowedMethods lists the methods allowed in cross-origin requests
+	// Default value is GET, HEAD and POST
+	AllowedMethods []string
+
+	// AllowedHeaders lists the request headers allowed in cross-origin
+	// requests, beyond those always allowed by browsers
+	AllowedHeaders []string
+
+	// AllowCredentials allows cross-origin requests to include credentials
+	// such as cookies. It cannot be combined with the "*" origin
+	AllowCredentials bool
+
+	// MaxAge is the time browsers may cache the answer to a preflight
+	// Default behavior is to leave it to browsers
+	MaxAge time.Duration
+}
+
+// validate checks that the config is well formed
+func (cc CORSConfig) validate() error {
+	if !cc.Enabled {
+		return nil
+	}
+	if len(cc.AllowedOrigins) == 0 {
+		return fmt.Errorf("%w: no allowed origins", ErrInvalidCORSConfig)
+	}
+	for _, origin := range cc.AllowedOrigins {
+		if origin == "*" {
+			if cc.AllowCredentials {
+				return fmt.Errorf("%w: credentials cannot be allowed for any origin", ErrInvalidCORSConfig)
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("%w: malformed origin %q", ErrInvalidCORSConfig, origin)
+		}
+	}
+	return nil
+}
+
+// allows reports whether origin may make cross-origin requests
+func (cc CORSConfig) allows(origin string) bool {
+	for _, allowed := range cc.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// withCORS answers CORS preflight requests and adds CORS headers to
+// responses to cross-origin requests from allowed origins
+func withCORS(h http.Handler, cc CORSConfig) http.Handler {
+	methods := cc.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	allowedMethods := strings.Join(methods, ", ")
+	allowedHeaders := strings.Join(cc.AllowedHeaders, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !cc.allows(origin) {
+			if preflight {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if cc.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		if !containsFold(methods, r.Header.Get("Access-Control-Request-Method")) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+		if allowedHeaders != "" {
+			w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+		}
+		if cc.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cc.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// containsFold reports whether list contains s, case insensitively
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
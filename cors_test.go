@@ -0,0 +1,94 @@
+package sslmgr
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCORS(t *testing.T) {
+	Convey("Test CORSConfig.validate()", t, func() {
+		So(CORSConfig{}.validate(), ShouldBeNil)
+		So(CORSConfig{Enabled: true, AllowedOrigins: []string{"*"}}.validate(), ShouldBeNil)
+		So(CORSConfig{Enabled: true, AllowedOrigins: []string{"https://app.yourdomain.io"}, AllowCredentials: true}.validate(), ShouldBeNil)
+		for _, cc := range []CORSConfig{
+			{Enabled: true},
+			{Enabled: true, AllowedOrigins: []string{"app.yourdomain.io"}},
+			{Enabled: true, AllowedOrigins: []string{"https://app.yourdomain.io/path"}},
+			{Enabled: true, AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		} {
+			So(errors.Is(cc.validate(), ErrInvalidCORSConfig), ShouldBeTrue)
+		}
+		_, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io"},
+			CORS:      CORSConfig{Enabled: true},
+		})
+		So(errors.Is(err, ErrInvalidCORSConfig), ShouldBeTrue)
+	})
+	Convey("Test withCORS()", t, func() {
+		var served bool
+		h := withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = true
+		}), CORSConfig{
+			Enabled:          true,
+			AllowedOrigins:   []string{"https://app.yourdomain.io"},
+			AllowedHeaders:   []string{"Authorization"},
+			AllowCredentials: true,
+			MaxAge:           time.Hour,
+		})
+		request := func(method, origin, preflightMethod string) *httptest.ResponseRecorder {
+			served = false
+			r := httptest.NewRequest(method, "https://yourdomain.io/", nil)
+			if origin != "" {
+				r.Header.Set("Origin", origin)
+			}
+			if preflightMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", preflightMethod)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			return rec
+		}
+		Convey("Test Preflight From Allowed Origin Is Answered", func() {
+			rec := request(http.MethodOptions, "https://app.yourdomain.io", http.MethodPost)
+			So(served, ShouldBeFalse)
+			So(rec.Code, ShouldEqual, http.StatusNoContent)
+			So(rec.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://app.yourdomain.io")
+			So(rec.Header().Get("Access-Control-Allow-Methods"), ShouldEqual, "GET, HEAD, POST")
+			So(rec.Header().Get("Access-Control-Allow-Headers"), ShouldEqual, "Authorization")
+			So(rec.Header().Get("Access-Control-Allow-Credentials"), ShouldEqual, "true")
+			So(rec.Header().Get("Access-Control-Max-Age"), ShouldEqual, "3600")
+		})
+		Convey("Test Preflight From Disallowed Origin Is Rejected", func() {
+			rec := request(http.MethodOptions, "https://evil.io", http.MethodPost)
+			So(served, ShouldBeFalse)
+			So(rec.Code, ShouldEqual, http.StatusForbidden)
+			So(rec.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+		})
+		Convey("Test Preflight For Disallowed Method Is Rejected", func() {
+			rec := request(http.MethodOptions, "https://app.yourdomain.io", http.MethodDelete)
+			So(served, ShouldBeFalse)
+			So(rec.Code, ShouldEqual, http.StatusForbidden)
+		})
+		Convey("Test Actual Request From Allowed Origin Gets Headers", func() {
+			rec := request(http.MethodGet, "https://app.yourdomain.io", "")
+			So(served, ShouldBeTrue)
+			So(rec.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://app.yourdomain.io")
+			So(rec.Header().Get("Access-Control-Allow-Methods"), ShouldBeEmpty)
+		})
+		Convey("Test Actual Request From Disallowed Origin Gets No Headers", func() {
+			rec := request(http.MethodGet, "https://evil.io", "")
+			So(served, ShouldBeTrue)
+			So(rec.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+		})
+		Convey("Test Plain OPTIONS Reaches The Handler", func() {
+			request(http.MethodOptions, "", "")
+			So(served, ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,74 @@
+package sslmgr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"time"
+)
+
+// devCertValidity is how long the self-signed certificates of DevMode are
+// valid for
+const devCertValidity = 30 * 24 * time.Hour
+
+// selfSignedCert generates a self-signed certificate valid for every one
+// of the hostnames, or for localhost (and its loopback addresses) if none
+func selfSignedCert(hostnames []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "sslmgr dev mode"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(devCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if len(hostnames) == 0 {
+		hostnames = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	for _, host := range hostnames {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+			continue
+		}
+		template.DNSNames = append(template.DNSNames, normalizeHost(host))
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// ClientTLSConfig returns a client tls.Config trusting the self-signed
+// certificate served in DevMode, so that tests can make real HTTPS
+// requests to the server, e.g. through an http.Transport's
+// TLSClientConfig. It returns nil, for the system roots to be used,
+// when not in DevMode
+func (ss *SecureServer) ClientTLSConfig() *tls.Config {
+	if ss.devCert == nil {
+		return nil
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ss.devCert)
+	return &tls.Config{RootCAs: roots}
+}
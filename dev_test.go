@@ -0,0 +1,58 @@
+package sslmgr
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDevMode(t *testing.T) {
+	Convey("Test DevMode", t, func() {
+		port := freePort()
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "hello")
+			}),
+			HTTPPort:  freePort(),
+			HTTPSPort: port,
+			DevMode:   true,
+		})
+		So(err, ShouldBeNil)
+		So(ss.http01, ShouldBeFalse)
+		So(ss.tlsALPN01, ShouldBeFalse)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		Convey("Test ClientTLSConfig Trusts The Self-Signed Certificate", func() {
+			for _, host := range []string{"localhost", "127.0.0.1"} {
+				client := &http.Client{Transport: &http.Transport{TLSClientConfig: ss.ClientTLSConfig()}}
+				resp, err := client.Get("https://" + host + port)
+				So(err, ShouldBeNil)
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				So(err, ShouldBeNil)
+				So(string(body), ShouldEqual, "hello")
+			}
+		})
+		Convey("Test Certificate Is Untrusted Otherwise", func() {
+			_, err := http.Get("https://localhost" + port)
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Test Certificate Covers The Hostnames", t, func() {
+		cert, err := selfSignedCert([]string{"YourDomain.io", "10.0.0.1"})
+		So(err, ShouldBeNil)
+		So(cert.Leaf.VerifyHostname("yourdomain.io"), ShouldBeNil)
+		So(cert.Leaf.VerifyHostname("10.0.0.1"), ShouldBeNil)
+		So(cert.Leaf.VerifyHostname("localhost"), ShouldNotBeNil)
+	})
+	Convey("Test ClientTLSConfig Is Nil Outside DevMode", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io"},
+		})
+		So(err, ShouldBeNil)
+		So(ss.ClientTLSConfig(), ShouldBeNil)
+	})
+}
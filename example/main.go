@@ -4,7 +4,6 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/adrianosela/certcache"
@@ -21,13 +20,11 @@ func main() {
 	})
 
 	ss, err := sslmgr.NewServer(sslmgr.ServerConfig{
-		Hostnames: []string{os.Getenv("CN_FOR_CERTIFICATE")},
-		HTTPPort:  ":80",
-		HTTPSPort: ":443",
-		Handler:   h,
-		ServeSSLFunc: func() bool {
-			return strings.ToLower(os.Getenv("PROD")) == "true"
-		},
+		Hostnames:    []string{os.Getenv("CN_FOR_CERTIFICATE")},
+		HTTPPort:     ":80",
+		HTTPSPort:    ":443",
+		Handler:      h,
+		ServeSSLFunc: sslmgr.ServeSSLFromEnv("PROD", "true"),
 		CertCache: certcache.NewLayered(
 			certcache.NewLogger(),
 			autocert.DirCache("."),
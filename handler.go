@@ -0,0 +1,118 @@
+package sslmgr
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// swappableHandler is an http.Handler whose underlying handler
+// can be replaced at runtime without interrupting in-flight requests
+type swappableHandler struct {
+	current atomic.Value // holds a handlerBox
+}
+
+// handlerBox gives atomic.Value a single concrete type to store
+// regardless of the http.Handler implementation being swapped in
+type handlerBox struct {
+	http.Handler
+}
+
+func newSwappableHandler(h http.Handler) *swappableHandler {
+	sh := &swappableHandler{}
+	sh.store(h)
+	return sh
+}
+
+func (sh *swappableHandler) store(h http.Handler) {
+	sh.current.Store(handlerBox{h})
+}
+
+func (sh *swappableHandler) load() http.Handler {
+	return sh.current.Load().(handlerBox).Handler
+}
+
+// ServeHTTP serves the request with the handler active at the time
+func (sh *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sh.load().ServeHTTP(w, r)
+}
+
+// byScheme routes requests received over TLS to secure, and all others to plain
+func byScheme(secure, plain http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			secure.ServeHTTP(w, r)
+			return
+		}
+		plain.ServeHTTP(w, r)
+	})
+}
+
+// withGRPC routes gRPC requests received over TLS to grpc, and all others
+// to h
+func withGRPC(h, grpc http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpc.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ExtendWriteDeadline lets a handler streaming a long-lived response
+// (e.g. server-sent events or a large download) outlive the server's
+// WriteTimeout, by moving the deadline for writing the response to d from
+// now. A zero d removes the deadline altogether. Handlers call it before
+// they start writing, and may call it again as they go to keep streaming
+func ExtendWriteDeadline(w http.ResponseWriter, d time.Duration) error {
+	var deadline time.Time
+	if d > 0 {
+		deadline = time.Now().Add(d)
+	}
+	return http.NewResponseController(w).SetWriteDeadline(deadline)
+}
+
+// whenCertReady serves h once a valid certificate for one of the server's
+// hostnames is cached, responding 503 Service Unavailable until then
+func (ss *SecureServer) whenCertReady(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ss.hasCert(r.Context()) {
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// hasCert reports whether a valid certificate for one of the server's
+// hostnames is available, which remains true once it has been
+func (ss *SecureServer) hasCert(ctx context.Context) bool {
+	if ss.certReady.Load() || ss.certificates != nil {
+		return true
+	}
+	for _, host := range ss.hostnames {
+		if _, err := cachedCert(ctx, ss.certMgr.Cache, host); err == nil {
+			ss.certReady.Store(true)
+			return true
+		}
+	}
+	return false
+}
+
+// redirectToHTTPS redirects requests to the same URL over HTTPS, on the
+// default port like autocert does for requests which are not challenges
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 literal
+		}
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusFound)
+}
@@ -0,0 +1,281 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHandler(t *testing.T) {
+	Convey("Test SetHandler()", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io"},
+		})
+		So(err, ShouldBeNil)
+		Convey("Test Initial Handler Is Served", func() {
+			rec := httptest.NewRecorder()
+			ss.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Test Replaced Handler Is Served", func() {
+			ss.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+			rec := httptest.NewRecorder()
+			ss.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(rec.Code, ShouldEqual, http.StatusTeapot)
+		})
+		Convey("Test Replacement Survives ACME Wrapping", func() {
+			ss.server.Handler = ss.certMgr.HTTPHandler(ss.server.Handler)
+			ss.SetHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}))
+			rec := httptest.NewRecorder()
+			ss.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(rec.Code, ShouldEqual, http.StatusTeapot)
+		})
+		Convey("Test Nil Handler Panics", func() {
+			So(func() { ss.SetHandler(nil) }, ShouldPanic)
+		})
+	})
+	Convey("Test HTTPHandler Serves Plain HTTP", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io"},
+			HTTPHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}),
+			HTTPPort:  ":0",
+			HTTPSPort: ":0",
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.server.Close()
+
+		plain := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		ss.server.Handler.ServeHTTP(rec, plain)
+		So(rec.Code, ShouldEqual, http.StatusTeapot)
+
+		secure := httptest.NewRequest(http.MethodGet, "/", nil)
+		secure.TLS = &tls.ConnectionState{}
+		rec = httptest.NewRecorder()
+		ss.server.Handler.ServeHTTP(rec, secure)
+		So(rec.Code, ShouldEqual, http.StatusNotFound)
+	})
+	Convey("Test PlainHTTPMode", t, func() {
+		cache := newMemCache()
+		cache.Put(context.Background(), "token+http-01", []byte("token.thumbprint"))
+		newServer := func(mode PlainHTTPMode) *SecureServer {
+			ss, err := NewServer(ServerConfig{
+				Handler:       http.NotFoundHandler(),
+				Hostnames:     []string{"yourdomain.io"},
+				CertCache:     cache,
+				HTTPHandler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) }),
+				HTTPPort:      ":0",
+				HTTPSPort:     ":0",
+				PlainHTTPMode: mode,
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			return ss
+		}
+		serve := func(ss *SecureServer, path string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			ss.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://yourdomain.io"+path, nil))
+			return rec
+		}
+		for mode, code := range map[PlainHTTPMode]int{
+			ServeContent:    http.StatusTeapot,
+			RedirectToHTTPS: http.StatusFound,
+			ChallengeOnly:   http.StatusNotFound,
+		} {
+			ss := newServer(mode)
+			defer ss.close()
+			So(serve(ss, "/.well-known/acme-challenge/token").Body.String(), ShouldEqual, "token.thumbprint")
+			So(serve(ss, "/path?q=1").Code, ShouldEqual, code)
+		}
+		Convey("Test Challenge Port Only Answers Challenges", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:           http.NotFoundHandler(),
+				Hostnames:         []string{"yourdomain.io"},
+				HTTPChallengePort: ":0",
+				PlainHTTPMode:     ChallengeOnly,
+			})
+			So(err, ShouldBeNil)
+			rec := httptest.NewRecorder()
+			ss.challengeServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://yourdomain.io/", nil))
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+	Convey("Test RedirectWhenCertReady", t, func() {
+		cache := newMemCache()
+		ss, err := NewServer(ServerConfig{
+			Handler:               http.NotFoundHandler(),
+			Hostnames:             []string{"yourdomain.io"},
+			CertCache:             cache,
+			HTTPPort:              ":0",
+			HTTPSPort:             ":0",
+			PlainHTTPMode:         RedirectToHTTPS,
+			RedirectWhenCertReady: true,
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		serve := func() *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			ss.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://yourdomain.io/path", nil))
+			return rec
+		}
+		rec := serve()
+		So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+		So(rec.Header().Get("Retry-After"), ShouldNotBeEmpty)
+		now := time.Now()
+		cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+		So(serve().Code, ShouldEqual, http.StatusFound)
+		Convey("Test Readiness Is Remembered", func() {
+			cache.Delete(context.Background(), "yourdomain.io")
+			So(serve().Code, ShouldEqual, http.StatusFound)
+		})
+		Convey("Test Challenge Port Waits For A Certificate", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:               http.NotFoundHandler(),
+				Hostnames:             []string{"yourdomain.io"},
+				CertCache:             newMemCache(),
+				HTTPChallengePort:     ":0",
+				RedirectWhenCertReady: true,
+			})
+			So(err, ShouldBeNil)
+			rec := httptest.NewRecorder()
+			ss.challengeServer.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://yourdomain.io/", nil))
+			So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+		})
+	})
+	Convey("Test GRPCHandler", t, func() {
+		port := freePort()
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "rest")
+			}),
+			GRPCHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "grpc")
+			}),
+			HTTPPort:     freePort(),
+			HTTPSPort:    port,
+			Certificates: testTLSConfig("yourdomain.io").Certificates,
+		})
+		So(err, ShouldBeNil)
+		So(ss.tlsConfig().NextProtos, ShouldContain, "h2")
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+		post := func(contentType string) string {
+			resp, err := client.Post("https://localhost"+port, contentType, strings.NewReader(""))
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.ProtoMajor, ShouldEqual, 2)
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			return string(body)
+		}
+		So(post("application/grpc"), ShouldEqual, "grpc")
+		So(post("application/grpc+proto"), ShouldEqual, "grpc")
+		So(post("application/json"), ShouldEqual, "rest")
+		Convey("Test gRPC Requests Are Access Controlled", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler: http.NotFoundHandler(),
+				GRPCHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusTeapot)
+				}),
+				HTTPPort:     freePort(),
+				HTTPSPort:    freePort(),
+				Certificates: testTLSConfig("yourdomain.io").Certificates,
+				DeniedCIDRs:  []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			defer ss.close()
+			serve := func(remoteAddr string) int {
+				r := httptest.NewRequest(http.MethodPost, "https://yourdomain.io/", nil)
+				r.ProtoMajor = 2
+				r.RemoteAddr = remoteAddr
+				r.Header.Set("Content-Type", "application/grpc")
+				rec := httptest.NewRecorder()
+				ss.server.Handler.ServeHTTP(rec, r)
+				return rec.Code
+			}
+			So(serve("198.51.100.1:1234"), ShouldEqual, http.StatusTeapot)
+			So(serve("192.0.2.1:1234"), ShouldEqual, http.StatusForbidden)
+		})
+		Convey("Test HTTP/1 Requests Are Not Routed To gRPC", func() {
+			r := httptest.NewRequest(http.MethodPost, "https://yourdomain.io/", nil)
+			r.Header.Set("Content-Type", "application/grpc")
+			rec := httptest.NewRecorder()
+			withGRPC(http.NotFoundHandler(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			})).ServeHTTP(rec, r)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+	Convey("Test redirectToHTTPS()", t, func() {
+		for host, location := range map[string]string{
+			"yourdomain.io":    "https://yourdomain.io/path?q=1",
+			"yourdomain.io:80": "https://yourdomain.io/path?q=1",
+			"[::1]:80":         "https://[::1]/path?q=1",
+		} {
+			r := httptest.NewRequest(http.MethodGet, "/path?q=1", nil)
+			r.Host = host
+			rec := httptest.NewRecorder()
+			redirectToHTTPS(rec, r)
+			So(rec.Code, ShouldEqual, http.StatusFound)
+			So(rec.Header().Get("Location"), ShouldEqual, location)
+		}
+	})
+	Convey("Test ExtendWriteDeadline()", t, func() {
+		port := freePort()
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/stream" {
+					if err := ExtendWriteDeadline(w, 0); err != nil {
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+				}
+				time.Sleep(300 * time.Millisecond)
+				w.Write([]byte("done"))
+			}),
+			Hostnames:    []string{"yourdomain.io"},
+			HTTPPort:     port,
+			ServeSSLFunc: func() bool { return false },
+			WriteTimeout: 100 * time.Millisecond,
+			Compression:  CompressionConfig{Enabled: true},
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		Convey("Test Extended Response Outlives WriteTimeout", func() {
+			resp, err := http.Get("http://localhost" + port + "/stream")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "done")
+		})
+		Convey("Test Other Responses Are Cut Off", func() {
+			_, err := http.Get("http://localhost" + port + "/other")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,99 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSOptions are the TLS settings overridden for a hostname in the
+// HostTLSOverrides. Zero valued fields keep the server's settings
+type TLSOptions struct {
+	// MinVersion and MaxVersion bound the TLS versions accepted, e.g.
+	// tls.VersionTLS12 for a legacy tenant or tls.VersionTLS13 for others
+	MinVersion uint16
+	MaxVersion uint16
+
+	// CipherSuites are the TLS 1.2 (and older) cipher suites accepted.
+	// Note that TLS 1.3 cipher suites are not configurable in Go
+	CipherSuites []uint16
+
+	// CurvePreferences are the elliptic curves used in key exchanges
+	CurvePreferences []tls.CurveID
+}
+
+// apply overrides the settings of config set in the options
+func (o TLSOptions) apply(config *tls.Config) {
+	if o.MinVersion != 0 {
+		config.MinVersion = o.MinVersion
+	}
+	if o.MaxVersion != 0 {
+		config.MaxVersion = o.MaxVersion
+	}
+	if o.CipherSuites != nil {
+		config.CipherSuites = o.CipherSuites
+	}
+	if o.CurvePreferences != nil {
+		config.CurvePreferences = o.CurvePreferences
+	}
+}
+
+// fill sets the settings of config left unset to those of the options
+func (o TLSOptions) fill(config *tls.Config) {
+	if config.MinVersion == 0 {
+		config.MinVersion = o.MinVersion
+	}
+	if config.MaxVersion == 0 {
+		config.MaxVersion = o.MaxVersion
+	}
+	if config.CipherSuites == nil {
+		config.CipherSuites = o.CipherSuites
+	}
+	if config.CurvePreferences == nil {
+		config.CurvePreferences = o.CurvePreferences
+	}
+}
+
+// normalizeTLSOverrides validates the overrides, and returns them keyed
+// by normalized hostname. Overrides for hostnames the policy (if any)
+// refuses are rejected, as they would never apply
+func normalizeTLSOverrides(overrides map[string]TLSOptions, policy autocert.HostPolicy) (map[string]TLSOptions, error) {
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+	normalized := make(map[string]TLSOptions, len(overrides))
+	for host, opts := range overrides {
+		if host = normalizeHost(host); host == "" {
+			return nil, fmt.Errorf("%w: empty hostname", ErrInvalidTLSOptions)
+		}
+		if opts.MinVersion != 0 && opts.MaxVersion != 0 && opts.MinVersion > opts.MaxVersion {
+			return nil, fmt.Errorf("%w: %s: MinVersion is above MaxVersion", ErrInvalidTLSOptions, host)
+		}
+		if policy != nil {
+			if err := policy(context.Background(), host); err != nil {
+				return nil, fmt.Errorf("%w: %s: %s", ErrInvalidTLSOptions, host, err)
+			}
+		}
+		normalized[host] = opts
+	}
+	return normalized, nil
+}
+
+// hostTLSConfig returns a clone of base with the HostTLSOverrides of the
+// hostname requested by hello applied, or nil if there are none. The
+// MinVersion of base (e.g. that of one of the Listeners) is never lowered
+func (ss *SecureServer) hostTLSConfig(base *tls.Config, hello *tls.ClientHelloInfo) *tls.Config {
+	opts, ok := ss.hostTLSOverrides[normalizeHost(hello.ServerName)]
+	if !ok {
+		return nil
+	}
+	config := base.Clone()
+	config.GetConfigForClient = nil
+	opts.apply(config)
+	if config.MinVersion < base.MinVersion {
+		config.MinVersion = base.MinVersion
+	}
+	return config
+}
@@ -0,0 +1,115 @@
+package sslmgr
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHostTLSOverrides(t *testing.T) {
+	Convey("Test HostTLSOverrides", t, func() {
+		port, listenerPort := freePort(), freePort()
+		dial := func(port, host string, maxVersion uint16) (uint16, error) {
+			conn, err := tls.Dial("tcp", "localhost"+port, &tls.Config{
+				ServerName:         host,
+				MaxVersion:         maxVersion,
+				InsecureSkipVerify: true,
+			})
+			if err != nil {
+				return 0, err
+			}
+			defer conn.Close()
+			return conn.ConnectionState().Version, nil
+		}
+		handshake := func(host string, maxVersion uint16) (uint16, error) {
+			return dial(port, host, maxVersion)
+		}
+		ss, err := NewServer(ServerConfig{
+			Handler:  http.NotFoundHandler(),
+			HTTPPort: freePort(),
+			Certificates: append(
+				testTLSConfig("yourdomain.io").Certificates,
+				testTLSConfig("legacy.io").Certificates...,
+			),
+			HTTPSPort: port,
+			HostTLSOverrides: map[string]TLSOptions{
+				"YourDomain.io": {MinVersion: tls.VersionTLS13},
+				"legacy.io":     {MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS12},
+			},
+			Listeners: []ListenerConfig{{Addr: listenerPort, MinVersion: tls.VersionTLS13}},
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		Convey("Test Overrides Apply By Server Name", func() {
+			_, err := handshake("yourdomain.io", tls.VersionTLS12)
+			So(err, ShouldNotBeNil)
+			version, err := handshake("yourdomain.io", 0)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, tls.VersionTLS13)
+			version, err = handshake("legacy.io", 0)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, tls.VersionTLS12)
+		})
+		Convey("Test Overrides Apply On Listeners Without Lowering Their MinVersion", func() {
+			_, err := dial(listenerPort, "yourdomain.io", tls.VersionTLS12)
+			So(err, ShouldNotBeNil)
+			version, err := dial(listenerPort, "yourdomain.io", 0)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, tls.VersionTLS13)
+			_, err = dial(listenerPort, "legacy.io", 0)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Test Other Hostnames Keep The Server's Settings", func() {
+			version, err := handshake("otherdomain.io", tls.VersionTLS12)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, tls.VersionTLS12)
+		})
+	})
+	Convey("Test GetConfigForClient Takes Precedence", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:      http.NotFoundHandler(),
+			Hostnames:    []string{"yourdomain.io"},
+			HostPatterns: []string{"*.yourdomain.io"},
+			HostTLSOverrides: map[string]TLSOptions{
+				"yourdomain.io":        {MinVersion: tls.VersionTLS13},
+				"custom.yourdomain.io": {MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13},
+			},
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				if hello.ServerName == "custom.yourdomain.io" {
+					return &tls.Config{MinVersion: tls.VersionTLS12}, nil
+				}
+				return nil, nil
+			},
+		})
+		So(err, ShouldBeNil)
+		configForClient := ss.tlsConfig().GetConfigForClient
+		config, err := configForClient(&tls.ClientHelloInfo{ServerName: "custom.yourdomain.io"})
+		So(err, ShouldBeNil)
+		So(config.MinVersion, ShouldEqual, tls.VersionTLS12)
+		So(config.MaxVersion, ShouldEqual, tls.VersionTLS13) // filled in by the override
+		config, err = configForClient(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+		So(err, ShouldBeNil)
+		So(config.MinVersion, ShouldEqual, tls.VersionTLS13)
+		So(config.GetCertificate, ShouldNotBeNil)
+	})
+	Convey("Test Invalid Overrides Are Rejected", t, func() {
+		for _, overrides := range []map[string]TLSOptions{
+			{"": {}},
+			{"yourdomain.io": {MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12}},
+			{"yourdomian.io": {MinVersion: tls.VersionTLS13}},      // not one of the Hostnames
+			{"api.otherdomain.io": {MinVersion: tls.VersionTLS13}}, // matches no HostPatterns
+		} {
+			_, err := NewServer(ServerConfig{
+				Handler:          http.NotFoundHandler(),
+				Hostnames:        []string{"yourdomain.io"},
+				HostPatterns:     []string{"*.yourdomain.io"},
+				HostTLSOverrides: overrides,
+			})
+			So(errors.Is(err, ErrInvalidTLSOptions), ShouldBeTrue)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package sslmgr
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// validatePrefixes returns ErrInvalidCIDR if any of the prefixes is invalid
+func validatePrefixes(prefixes ...[]netip.Prefix) error {
+	for _, list := range prefixes {
+		for _, p := range list {
+			if !p.IsValid() {
+				return fmt.Errorf("%w: %s", ErrInvalidCIDR, p)
+			}
+		}
+	}
+	return nil
+}
+
+// containsAddr reports whether any of the prefixes contains ip
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client a request was sent by.
+// When the request was received from one of the trusted proxies, it is
+// the right-most address of the X-Forwarded-For header which is not that
+// of a trusted proxy, so that addresses made up by the client are ignored
+func clientIP(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	ip := peer.Addr().Unmap()
+	if !containsAddr(trusted, ip) {
+		return ip, true
+	}
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		if ip = hop.Unmap(); !containsAddr(trusted, ip) {
+			break
+		}
+	}
+	return ip, true
+}
+
+// withIPFilter responds 403 Forbidden to requests from clients in any of
+// the denied ranges, or outside all of the allowed ones (when there are
+// any), before they reach h. Requests whose client IP can not be
+// determined are rejected too
+func withIPFilter(h http.Handler, allowed, denied, trusted []netip.Prefix) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, ok := clientIP(r, trusted)
+		if !ok || containsAddr(denied, ip) || (len(allowed) > 0 && !containsAddr(allowed, ip)) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,104 @@
+package sslmgr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIPFilter(t *testing.T) {
+	prefixes := func(cidrs ...string) []netip.Prefix {
+		var ps []netip.Prefix
+		for _, cidr := range cidrs {
+			ps = append(ps, netip.MustParsePrefix(cidr))
+		}
+		return ps
+	}
+	Convey("Test clientIP()", t, func() {
+		trusted := prefixes("10.0.0.0/8")
+		ip := func(remoteAddr string, forwardedFor ...string) string {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = remoteAddr
+			for _, value := range forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			addr, ok := clientIP(r, trusted)
+			if !ok {
+				return "unknown"
+			}
+			return addr.String()
+		}
+		So(ip("203.0.113.1:1234"), ShouldEqual, "203.0.113.1")
+		So(ip("[::ffff:203.0.113.1]:1234"), ShouldEqual, "203.0.113.1")
+		So(ip("203.0.113.1:1234", "198.51.100.1"), ShouldEqual, "203.0.113.1")
+		So(ip("10.0.0.1:1234", "198.51.100.1"), ShouldEqual, "198.51.100.1")
+		So(ip("10.0.0.1:1234", "192.0.2.1, 198.51.100.1, 10.0.0.2"), ShouldEqual, "198.51.100.1")
+		So(ip("10.0.0.1:1234", "192.0.2.1", "198.51.100.1"), ShouldEqual, "198.51.100.1")
+		So(ip("10.0.0.1:1234", "10.0.0.3"), ShouldEqual, "10.0.0.3")
+		So(ip("10.0.0.1:1234"), ShouldEqual, "10.0.0.1")
+		So(ip("10.0.0.1:1234", "garbage"), ShouldEqual, "unknown")
+		So(ip("pipe"), ShouldEqual, "unknown")
+	})
+	Convey("Test withIPFilter()", t, func() {
+		h := withIPFilter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}), prefixes("192.0.2.0/24"), prefixes("192.0.2.128/25"), prefixes("10.0.0.0/8"))
+		serve := func(remoteAddr, forwardedFor string) int {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = remoteAddr
+			if forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", forwardedFor)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			return rec.Code
+		}
+		So(serve("192.0.2.1:1234", ""), ShouldEqual, http.StatusTeapot)
+		So(serve("198.51.100.1:1234", ""), ShouldEqual, http.StatusForbidden)
+		Convey("Test Denylist Takes Precedence", func() {
+			So(serve("192.0.2.200:1234", ""), ShouldEqual, http.StatusForbidden)
+		})
+		Convey("Test Forwarded Client IP Is Checked Behind Trusted Proxies", func() {
+			So(serve("10.0.0.1:1234", "192.0.2.1"), ShouldEqual, http.StatusTeapot)
+			So(serve("10.0.0.1:1234", "198.51.100.1"), ShouldEqual, http.StatusForbidden)
+			So(serve("198.51.100.1:1234", "192.0.2.1"), ShouldEqual, http.StatusForbidden)
+		})
+	})
+	Convey("Test AllowedCIDRs", t, func() {
+		cache := newMemCache()
+		cache.Put(context.Background(), "token+http-01", []byte("token.thumbprint"))
+		ss, err := NewServer(ServerConfig{
+			Handler:      http.NotFoundHandler(),
+			Hostnames:    []string{"yourdomain.io"},
+			CertCache:    cache,
+			HTTPPort:     ":0",
+			HTTPSPort:    ":0",
+			AllowedCIDRs: prefixes("192.0.2.0/24"),
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		serve := func(path string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodGet, "http://yourdomain.io"+path, nil)
+			r.RemoteAddr = "198.51.100.1:1234"
+			rec := httptest.NewRecorder()
+			ss.httpServer.Handler.ServeHTTP(rec, r)
+			return rec
+		}
+		So(serve("/").Code, ShouldEqual, http.StatusForbidden)
+		So(serve("/.well-known/acme-challenge/token").Body.String(), ShouldEqual, "token.thumbprint")
+		Convey("Test Invalid Prefixes Are Rejected", func() {
+			_, err := NewServer(ServerConfig{
+				Handler:     http.NotFoundHandler(),
+				Hostnames:   []string{"yourdomain.io"},
+				DeniedCIDRs: []netip.Prefix{{}},
+			})
+			So(errors.Is(err, ErrInvalidCIDR), ShouldBeTrue)
+		})
+	})
+}
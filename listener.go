@@ -0,0 +1,234 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// handshakeListener is a TLS listener which completes each handshake in
+// the background, bounded by its own deadline, and only hands out
+// connections which completed one. This keeps slow or malicious
+// handshakes from eating into the HTTP read timeout
+type handshakeListener struct {
+	net.Listener
+	config    *tls.Config
+	timeout   time.Duration
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newHandshakeListener(inner net.Listener, config *tls.Config, timeout time.Duration) *handshakeListener {
+	hl := &handshakeListener{
+		Listener: inner,
+		config:   config,
+		timeout:  timeout,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go hl.acceptLoop()
+	return hl
+}
+
+// acceptLoop accepts raw connections and starts their handshakes
+func (hl *handshakeListener) acceptLoop() {
+	for {
+		c, err := hl.Listener.Accept()
+		if err != nil {
+			select {
+			case hl.errs <- err:
+			case <-hl.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go hl.handshake(c)
+	}
+}
+
+// handshake completes the TLS handshake on c within the timeout
+func (hl *handshakeListener) handshake(c net.Conn) {
+	ctx, cncl := context.WithTimeout(context.Background(), hl.timeout)
+	defer cncl()
+	tlsConn := tls.Server(c, hl.config)
+	c.SetDeadline(time.Now().Add(hl.timeout))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+	select {
+	case hl.conns <- tlsConn:
+	case <-hl.done:
+		c.Close()
+	}
+}
+
+// Accept returns the next connection which completed its handshake
+func (hl *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-hl.conns:
+		return c, nil
+	case err := <-hl.errs:
+		return nil, err
+	case <-hl.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections
+func (hl *handshakeListener) Close() error {
+	hl.closeOnce.Do(func() { close(hl.done) })
+	return hl.Listener.Close()
+}
+
+// keepAliveListener sets the TCP keep-alive period of accepted
+// connections, disabling keep-alive probes altogether if it is negative
+type keepAliveListener struct {
+	net.Listener
+	period time.Duration
+}
+
+// Accept returns the next connection with its keep-alive period set
+func (kl *keepAliveListener) Accept() (net.Conn, error) {
+	c, err := kl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := c.(*net.TCPConn); ok {
+		if kl.period < 0 {
+			tc.SetKeepAlive(false)
+		} else {
+			tc.SetKeepAlive(true)
+			tc.SetKeepAlivePeriod(kl.period)
+		}
+	}
+	return c, nil
+}
+
+// ListenerConfig describes an additional HTTPS listener, with TLS
+// settings (and optionally a handler) of its own. It shares everything
+// else with the server's HTTPS listener: certificates, timeouts,
+// middleware and lifecycle
+type ListenerConfig struct {
+	// Addr is the address to listen at, e.g. "10.0.0.1:8443"
+	// (REQUIRED)
+	Addr string
+
+	// ClientAuth is the policy for TLS client certificates (mTLS) on
+	// this listener
+	// Default value is tls.NoClientCert
+	ClientAuth tls.ClientAuthType
+
+	// ClientCAs are the certificate authorities client certificates are
+	// verified against on this listener
+	// Default value is the server's ClientCAs
+	ClientCAs *x509.CertPool
+
+	// MinVersion is the minimum TLS version accepted on this listener
+	// Default value is that of crypto/tls
+	MinVersion uint16
+
+	// Handler serves the requests received on this listener, behind the
+	// server's middleware. It is not replaced by SetHandler
+	// Default value is the server's Handler
+	Handler http.Handler
+}
+
+// validateListeners returns ErrInvalidListenerConfig if any of the
+// listeners lacks an address
+func validateListeners(listeners []ListenerConfig) error {
+	for i, lc := range listeners {
+		if lc.Addr == "" {
+			return fmt.Errorf("%w: listener %d has no address", ErrInvalidListenerConfig, i)
+		}
+	}
+	return nil
+}
+
+// handlerListener tags the connections it accepts with the handler
+// serving their requests
+type handlerListener struct {
+	net.Listener
+	handler http.Handler
+}
+
+// handlerConn is a connection whose requests are served by its handler
+type handlerConn struct {
+	net.Conn
+	handler http.Handler
+}
+
+// NetConn returns the underlying connection
+func (hc *handlerConn) NetConn() net.Conn {
+	return hc.Conn
+}
+
+// Accept returns the next connection tagged with the listener's handler
+func (hl *handlerListener) Accept() (net.Conn, error) {
+	c, err := hl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &handlerConn{Conn: c, handler: hl.handler}, nil
+}
+
+// listenerConnContext is the http.Server.ConnContext hook carrying the
+// handler of connections accepted by a handlerListener into the context
+// of their requests
+func listenerConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if hc, ok := c.(*handlerConn); ok && hc.handler != nil {
+		return context.WithValue(ctx, listenerHandlerKey, hc.handler)
+	}
+	return ctx
+}
+
+// byListener serves requests with the handler of the listener they were
+// received on, if any, and with h otherwise
+func byListener(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lh, ok := r.Context().Value(listenerHandlerKey).(http.Handler); ok {
+			lh.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serveListener serves HTTPS on l, one of the additional Listeners, in
+// the background
+func (ss *SecureServer) serveListener(l net.Listener, lc ListenerConfig) {
+	config := ss.tlsConfig()
+	config.ClientAuth = lc.ClientAuth
+	if lc.ClientCAs != nil {
+		config.ClientCAs = lc.ClientCAs
+	}
+	config.MinVersion = lc.MinVersion
+	inner := &handlerListener{Listener: l, handler: lc.Handler}
+	served := tls.NewListener(inner, config)
+	if ss.tlsHandshakeTimeout > 0 {
+		served = newHandshakeListener(inner, config, ss.tlsHandshakeTimeout)
+	}
+	go func() {
+		ss.logger.Printf("[sslmgr] serving https at %s", l.Addr())
+		if err := ss.server.Serve(served); !servingStopped(err) {
+			log.Fatalf("[sslmgr] Serve() failed with %s", err)
+		}
+	}()
+}
@@ -0,0 +1,155 @@
+package sslmgr
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testTLSConfig returns a server tls.Config serving a self-signed
+// certificate for host
+func testTLSConfig(host string) *tls.Config {
+	certPEM, keyPEM := testCertPEM(host, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		panic(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2", "http/1.1"}}
+}
+
+func TestListener(t *testing.T) {
+	Convey("Test handshakeListener", t, func() {
+		inner, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		config := testTLSConfig("yourdomain.io")
+		hl := newHandshakeListener(inner, config, 200*time.Millisecond)
+		srv := &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.TLS == nil {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}),
+			TLSConfig: config,
+		}
+		go srv.Serve(hl)
+		defer srv.Close()
+
+		Convey("Test Completed Handshakes Are Served", func() {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true, ServerName: "yourdomain.io"},
+			}}
+			resp, err := client.Get("https://" + inner.Addr().String())
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+		Convey("Test Stalled Handshakes Are Dropped", func() {
+			conn, err := net.Dial("tcp", inner.Addr().String())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			start := time.Now()
+			_, err = conn.Read(make([]byte, 1))
+			So(err, ShouldEqual, io.EOF)
+			So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+		})
+		Convey("Test Close Stops Accepting", func() {
+			So(hl.Close(), ShouldBeNil)
+			_, err := hl.Accept()
+			So(err, ShouldNotBeNil)
+		})
+	})
+	Convey("Test TCPKeepAlive", t, func() {
+		newServer := func(period time.Duration) *SecureServer {
+			ss, err := NewServer(ServerConfig{
+				Handler:      http.NotFoundHandler(),
+				Hostnames:    []string{"yourdomain.io"},
+				TCPKeepAlive: period,
+			})
+			So(err, ShouldBeNil)
+			return ss
+		}
+		Convey("Test Listeners Are Left Alone By Default", func() {
+			l, err := newServer(0).listen("127.0.0.1:0")
+			So(err, ShouldBeNil)
+			defer l.Close()
+			_, wrapped := l.(*keepAliveListener)
+			So(wrapped, ShouldBeFalse)
+		})
+		for _, period := range []time.Duration{30 * time.Second, -1} {
+			Convey(fmt.Sprintf("Test Accepted Connections Are Set Up With %s", period), func() {
+				l, err := newServer(period).listen("127.0.0.1:0")
+				So(err, ShouldBeNil)
+				defer l.Close()
+				So(l.(*keepAliveListener).period, ShouldEqual, period)
+				go func() {
+					if c, err := net.Dial("tcp", l.Addr().String()); err == nil {
+						c.Close()
+					}
+				}()
+				c, err := l.Accept()
+				So(err, ShouldBeNil)
+				c.Close()
+			})
+		}
+	})
+}
+
+func TestListeners(t *testing.T) {
+	Convey("Test Listeners", t, func() {
+		httpsPort, internalPort := freePort(), freePort()
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "external")
+			}),
+			HTTPPort:     freePort(),
+			HTTPSPort:    httpsPort,
+			Certificates: testTLSConfig("yourdomain.io").Certificates,
+			Listeners: []ListenerConfig{{
+				Addr:       internalPort,
+				ClientAuth: tls.RequireAnyClientCert,
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					io.WriteString(w, fmt.Sprintf("internal %s", r.TLS.PeerCertificates[0].Subject.CommonName))
+				}),
+			}},
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		get := func(port string, clientCerts []tls.Certificate) (string, error) {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: clientCerts},
+			}}
+			resp, err := client.Get("https://localhost" + port)
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			return string(body), err
+		}
+		body, err := get(httpsPort, nil)
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "external")
+		_, err = get(internalPort, nil)
+		So(err, ShouldNotBeNil)
+		body, err = get(internalPort, testTLSConfig("client").Certificates)
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "internal client")
+		Convey("Test Listeners Require An Address", func() {
+			_, err := NewServer(ServerConfig{
+				Handler:   http.NotFoundHandler(),
+				Hostnames: []string{"yourdomain.io"},
+				Listeners: []ListenerConfig{{ClientAuth: tls.RequireAnyClientCert}},
+			})
+			So(errors.Is(err, ErrInvalidListenerConfig), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,146 @@
+package sslmgr
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Logger is the interface through which the server reports its operation.
+// A *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// stdLogger writes to the standard library's global logger, so that
+// its output honors log.SetOutput and log.SetFlags
+type stdLogger struct{}
+
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+// ShutdownLogger may be implemented by a Logger to receive the events of
+// the server's graceful shutdown as they are, rather than pre-formatted
+// messages through Printf. This allows for rendering (or suppressing)
+// them in any way, e.g. for localized logs or log parsing pipelines
+type ShutdownLogger interface {
+	LogShutdown(e ShutdownEvent)
+}
+
+// ShutdownPhase identifies a step of the server's graceful shutdown
+type ShutdownPhase int
+
+const (
+	// ShutdownDraining is reported once a shutdown begins, after which
+	// existing connections are drained
+	ShutdownDraining ShutdownPhase = iota
+	// ShutdownFailed is reported when connections could not be drained
+	ShutdownFailed
+	// ShutdownComplete is reported once the server is closed
+	ShutdownComplete
+	// ShutdownClosing is reported once a shutdown begins which closes
+	// existing connections rather than draining them, as configured by
+	// the ShutdownImmediate strategy
+	ShutdownClosing
+	// ShutdownForced is reported once the server is closed after a
+	// ShutdownClosing event, in-flight connections having been cut
+	ShutdownForced
+)
+
+// ShutdownEvent describes a step of the server's graceful shutdown
+type ShutdownEvent struct {
+	Phase ShutdownPhase
+	// Signal is the OS signal a ShutdownDraining (or ShutdownClosing)
+	// event was caused by, or nil when the shutdown was triggered through
+	// TriggerShutdown
+	Signal os.Signal
+	// Reason is the reason given to TriggerShutdown, if any
+	Reason string
+	// Err is the error draining a ShutdownFailed event failed with
+	Err error
+}
+
+// String renders the event as the message logged through Printf
+func (e ShutdownEvent) String() string {
+	switch e.Phase {
+	case ShutdownDraining:
+		if e.Signal != nil {
+			return "shutdown signal received, draining existing connections..."
+		}
+		return fmt.Sprintf("shutdown triggered (%s), draining existing connections...", e.Reason)
+	case ShutdownClosing:
+		return "shutdown signal received, closing existing connections immediately..."
+	case ShutdownForced:
+		return "server was closed, existing connections were cut"
+	case ShutdownFailed:
+		return fmt.Sprintf("server could not be shutdown gracefully: %s", e.Err)
+	default:
+		return "server was closed successfully with no service interruptions"
+	}
+}
+
+// errorLog returns a *log.Logger writing to l, for net/http's own errors
+// and warnings (e.g. TLS handshake failures or panics in handlers when
+// panic recovery is disabled) to go through the server's Logger
+func errorLog(l Logger) *log.Logger {
+	return log.New(logWriter{l}, "", 0)
+}
+
+// logWriter writes every message it is given to a Logger
+type logWriter struct {
+	logger Logger
+}
+
+func (lw logWriter) Write(p []byte) (int, error) {
+	lw.logger.Printf("[sslmgr] %s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// CertLogger may be implemented by a Logger to receive the certificates
+// logged with LogCertFingerprints as CertEvents, rather than pre-formatted
+// messages through Printf, e.g. for feeding them to an audit trail
+type CertLogger interface {
+	LogCert(e CertEvent)
+}
+
+// CertEvent describes a certificate served for the first time
+type CertEvent struct {
+	// Host is the hostname the certificate was first served for
+	Host string
+	// Fingerprint is the hex encoded SHA-256 digest of the certificate
+	Fingerprint string
+	// SerialNumber is the certificate's serial number, in hex
+	SerialNumber string
+	// SANs are the DNS names and IP addresses the certificate is valid for
+	SANs      []string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// String renders the event as the message logged through Printf
+func (e CertEvent) String() string {
+	return fmt.Sprintf("serving certificate host=%s sha256=%s serial=%s sans=%s not_before=%s not_after=%s",
+		e.Host, e.Fingerprint, e.SerialNumber, strings.Join(e.SANs, ","),
+		e.NotBefore.UTC().Format(time.RFC3339), e.NotAfter.UTC().Format(time.RFC3339))
+}
+
+// logCert reports a certificate event to the server's logger
+func (ss *SecureServer) logCert(e CertEvent) {
+	if cl, ok := ss.logger.(CertLogger); ok {
+		cl.LogCert(e)
+		return
+	}
+	ss.logger.Printf("[sslmgr] %s", e)
+}
+
+// logShutdown reports a shutdown event to the server's logger
+func (ss *SecureServer) logShutdown(e ShutdownEvent) {
+	if sl, ok := ss.logger.(ShutdownLogger); ok {
+		sl.LogShutdown(e)
+		return
+	}
+	ss.logger.Printf("[sslmgr] %s", e)
+}
@@ -0,0 +1,85 @@
+package sslmgr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testLogger records every logged message
+type testLogger struct {
+	sync.Mutex
+	lines []string
+}
+
+func (tl *testLogger) Printf(format string, v ...interface{}) {
+	tl.Lock()
+	defer tl.Unlock()
+	tl.lines = append(tl.lines, fmt.Sprintf(format, v...))
+}
+
+// String returns every logged message, one per line
+func (tl *testLogger) String() string {
+	tl.Lock()
+	defer tl.Unlock()
+	return strings.Join(tl.lines, "\n")
+}
+
+// shutdownLogger records shutdown events
+type shutdownLogger struct {
+	testLogger
+	events chan ShutdownEvent
+}
+
+func (sl *shutdownLogger) LogShutdown(e ShutdownEvent) {
+	sl.events <- e
+}
+
+// certLogger records certificate events
+type certLogger struct {
+	testLogger
+	events []CertEvent
+}
+
+func (cl *certLogger) LogCert(e CertEvent) {
+	cl.events = append(cl.events, e)
+}
+
+func TestLogger(t *testing.T) {
+	Convey("Test ShutdownEvent Messages", t, func() {
+		So(ShutdownEvent{Phase: ShutdownDraining, Signal: syscall.SIGTERM}.String(), ShouldEqual, "shutdown signal received, draining existing connections...")
+		So(ShutdownEvent{Phase: ShutdownDraining, Reason: "unhealthy"}.String(), ShouldEqual, "shutdown triggered (unhealthy), draining existing connections...")
+		So(ShutdownEvent{Phase: ShutdownFailed, Err: errors.New("boom")}.String(), ShouldEqual, "server could not be shutdown gracefully: boom")
+		So(ShutdownEvent{Phase: ShutdownComplete}.String(), ShouldEqual, "server was closed successfully with no service interruptions")
+		So(ShutdownEvent{Phase: ShutdownClosing, Signal: syscall.SIGINT}.String(), ShouldEqual, "shutdown signal received, closing existing connections immediately...")
+		So(ShutdownEvent{Phase: ShutdownForced}.String(), ShouldEqual, "server was closed, existing connections were cut")
+	})
+	Convey("Test Shutdown Events Reach ShutdownLogger", t, func() {
+		logger := &shutdownLogger{events: make(chan ShutdownEvent, 3)}
+		ss, err := NewServer(ServerConfig{
+			Handler:      http.NotFoundHandler(),
+			Hostnames:    []string{"yourdomain.io"},
+			HTTPPort:     ":0",
+			ServeSSLFunc: func() bool { return false },
+			Logger:       logger,
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		ss.TriggerShutdown("unhealthy")
+		select {
+		case <-ss.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("server was not shut down")
+		}
+		So(<-logger.events, ShouldResemble, ShutdownEvent{Phase: ShutdownDraining, Reason: "unhealthy"})
+		So(<-logger.events, ShouldResemble, ShutdownEvent{Phase: ShutdownComplete})
+		So(logger.String(), ShouldNotContainSubstring, "draining")
+	})
+}
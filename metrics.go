@@ -0,0 +1,48 @@
+package sslmgr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// CertMetricsHandler returns a handler rendering the expiry of each
+// configured hostname's cached certificate in the Prometheus text format,
+// as the sslmgr_cert_not_after_seconds gauge. Hostnames without a cached
+// certificate are left out. The handler is meant to be mounted on an
+// internal listener of the user's choosing
+func (ss *SecureServer) CertMetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		fmt.Fprintln(w, "# HELP sslmgr_cert_not_after_seconds Expiry of the cached certificate as a unix timestamp.")
+		fmt.Fprintln(w, "# TYPE sslmgr_cert_not_after_seconds gauge")
+		for _, host := range ss.hostnames {
+			notAfter, ok := ss.cachedExpiry(r.Context(), host)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(w, "sslmgr_cert_not_after_seconds{host=%q} %d\n", host, notAfter.Unix())
+		}
+	})
+}
+
+// cachedExpiry returns the latest expiry among the certificates cached
+// for host, whether or not they are still valid
+func (ss *SecureServer) cachedExpiry(ctx context.Context, host string) (time.Time, bool) {
+	var latest time.Time
+	for _, key := range []string{host, host + "+rsa"} {
+		data, err := ss.certMgr.Cache.Get(ctx, key)
+		if err != nil {
+			continue
+		}
+		cert, err := decodeCachedCert(data)
+		if err != nil {
+			continue
+		}
+		if cert.Leaf.NotAfter.After(latest) {
+			latest = cert.Leaf.NotAfter
+		}
+	}
+	return latest, !latest.IsZero()
+}
@@ -0,0 +1,37 @@
+package sslmgr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("Test CertMetricsHandler()", t, func() {
+		now := time.Now().Truncate(time.Second)
+		cache := newMemCache()
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io", "otherdomain.io", "expired.io"},
+			CertCache: cache,
+		})
+		So(err, ShouldBeNil)
+		cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+		cache.Put(context.Background(), "expired.io+rsa", testCacheEntry("expired.io", now.Add(-2*time.Hour), now.Add(-time.Hour)))
+
+		rec := httptest.NewRecorder()
+		ss.CertMetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(rec.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+		body := rec.Body.String()
+		So(body, ShouldContainSubstring, "# TYPE sslmgr_cert_not_after_seconds gauge")
+		So(body, ShouldContainSubstring, fmt.Sprintf(`sslmgr_cert_not_after_seconds{host="yourdomain.io"} %d`, now.Add(time.Hour).Unix()))
+		So(body, ShouldContainSubstring, fmt.Sprintf(`sslmgr_cert_not_after_seconds{host="expired.io"} %d`, now.Add(-time.Hour).Unix()))
+		So(body, ShouldNotContainSubstring, "otherdomain.io")
+	})
+}
@@ -0,0 +1,462 @@
+package sslmgr
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// contextKey is the type of all context keys set by sslmgr
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	shutdownKey
+	goroutineDumpKey
+	listenerHandlerKey
+)
+
+// Middleware wraps an http.Handler with additional behavior
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in the given middleware, the first of which is the
+// outermost (i.e. the first to see requests)
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// wrapHandler applies the middleware enabled in the config around h,
+// which is checked for superfluous WriteHeader calls
+func wrapHandler(h http.Handler, c ServerConfig) http.Handler {
+	return Chain(withHeaderCheck(h, c.Logger), middlewareFor(c)...)
+}
+
+// middlewareFor returns the middleware enabled in the config, outermost
+// first. sslmgr's own middleware runs before the user's Middlewares, so
+// that e.g. panics in the latter are recovered from
+func middlewareFor(c ServerConfig) []Middleware {
+	var mw []Middleware
+	if c.RequestIDHeader != "" {
+		mw = append(mw, func(h http.Handler) http.Handler { return withRequestID(h, c.RequestIDHeader) })
+	}
+	if len(c.AllowedCIDRs) > 0 || len(c.DeniedCIDRs) > 0 {
+		mw = append(mw, func(h http.Handler) http.Handler {
+			return withIPFilter(h, c.AllowedCIDRs, c.DeniedCIDRs, c.TrustedProxies)
+		})
+	}
+	if policy := hostCheckPolicy(c); c.RequireHostHeader && policy != nil {
+		mw = append(mw, func(h http.Handler) http.Handler { return withHostCheck(h, policy) })
+	}
+	if c.GRPCHandler != nil {
+		// gRPC requests are access controlled, but skip the rest
+		mw = append(mw, func(h http.Handler) http.Handler { return withGRPC(h, c.GRPCHandler) })
+	}
+	if c.CORS.Enabled {
+		mw = append(mw, func(h http.Handler) http.Handler { return withCORS(h, c.CORS) })
+	}
+	if c.MaxConcurrentRequests > 0 {
+		mw = append(mw, func(h http.Handler) http.Handler {
+			return withConcurrencyLimit(h, c.MaxConcurrentRequests, c.ConcurrencyQueueTimeout)
+		})
+	}
+	if c.SlowRequestThreshold > 0 {
+		mw = append(mw, func(h http.Handler) http.Handler { return withSlowRequests(h, c.SlowRequestThreshold, c.OnSlowRequest) })
+	}
+	if c.RequestTimeout > 0 {
+		mw = append(mw, func(h http.Handler) http.Handler { return withRequestTimeout(h, c.RequestTimeout) })
+	}
+	if c.MaxRequestBodySize > 0 {
+		mw = append(mw, func(h http.Handler) http.Handler { return withBodyLimit(h, c.MaxRequestBodySize) })
+	}
+	if c.EnablePprof {
+		mw = append(mw, func(h http.Handler) http.Handler { return withPprof(h, c.PprofPrefix, c.PprofAuth) })
+	}
+	if c.ServerHeader != "" {
+		mw = append(mw, func(h http.Handler) http.Handler { return withServerHeader(h, c.ServerHeader) })
+	}
+	if c.EnableSecurityHeaders {
+		mw = append(mw, func(h http.Handler) http.Handler { return withSecurityHeaders(h, c.SecurityHeaders) })
+	}
+	if c.Compression.Enabled {
+		mw = append(mw, func(h http.Handler) http.Handler { return withCompression(h, c.Compression) })
+	}
+	if c.RobotsTxt != "" || c.SecurityTxt != "" {
+		files := make(map[string]string)
+		if c.RobotsTxt != "" {
+			files["/robots.txt"] = c.RobotsTxt
+		}
+		if c.SecurityTxt != "" {
+			files["/.well-known/security.txt"] = c.SecurityTxt
+		}
+		mw = append(mw, func(h http.Handler) http.Handler { return withTextFiles(h, files) })
+	}
+	if c.NotFoundHandler != nil {
+		mw = append(mw, func(h http.Handler) http.Handler { return withNotFound(h, c.NotFoundHandler) })
+	}
+	if !c.DisablePanicRecovery {
+		mw = append(mw, func(h http.Handler) http.Handler {
+			return withRecovery(h, c.Logger, c.PanicHandler, c.OnPanicDumpGoroutines)
+		})
+	}
+	return append(mw, c.Middlewares...)
+}
+
+// withRecovery recovers from panics in h, logging the panic along with
+// its stack trace (or a dump of all goroutines) and handing the request
+// over to the panic handler
+func withRecovery(h http.Handler, logger Logger, panicHandler func(http.ResponseWriter, *http.Request, interface{}), dumpGoroutines bool) http.Handler {
+	if panicHandler == nil {
+		panicHandler = defaultPanicHandler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered) // deliberate abort, let net/http handle it
+			}
+			if !dumpGoroutines {
+				logger.Printf("[sslmgr] panic serving %s %s: %v\n%s", r.Method, r.URL.Path, recovered, debug.Stack())
+				panicHandler(w, r, recovered)
+				return
+			}
+			dump := goroutineDump()
+			logger.Printf("[sslmgr] panic serving %s %s: %v\n%s", r.Method, r.URL.Path, recovered, dump)
+			panicHandler(w, r.WithContext(context.WithValue(r.Context(), goroutineDumpKey, dump)), recovered)
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// goroutineDump returns the stack traces of all goroutines, the calling
+// one first
+func goroutineDump() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// GoroutineDumpFromContext returns the dump of all goroutines captured
+// when the handler of a request panicked, given the request's context as
+// passed to the PanicHandler. Dumps are only captured when the server's
+// OnPanicDumpGoroutines is set, otherwise nil is returned
+func GoroutineDumpFromContext(ctx context.Context) []byte {
+	dump, _ := ctx.Value(goroutineDumpKey).([]byte)
+	return dump
+}
+
+// defaultPanicHandler responds to requests whose handler panicked
+// with a plain 500 Internal Server Error
+func defaultPanicHandler(w http.ResponseWriter, r *http.Request, recovered interface{}) {
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// withSlowRequests times every request, handing those which took longer
+// than threshold to serve over to onSlow
+func withSlowRequests(h http.Handler, threshold time.Duration, onSlow func(*http.Request, time.Duration)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		h.ServeHTTP(w, r)
+		if d := time.Since(start); d > threshold {
+			onSlow(r, d)
+		}
+	})
+}
+
+// withRequestLimit returns a Middleware counting the requests served by
+// all of the handlers it wraps, calling onLimit once max is reached
+func withRequestLimit(max int64, onLimit func()) Middleware {
+	var served int64
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r)
+			if atomic.AddInt64(&served, 1) == max {
+				onLimit()
+			}
+		})
+	}
+}
+
+// withConcurrencyLimit serves up to max requests with h at once, making
+// requests over the limit wait up to queueTimeout for a slot, and
+// responding 503 Service Unavailable to those which get none
+func withConcurrencyLimit(h http.Handler, max int, queueTimeout time.Duration) http.Handler {
+	slots := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			if !awaitSlot(r.Context(), slots, queueTimeout) {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		defer func() { <-slots }()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// awaitSlot waits up to timeout to take one of the slots, giving up early
+// when ctx is done, and reports whether it took one
+func awaitSlot(ctx context.Context, slots chan struct{}, timeout time.Duration) bool {
+	if timeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// withTextFiles serves GET and HEAD requests for the exact paths of files
+// with their plain text content, and all other requests with h, so that
+// e.g. ACME challenges under /.well-known/ are never shadowed
+func withTextFiles(h http.Handler, files map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == http.MethodGet {
+			io.WriteString(w, content)
+		}
+	})
+}
+
+// withRequestTimeout bounds the context of every request by timeout,
+// leaving it to the handler to respond once the context is done
+func withRequestTimeout(h http.Handler, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cncl := context.WithTimeout(r.Context(), timeout)
+		defer cncl()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withBodyLimit responds 413 Request Entity Too Large to requests declaring
+// a body larger than max, without reading it, and caps the bytes the
+// handler may read from bodies of undeclared length
+func withBodyLimit(h http.Handler, max int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			w.Header().Set("Connection", "close")
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// defaultSecurityHeaders are the headers added to HTTPS responses when
+// security headers are enabled without configuring any
+var defaultSecurityHeaders = map[string]string{
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "DENY",
+	"Referrer-Policy":         "strict-origin-when-cross-origin",
+	"Content-Security-Policy": "frame-ancestors 'none'",
+}
+
+// withSecurityHeaders adds the given headers to every response served
+// over HTTPS, leaving alone any header already set by the handler
+func withSecurityHeaders(h http.Handler, headers map[string]string) http.Handler {
+	if headers == nil {
+		headers = defaultSecurityHeaders
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		hw := &headerHookWriter{ResponseWriter: w, hook: func(hdr http.Header) {
+			for k, v := range headers {
+				if hdr.Get(k) == "" {
+					hdr.Set(k, v)
+				}
+			}
+		}}
+		h.ServeHTTP(hw, r)
+		hw.runHook()
+	})
+}
+
+// SuppressServerHeader is the ServerHeader value which removes the
+// Server header from responses
+const SuppressServerHeader = "-"
+
+// withServerHeader sets the Server header of every response to value,
+// or removes it if value is SuppressServerHeader
+func withServerHeader(h http.Handler, value string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &headerHookWriter{ResponseWriter: w, hook: func(hdr http.Header) {
+			if value == SuppressServerHeader {
+				hdr.Del("Server")
+				return
+			}
+			hdr.Set("Server", value)
+		}}
+		h.ServeHTTP(hw, r)
+		hw.runHook()
+	})
+}
+
+// headerHookWriter runs a hook on the response headers right before
+// they are written, once the handler has had its chance to set them
+type headerHookWriter struct {
+	http.ResponseWriter
+	hook   func(http.Header)
+	hooked bool
+}
+
+func (hw *headerHookWriter) runHook() {
+	if !hw.hooked {
+		hw.hooked = true
+		hw.hook(hw.Header())
+	}
+}
+
+func (hw *headerHookWriter) WriteHeader(status int) {
+	hw.runHook()
+	hw.ResponseWriter.WriteHeader(status)
+}
+
+func (hw *headerHookWriter) Write(p []byte) (int, error) {
+	hw.runHook()
+	return hw.ResponseWriter.Write(p)
+}
+
+// Flush sends whatever has been written so far to the client
+func (hw *headerHookWriter) Flush() {
+	hw.runHook()
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection, e.g. for WebSockets
+func (hw *headerHookWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(hw.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (hw *headerHookWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// withHeaderCheck logs superfluous WriteHeader calls made by h, along
+// with the request they were made for, rather than leaving them to
+// net/http's context-less warning
+func withHeaderCheck(h http.Handler, logger Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&headerCheckWriter{ResponseWriter: w, r: r, logger: logger}, r)
+	})
+}
+
+// headerCheckWriter drops (and logs) WriteHeader calls made once the
+// response status was written
+type headerCheckWriter struct {
+	http.ResponseWriter
+	r      *http.Request
+	logger Logger
+	status int
+}
+
+func (cw *headerCheckWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		cw.logger.Printf("[sslmgr] superfluous WriteHeader(%d) call for %s %s, status %d was already written", status, cw.r.Method, cw.r.URL.Path, cw.status)
+		return
+	}
+	if status >= 200 {
+		// informational (1xx) responses may precede the final status
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *headerCheckWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends whatever has been written so far to the client
+func (cw *headerCheckWriter) Flush() {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection, e.g. for WebSockets
+func (cw *headerCheckWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (cw *headerCheckWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// RequestIDFromContext returns the request ID assigned to a request by
+// the server, or an empty string if none was assigned. Request IDs are
+// only assigned when the server's RequestIDHeader is configured
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// withRequestID tags every request with the ID found in the given header,
+// or a newly generated one if absent. The ID is stored in the request's
+// context and echoed back in the same response header
+func withRequestID(h http.Handler, header string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(header)
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set(header, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// newRequestID returns a random (version 4) UUID
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("sslmgr: could not generate request id: %s", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
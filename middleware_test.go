@@ -0,0 +1,438 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMiddleware(t *testing.T) {
+	Convey("Test withRequestID()", t, func() {
+		var seen string
+		h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = RequestIDFromContext(r.Context())
+		}), "X-Request-ID")
+		Convey("Test Incoming ID Is Propagated", func() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Request-ID", "abc123")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			So(seen, ShouldEqual, "abc123")
+			So(rec.Header().Get("X-Request-ID"), ShouldEqual, "abc123")
+		})
+		Convey("Test Missing ID Is Generated", func() {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(seen, ShouldHaveLength, 36)
+			So(rec.Header().Get("X-Request-ID"), ShouldEqual, seen)
+		})
+		Convey("Test No ID Without Middleware", func() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			So(RequestIDFromContext(req.Context()), ShouldBeEmpty)
+		})
+	})
+	Convey("Test withSecurityHeaders()", t, func() {
+		h := withSecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+			w.Write([]byte("ok"))
+		}), nil)
+		Convey("Test Defaults Are Added Over HTTPS", func() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.TLS = &tls.ConnectionState{}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			So(rec.Header().Get("X-Content-Type-Options"), ShouldEqual, "nosniff")
+			So(rec.Header().Get("Referrer-Policy"), ShouldNotBeEmpty)
+			So(rec.Header().Get("X-Frame-Options"), ShouldEqual, "SAMEORIGIN")
+		})
+		Convey("Test Headers Are Added When Handler Writes Nothing", func() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.TLS = &tls.ConnectionState{}
+			rec := httptest.NewRecorder()
+			withSecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), map[string]string{
+				"X-Custom": "yes",
+			}).ServeHTTP(rec, req)
+			So(rec.Header().Get("X-Custom"), ShouldEqual, "yes")
+			So(rec.Header().Get("X-Content-Type-Options"), ShouldBeEmpty)
+		})
+		Convey("Test Plain HTTP Is Untouched", func() {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(rec.Header().Get("X-Content-Type-Options"), ShouldBeEmpty)
+		})
+	})
+	Convey("Test withRecovery()", t, func() {
+		logger := &testLogger{}
+		var recovered interface{}
+		h := withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}), logger, func(w http.ResponseWriter, r *http.Request, rec interface{}) {
+			recovered = rec
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, false)
+		Convey("Test Panic Is Handled And Logged", func() {
+			rec := httptest.NewRecorder()
+			So(func() { h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil)) }, ShouldNotPanic)
+			So(recovered, ShouldEqual, "boom")
+			So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(logger.String(), ShouldContainSubstring, "boom")
+			So(logger.String(), ShouldContainSubstring, "goroutine")
+		})
+		Convey("Test Panic Handler Gets No Goroutine Dump By Default", func() {
+			var dump []byte
+			withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			}), logger, func(w http.ResponseWriter, r *http.Request, rec interface{}) {
+				dump = GoroutineDumpFromContext(r.Context())
+			}, false).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			So(dump, ShouldBeNil)
+		})
+		Convey("Test Goroutine Dump Is Captured", func() {
+			blocked := make(chan struct{})
+			defer close(blocked)
+			go func() { <-blocked }()
+			var dump []byte
+			withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			}), logger, func(w http.ResponseWriter, r *http.Request, rec interface{}) {
+				dump = GoroutineDumpFromContext(r.Context())
+			}, true).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			So(string(dump), ShouldContainSubstring, "[chan receive]")
+			So(logger.String(), ShouldContainSubstring, "[chan receive]")
+		})
+		Convey("Test Default Panic Handler", func() {
+			rec := httptest.NewRecorder()
+			withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			}), logger, nil, false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+		})
+		Convey("Test Aborts Are Not Recovered", func() {
+			abort := withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic(http.ErrAbortHandler)
+			}), logger, func(w http.ResponseWriter, r *http.Request, rec interface{}) {}, false)
+			So(func() {
+				abort.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}, ShouldPanic)
+		})
+	})
+	Convey("Test Panic Recovery Is Enabled By Default", t, func() {
+		panicky := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})
+		ss, err := NewServer(ServerConfig{
+			Handler:   panicky,
+			Hostnames: []string{"yourdomain.io"},
+			Logger:    &testLogger{},
+		})
+		So(err, ShouldBeNil)
+		rec := httptest.NewRecorder()
+		So(func() { ss.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil)) }, ShouldNotPanic)
+		So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+		Convey("Test Panic Recovery Can Be Disabled", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:              panicky,
+				Hostnames:            []string{"yourdomain.io"},
+				DisablePanicRecovery: true,
+			})
+			So(err, ShouldBeNil)
+			So(func() {
+				ss.server.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}, ShouldPanic)
+		})
+	})
+	Convey("Test withBodyLimit()", t, func() {
+		var called bool
+		var readErr error
+		h := withBodyLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			_, readErr = io.ReadAll(r.Body)
+		}), 4)
+		Convey("Test Oversized Content-Length Is Rejected Early", func() {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too large"))
+			req.Header.Set("Expect", "100-continue")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(called, ShouldBeFalse)
+		})
+		Convey("Test Undeclared Length Is Capped", func() {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too large"))
+			req.ContentLength = -1
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			So(called, ShouldBeTrue)
+			So(readErr, ShouldNotBeNil)
+		})
+		Convey("Test Small Body Is Passed Through", func() {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("ok"))
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			So(called, ShouldBeTrue)
+			So(readErr, ShouldBeNil)
+		})
+	})
+	Convey("Test withConcurrencyLimit()", t, func() {
+		entered := make(chan struct{})
+		release := make(chan struct{})
+		blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/block" {
+				entered <- struct{}{}
+				<-release
+			}
+		})
+		serve := func(h http.Handler, path string) chan int {
+			code := make(chan int, 1)
+			go func() {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				code <- rec.Code
+			}()
+			return code
+		}
+		Convey("Test Requests Over The Limit Are Rejected", func() {
+			h := withConcurrencyLimit(blocking, 1, 0)
+			blocked := serve(h, "/block")
+			<-entered
+			So(<-serve(h, "/"), ShouldEqual, http.StatusServiceUnavailable)
+			close(release)
+			So(<-blocked, ShouldEqual, http.StatusOK)
+			So(<-serve(h, "/"), ShouldEqual, http.StatusOK)
+		})
+		Convey("Test Queued Requests Are Served Once A Slot Frees Up", func() {
+			h := withConcurrencyLimit(blocking, 1, time.Second)
+			blocked := serve(h, "/block")
+			<-entered
+			queued := serve(h, "/")
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			So(<-blocked, ShouldEqual, http.StatusOK)
+			So(<-queued, ShouldEqual, http.StatusOK)
+		})
+		Convey("Test Queued Requests Time Out", func() {
+			defer close(release)
+			h := withConcurrencyLimit(blocking, 1, 50*time.Millisecond)
+			serve(h, "/block")
+			<-entered
+			start := time.Now()
+			So(<-serve(h, "/"), ShouldEqual, http.StatusServiceUnavailable)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+		})
+	})
+	Convey("Test RobotsTxt And SecurityTxt", t, func() {
+		cache := newMemCache()
+		cache.Put(context.Background(), "token+http-01", []byte("token.thumbprint"))
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}),
+			Hostnames:   []string{"yourdomain.io"},
+			CertCache:   cache,
+			HTTPPort:    ":0",
+			HTTPSPort:   ":0",
+			RobotsTxt:   "User-agent: *\nDisallow: /admin\n",
+			SecurityTxt: "Contact: mailto:security@yourdomain.io\n",
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		serve := func(method, path string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			ss.httpServer.Handler.ServeHTTP(rec, httptest.NewRequest(method, "http://yourdomain.io"+path, nil))
+			return rec
+		}
+		rec := serve(http.MethodGet, "/robots.txt")
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(rec.Header().Get("Content-Type"), ShouldEqual, "text/plain; charset=utf-8")
+		So(rec.Body.String(), ShouldEqual, "User-agent: *\nDisallow: /admin\n")
+		rec = serve(http.MethodHead, "/.well-known/security.txt")
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		So(rec.Body.String(), ShouldBeEmpty)
+		So(serve(http.MethodGet, "/.well-known/security.txt").Body.String(), ShouldEqual, "Contact: mailto:security@yourdomain.io\n")
+		Convey("Test Other Paths Reach The Handler", func() {
+			So(serve(http.MethodPost, "/robots.txt").Code, ShouldEqual, http.StatusTeapot)
+			So(serve(http.MethodGet, "/.well-known/other").Code, ShouldEqual, http.StatusTeapot)
+			So(serve(http.MethodGet, "/.well-known/acme-challenge/token").Body.String(), ShouldEqual, "token.thumbprint")
+		})
+	})
+	Convey("Test Superfluous WriteHeader Calls Are Logged", t, func() {
+		logger := &testLogger{}
+		port := freePort()
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/panic" {
+					panic("boom")
+				}
+				w.WriteHeader(http.StatusTeapot)
+				w.WriteHeader(http.StatusInternalServerError)
+			}),
+			Hostnames:            []string{"yourdomain.io"},
+			HTTPPort:             port,
+			ServeSSLFunc:         func() bool { return false },
+			Logger:               logger,
+			DisablePanicRecovery: true,
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		resp, err := http.Get("http://localhost" + port + "/double")
+		So(err, ShouldBeNil)
+		resp.Body.Close()
+		So(resp.StatusCode, ShouldEqual, http.StatusTeapot)
+		So(logger.String(), ShouldContainSubstring, "[sslmgr] superfluous WriteHeader(500) call for GET /double, status 418 was already written")
+		Convey("Test net/http Errors Reach The Logger", func() {
+			_, err := http.Get("http://localhost" + port + "/panic")
+			So(err, ShouldNotBeNil)
+			So(logger.String(), ShouldContainSubstring, "[sslmgr] http: panic serving")
+		})
+		Convey("Test Informational Responses Are Not Superfluous", func() {
+			rec := httptest.NewRecorder()
+			withHeaderCheck(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusEarlyHints)
+				w.WriteHeader(http.StatusNoContent)
+			}), logger).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hints", nil))
+			So(logger.String(), ShouldNotContainSubstring, "/hints")
+		})
+	})
+	Convey("Test Chain()", t, func() {
+		var order []string
+		tag := func(name string) Middleware {
+			return func(h http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					order = append(order, name)
+					h.ServeHTTP(w, r)
+				})
+			}
+		}
+		h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "handler")
+		}), tag("outer"), tag("inner"))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		So(order, ShouldResemble, []string{"outer", "inner", "handler"})
+	})
+	Convey("Test Middlewares Run Inside Panic Recovery", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io"},
+			Logger:    &testLogger{},
+			Middlewares: []Middleware{func(h http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("middleware") })
+			}},
+		})
+		So(err, ShouldBeNil)
+		rec := httptest.NewRecorder()
+		So(func() { ss.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil)) }, ShouldNotPanic)
+		So(rec.Code, ShouldEqual, http.StatusInternalServerError)
+	})
+	Convey("Test withRequestTimeout()", t, func() {
+		var ctxErr error
+		var hasDeadline bool
+		h := withRequestTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, hasDeadline = r.Context().Deadline()
+			<-r.Context().Done()
+			ctxErr = r.Context().Err()
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}), 10*time.Millisecond)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		So(hasDeadline, ShouldBeTrue)
+		So(ctxErr, ShouldEqual, context.DeadlineExceeded)
+		So(rec.Code, ShouldEqual, http.StatusGatewayTimeout)
+	})
+	Convey("Test withSlowRequests()", t, func() {
+		var slow []string
+		h := withSlowRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				time.Sleep(20 * time.Millisecond)
+			}
+		}), 10*time.Millisecond, func(r *http.Request, d time.Duration) {
+			So(d, ShouldBeGreaterThan, 10*time.Millisecond)
+			slow = append(slow, r.URL.Path)
+		})
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+		So(slow, ShouldResemble, []string{"/slow"})
+		Convey("Test Slow Requests Are Logged By Default", func() {
+			logger := &testLogger{}
+			ss, err := NewServer(ServerConfig{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					time.Sleep(20 * time.Millisecond)
+				}),
+				Hostnames:            []string{"yourdomain.io"},
+				Logger:               logger,
+				SlowRequestThreshold: 10 * time.Millisecond,
+			})
+			So(err, ShouldBeNil)
+			ss.server.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+			So(logger.String(), ShouldContainSubstring, "slow request: GET /slow")
+		})
+	})
+	Convey("Test withServerHeader()", t, func() {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Server", "downstream/1.0")
+			w.Write([]byte("ok"))
+		})
+		Convey("Test Server Header Is Set", func() {
+			rec := httptest.NewRecorder()
+			withServerHeader(handler, "yourdomain").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(rec.Header().Get("Server"), ShouldEqual, "yourdomain")
+		})
+		Convey("Test Server Header Is Suppressed", func() {
+			rec := httptest.NewRecorder()
+			withServerHeader(handler, SuppressServerHeader).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(rec.Header(), ShouldNotContainKey, "Server")
+		})
+		Convey("Test Server Header Is Set When Handler Writes Nothing", func() {
+			rec := httptest.NewRecorder()
+			withServerHeader(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "yourdomain").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(rec.Header().Get("Server"), ShouldEqual, "yourdomain")
+		})
+	})
+	Convey("Test Connections Can Be Hijacked Through The Header Middleware", t, func() {
+		chain := Chain(hijackingHandler,
+			func(h http.Handler) http.Handler { return withServerHeader(h, "yourdomain") },
+			func(h http.Handler) http.Handler { return withSecurityHeaders(h, nil) },
+		)
+		body, err := hijackedBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.TLS = &tls.ConnectionState{} // as if served over HTTPS, for the security headers
+			chain.ServeHTTP(w, r)
+		}))
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, "hijacked")
+	})
+	Convey("Test MaxRequestsBeforeShutdown", t, func() {
+		port := freePort()
+		ss, err := NewServer(ServerConfig{
+			Handler:                   http.NotFoundHandler(),
+			Hostnames:                 []string{"yourdomain.io"},
+			HTTPPort:                  port,
+			ServeSSLFunc:              ServeSSLNever,
+			MaxRequestsBeforeShutdown: 2,
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		// connections the client dials ahead of time and never uses would
+		// hold up the shutdown, so every request is sent on its own
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		for i := 0; i < 2; i++ {
+			select {
+			case <-ss.done:
+				t.Fatal("server shut down early")
+			default:
+			}
+			resp, err := client.Get("http://localhost" + port)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+		}
+		select {
+		case <-ss.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("server was not shut down")
+		}
+	})
+}
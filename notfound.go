@@ -0,0 +1,122 @@
+package sslmgr
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+)
+
+// notFoundBody is the body http.NotFound (and so http.ServeMux) responds with
+const notFoundBody = "404 page not found\n"
+
+// withNotFound serves notFound in place of the 404 Not Found responses of
+// h which have an empty body, or the body of http.NotFound
+func withNotFound(h, notFound http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nw := &notFoundWriter{ResponseWriter: w}
+		h.ServeHTTP(nw, r)
+		if !nw.intercepting {
+			return
+		}
+		if len(nw.body) > 0 && string(nw.body) != notFoundBody {
+			nw.release()
+			return
+		}
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Length")
+		w.Header().Del("X-Content-Type-Options")
+		notFound.ServeHTTP(&notFoundStatusWriter{ResponseWriter: w}, r)
+	})
+}
+
+// notFoundWriter holds back 404 Not Found responses for as long as their
+// body may still be replaced
+type notFoundWriter struct {
+	http.ResponseWriter
+	wroteHeader  bool
+	intercepting bool
+	body         []byte
+}
+
+func (nw *notFoundWriter) WriteHeader(status int) {
+	if nw.wroteHeader {
+		return
+	}
+	nw.wroteHeader = true
+	if status == http.StatusNotFound {
+		nw.intercepting = true
+		return
+	}
+	nw.ResponseWriter.WriteHeader(status)
+}
+
+func (nw *notFoundWriter) Write(p []byte) (int, error) {
+	if !nw.wroteHeader {
+		nw.WriteHeader(http.StatusOK)
+	}
+	if !nw.intercepting {
+		return nw.ResponseWriter.Write(p)
+	}
+	nw.body = append(nw.body, p...)
+	if !bytes.HasPrefix([]byte(notFoundBody), nw.body) {
+		// a body of the handler's own, which is left alone
+		if err := nw.release(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// release writes the held back response as the handler wrote it
+func (nw *notFoundWriter) release() error {
+	nw.intercepting = false
+	nw.ResponseWriter.WriteHeader(http.StatusNotFound)
+	_, err := nw.ResponseWriter.Write(nw.body)
+	return err
+}
+
+// Flush sends whatever has been written so far to the client, unless it
+// is held back
+func (nw *notFoundWriter) Flush() {
+	if nw.intercepting {
+		return
+	}
+	if f, ok := nw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection, e.g. for WebSockets
+func (nw *notFoundWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(nw.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (nw *notFoundWriter) Unwrap() http.ResponseWriter {
+	return nw.ResponseWriter
+}
+
+// notFoundStatusWriter defaults the status of responses to 404 Not Found
+// rather than 200 OK
+type notFoundStatusWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (sw *notFoundStatusWriter) WriteHeader(status int) {
+	sw.wroteHeader = true
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *notFoundStatusWriter) Write(p []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusNotFound)
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (sw *notFoundStatusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,109 @@
+package sslmgr
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// hijackingHandler takes over the connection through http.Hijacker, as
+// WebSocket libraries do, and responds on it directly
+var hijackingHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "not a http.Hijacker", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+	buf.Flush()
+})
+
+// hijackedBody returns the body of the response h serves to a request
+// over a real connection, which it may hijack
+func hijackedBody(h http.Handler) (string, error) {
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	custom := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "<h1>lost?</h1>")
+	})
+	serve := func(h http.Handler, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		withNotFound(h, custom).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	Convey("Test withNotFound()", t, func() {
+		Convey("Test Unmatched Routes Are Replaced", func() {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/found", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "here") })
+			rec := serve(mux, "/missing")
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+			So(rec.Body.String(), ShouldEqual, "<h1>lost?</h1>")
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "text/html")
+			So(rec.Header().Get("X-Content-Type-Options"), ShouldBeEmpty)
+			rec = serve(mux, "/found")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldEqual, "here")
+		})
+		Convey("Test Empty 404s Are Replaced", func() {
+			rec := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			}), "/")
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+			So(rec.Body.String(), ShouldEqual, "<h1>lost?</h1>")
+		})
+		Convey("Test 404s With Their Own Body Are Kept", func() {
+			for _, body := range []string{`{"error":"no such user"}`, "404 page"} {
+				rec := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNotFound)
+					io.WriteString(w, body)
+				}), "/")
+				So(rec.Code, ShouldEqual, http.StatusNotFound)
+				So(rec.Body.String(), ShouldEqual, body)
+			}
+		})
+		Convey("Test Custom Handler Status Is Kept", func() {
+			rec := httptest.NewRecorder()
+			withNotFound(http.NotFoundHandler(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusGone)
+			})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			So(rec.Code, ShouldEqual, http.StatusGone)
+		})
+		Convey("Test Connections Can Be Hijacked", func() {
+			body, err := hijackedBody(withNotFound(hijackingHandler, custom))
+			So(err, ShouldBeNil)
+			So(body, ShouldEqual, "hijacked")
+		})
+	})
+	Convey("Test NotFoundHandler Is Applied", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:         http.NotFoundHandler(),
+			Hostnames:       []string{"yourdomain.io"},
+			NotFoundHandler: custom,
+		})
+		So(err, ShouldBeNil)
+		rec := httptest.NewRecorder()
+		ss.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		So(rec.Code, ShouldEqual, http.StatusNotFound)
+		So(rec.Body.String(), ShouldEqual, "<h1>lost?</h1>")
+	})
+}
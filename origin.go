@@ -0,0 +1,71 @@
+package sslmgr
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// loadOriginCert loads the certificate (chain) and key at the given PEM
+// files, and checks that the certificate is valid for every hostname
+func loadOriginCert(certFile, keyFile string, hostnames []string) (tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, fmt.Errorf("%w: both a certificate and a key file are required", ErrInvalidOriginCert)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%w: %s", ErrInvalidOriginCert, err)
+	}
+	for _, host := range hostnames {
+		if err := cert.Leaf.VerifyHostname(normalizeHost(host)); err != nil {
+			return tls.Certificate{}, fmt.Errorf("%w: %s", ErrInvalidOriginCert, err)
+		}
+	}
+	return cert, nil
+}
+
+// originCert returns the current origin certificate if it is valid for
+// the hostname hello requests (if any), or nil otherwise
+func (ss *SecureServer) originCert(hello *tls.ClientHelloInfo) *tls.Certificate {
+	cert := ss.origin.Load()
+	if cert == nil {
+		return nil
+	}
+	if host := normalizeHost(hello.ServerName); host != "" && cert.Leaf.VerifyHostname(host) != nil {
+		return nil
+	}
+	return cert
+}
+
+// reloadOriginCert reads the OriginCertFile and OriginKeyFile again, and
+// swaps the certificate served for the one read, unless it is invalid
+func (ss *SecureServer) reloadOriginCert() error {
+	cert, err := loadOriginCert(ss.originCertFile, ss.originKeyFile, ss.hostnames)
+	if err != nil {
+		ss.logger.Printf("[sslmgr] failed to reload origin certificate, keeping the current one: %s", err)
+		return err
+	}
+	ss.origin.Store(&cert)
+	ss.logger.Printf("[sslmgr] reloaded origin certificate from %s", ss.originCertFile)
+	return nil
+}
+
+// reloadOriginCertOnSignal reloads the origin certificate whenever the
+// process receives a SIGUSR1, until the server shuts down
+func (ss *SecureServer) reloadOriginCertOnSignal() {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(reload)
+		for {
+			select {
+			case <-reload:
+				ss.reloadOriginCert()
+			case <-ss.stopping.Done():
+				return
+			}
+		}
+	}()
+}
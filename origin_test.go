@@ -0,0 +1,103 @@
+package sslmgr
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOriginCert(t *testing.T) {
+	Convey("Test OriginCertFile and OriginKeyFile", t, func() {
+		dir := t.TempDir()
+		certFile, keyFile := filepath.Join(dir, "origin.pem"), filepath.Join(dir, "origin.key")
+		certPEM, keyPEM := testCertPEM("yourdomain.io", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+		So(os.WriteFile(certFile, certPEM, 0600), ShouldBeNil)
+		So(os.WriteFile(keyFile, keyPEM, 0600), ShouldBeNil)
+		newServer := func(hostnames []string, certFile, keyFile string) (*SecureServer, error) {
+			return NewServer(ServerConfig{
+				Handler:        http.NotFoundHandler(),
+				Hostnames:      hostnames,
+				OriginCertFile: certFile,
+				OriginKeyFile:  keyFile,
+			})
+		}
+		Convey("Test Origin Certificate Is Served Instead Of ACME", func() {
+			ss, err := newServer([]string{"YourDomain.io"}, certFile, keyFile)
+			So(err, ShouldBeNil)
+			So(ss.certificates, ShouldNotBeNil)
+			So(ss.http01, ShouldBeFalse)
+			So(ss.tlsALPN01, ShouldBeFalse)
+			cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+			So(err, ShouldBeNil)
+			So(cert, ShouldEqual, ss.origin.Load())
+		})
+		Convey("Test Hostnames Are Optional", func() {
+			_, err := newServer(nil, certFile, keyFile)
+			So(err, ShouldBeNil)
+		})
+		Convey("Test Certificate Must Cover Every Hostname", func() {
+			_, err := newServer([]string{"yourdomain.io", "otherdomain.io"}, certFile, keyFile)
+			So(errors.Is(err, ErrInvalidOriginCert), ShouldBeTrue)
+		})
+		Convey("Test Both Files Are Required", func() {
+			_, err := newServer([]string{"yourdomain.io"}, certFile, "")
+			So(errors.Is(err, ErrInvalidOriginCert), ShouldBeTrue)
+		})
+		Convey("Test Certificate Is Reloaded On SIGUSR1", func() {
+			logger := &testLogger{}
+			ss, err := NewServer(ServerConfig{
+				Handler:        http.NotFoundHandler(),
+				Hostnames:      []string{"yourdomain.io"},
+				OriginCertFile: certFile,
+				OriginKeyFile:  keyFile,
+				HTTPPort:       ":0",
+				HTTPSPort:      ":0",
+				Logger:         logger,
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			defer ss.close()
+			defer ss.stop()
+			served := func() string {
+				cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+				So(err, ShouldBeNil)
+				return cert.Leaf.SerialNumber.String()
+			}
+			original := served()
+			certPEM, keyPEM := testCertPEM("yourdomain.io", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+			So(os.WriteFile(certFile, certPEM, 0600), ShouldBeNil)
+			So(os.WriteFile(keyFile, keyPEM, 0600), ShouldBeNil)
+			So(syscall.Kill(syscall.Getpid(), syscall.SIGUSR1), ShouldBeNil)
+			for start := time.Now(); served() == original && time.Since(start) < 5*time.Second; {
+				time.Sleep(10 * time.Millisecond)
+			}
+			rotated := served()
+			So(rotated, ShouldNotEqual, original)
+			// as is the certificate served for other hostnames
+			cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "otherdomain.io"})
+			So(err, ShouldBeNil)
+			So(cert.Leaf.SerialNumber.String(), ShouldEqual, rotated)
+			So(logger.String(), ShouldContainSubstring, "reloaded origin certificate from "+certFile)
+			Convey("Test Invalid Files Keep The Current Certificate", func() {
+				otherPEM, _ := testCertPEM("otherdomain.io", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+				So(os.WriteFile(certFile, otherPEM, 0600), ShouldBeNil)
+				So(errors.Is(ss.reloadOriginCert(), ErrInvalidOriginCert), ShouldBeTrue)
+				So(served(), ShouldEqual, rotated)
+				So(logger.String(), ShouldContainSubstring, "keeping the current one")
+			})
+		})
+		Convey("Test Mismatched Key Is Rejected", func() {
+			_, otherKeyPEM := testCertPEM("yourdomain.io", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+			So(os.WriteFile(keyFile, otherKeyPEM, 0600), ShouldBeNil)
+			_, err := newServer([]string{"yourdomain.io"}, certFile, keyFile)
+			So(errors.Is(err, ErrInvalidOriginCert), ShouldBeTrue)
+		})
+	})
+}
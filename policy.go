@@ -0,0 +1,178 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/publicsuffix"
+)
+
+// normalizeHostnames returns the canonical form of hostnames, in order
+// and without duplicates, along with the duplicates which were dropped
+func normalizeHostnames(hostnames []string) (normalized, duplicates []string) {
+	seen := make(map[string]bool)
+	for _, host := range hostnames {
+		canonical := normalizeHost(host)
+		if seen[canonical] {
+			duplicates = append(duplicates, host)
+			continue
+		}
+		seen[canonical] = true
+		normalized = append(normalized, canonical)
+	}
+	return normalized, duplicates
+}
+
+// validateHostPatterns checks that every pattern is a well formed
+// hostname pattern whose wildcards are confined to a registrable domain,
+// i.e. whose literal trailing labels are more than a public suffix, so
+// that a pattern such as "*.com" or "*.co.uk" cannot have certificates
+// issued for any hostname pointed at the server
+func validateHostPatterns(patterns []string) error {
+	for _, p := range patterns {
+		labels := strings.Split(normalizeHost(p), ".")
+		literal := len(labels)
+		for i, label := range labels {
+			if _, err := path.Match(label, ""); label == "" || err != nil {
+				return fmt.Errorf("%w: %q", ErrInvalidHostPattern, p)
+			}
+			if strings.ContainsAny(label, "*?[") {
+				literal = len(labels) - i - 1
+			}
+		}
+		suffix := strings.Join(labels[len(labels)-literal:], ".")
+		if literal < 2 {
+			return fmt.Errorf("%w: %q", ErrInvalidHostPattern, p)
+		}
+		if _, err := publicsuffix.EffectiveTLDPlusOne(suffix); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidHostPattern, p)
+		}
+	}
+	return nil
+}
+
+// matchHostPattern reports whether host matches pattern label by label,
+// such that a "*" label matches exactly one label of the host
+func matchHostPattern(pattern, host string) bool {
+	patternLabels := strings.Split(normalizeHost(pattern), ".")
+	hostLabels := strings.Split(host, ".")
+	if len(patternLabels) != len(hostLabels) {
+		return false
+	}
+	for i := range patternLabels {
+		if ok, _ := path.Match(patternLabels[i], hostLabels[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// hostPolicy returns an autocert.HostPolicy approving the given hostnames,
+// and any hostname matching one of the given patterns
+func hostPolicy(hostnames, patterns []string) autocert.HostPolicy {
+	whitelist := autocert.HostWhitelist(hostnames...)
+	if len(patterns) == 0 {
+		return whitelist
+	}
+	return func(ctx context.Context, host string) error {
+		err := whitelist(ctx, host)
+		if err == nil {
+			return nil
+		}
+		for _, p := range patterns {
+			if matchHostPattern(p, normalizeHost(host)) {
+				return nil
+			}
+		}
+		return err
+	}
+}
+
+// observedHostPolicy wraps policy so that each of its decisions is logged
+// and reported to onCheck, if set
+func observedHostPolicy(policy autocert.HostPolicy, logger Logger, onCheck func(context.Context, string, bool)) autocert.HostPolicy {
+	if onCheck == nil {
+		return policy
+	}
+	return func(ctx context.Context, host string) error {
+		err := policy(ctx, host)
+		if err != nil {
+			logger.Printf("[sslmgr] host policy rejected %s: %s", host, err)
+		} else {
+			logger.Printf("[sslmgr] host policy allowed %s", host)
+		}
+		onCheck(ctx, host, err == nil)
+		return err
+	}
+}
+
+// hostCheckPolicy returns the policy Host headers are checked against:
+// that of the Hostnames and HostPatterns or, when neither is set, one
+// approving the names the Certificates (e.g. that of DevMode) are valid
+// for. It returns nil when no name is known, e.g. when serving the
+// OriginCertFile alone
+func hostCheckPolicy(c ServerConfig) autocert.HostPolicy {
+	if len(c.Hostnames) > 0 || len(c.HostPatterns) > 0 {
+		return hostPolicy(c.Hostnames, c.HostPatterns)
+	}
+	names := make(map[string]bool)
+	var patterns []string
+	for _, cert := range c.Certificates {
+		leaf := cert.Leaf
+		if leaf == nil {
+			if len(cert.Certificate) == 0 {
+				continue
+			}
+			var err error
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				continue
+			}
+		}
+		for _, name := range leaf.DNSNames {
+			if strings.HasPrefix(name, "*.") {
+				patterns = append(patterns, name)
+			} else {
+				names[normalizeHost(name)] = true
+			}
+		}
+		for _, ip := range leaf.IPAddresses {
+			names[ip.String()] = true
+		}
+	}
+	if len(names) == 0 && len(patterns) == 0 {
+		return nil
+	}
+	return func(_ context.Context, host string) error {
+		if names[host] {
+			return nil
+		}
+		for _, p := range patterns {
+			if matchHostPattern(p, host) {
+				return nil
+			}
+		}
+		return fmt.Errorf("host %q is not named by any of the Certificates", host)
+	}
+}
+
+// withHostCheck responds 400 Bad Request to requests whose Host header is
+// missing or not approved by policy
+func withHostCheck(h http.Handler, policy autocert.HostPolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if host = normalizeHost(host); host == "" || policy(r.Context(), host) != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
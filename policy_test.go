@@ -0,0 +1,162 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPolicy(t *testing.T) {
+	Convey("Test normalizeHostnames()", t, func() {
+		normalized, duplicates := normalizeHostnames([]string{"YourDomain.io", "yourdomain.io.", "api.yourdomain.io.", "Other.io"})
+		So(normalized, ShouldResemble, []string{"yourdomain.io", "api.yourdomain.io", "other.io"})
+		So(duplicates, ShouldResemble, []string{"yourdomain.io."})
+	})
+	Convey("Test Hostnames Are Normalized", t, func() {
+		logger := &testLogger{}
+		ss, err := NewServer(ServerConfig{
+			Handler:     http.NotFoundHandler(),
+			Hostnames:   []string{"YourDomain.io.", "yourdomain.io"},
+			Logger:      logger,
+			CertCache:   newMemCache(),
+			OfflineMode: true,
+		})
+		So(err, ShouldBeNil)
+		So(ss.hostnames, ShouldResemble, []string{"yourdomain.io"})
+		So(logger.String(), ShouldContainSubstring, `duplicate hostname "yourdomain.io"`)
+		for _, host := range []string{"yourdomain.io", "YOURDOMAIN.IO", "yourdomain.io."} {
+			So(ss.certMgr.HostPolicy(context.Background(), normalizeHost(host)), ShouldBeNil)
+		}
+		So(ss.certMgr.HostPolicy(context.Background(), "otherdomain.io"), ShouldNotBeNil)
+	})
+	Convey("Test validateHostPatterns()", t, func() {
+		So(validateHostPatterns([]string{"*.yourdomain.io", "api-?.yourdomain.io"}), ShouldBeNil)
+		So(errors.Is(validateHostPatterns([]string{"*"}), ErrInvalidHostPattern), ShouldBeTrue)
+		So(errors.Is(validateHostPatterns([]string{"[.yourdomain.io"}), ErrInvalidHostPattern), ShouldBeTrue)
+		So(errors.Is(validateHostPatterns([]string{"a..yourdomain.io"}), ErrInvalidHostPattern), ShouldBeTrue)
+		Convey("Test Patterns Must Not Span A Public Suffix", func() {
+			for _, p := range []string{"*.*", "*.com", "*.co.uk", "yourdomain.*", "*.yourdomain.*"} {
+				So(errors.Is(validateHostPatterns([]string{p}), ErrInvalidHostPattern), ShouldBeTrue)
+			}
+			So(validateHostPatterns([]string{"*.yourdomain.co.uk", "*.corp.internal"}), ShouldBeNil)
+		})
+	})
+	Convey("Test matchHostPattern()", t, func() {
+		So(matchHostPattern("*.yourdomain.io", "a.yourdomain.io"), ShouldBeTrue)
+		So(matchHostPattern("*.YourDomain.io", "a.yourdomain.io"), ShouldBeTrue)
+		So(matchHostPattern("*.yourdomain.io", "a.b.yourdomain.io"), ShouldBeFalse)
+		So(matchHostPattern("*.yourdomain.io", "yourdomain.io"), ShouldBeFalse)
+		So(matchHostPattern("*.yourdomain.io", "a.otherdomain.io"), ShouldBeFalse)
+	})
+	Convey("Test HostPatterns Policy", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:      http.NotFoundHandler(),
+			Hostnames:    []string{"yourdomain.io"},
+			HostPatterns: []string{"*.customer.yourdomain.io"},
+		})
+		So(err, ShouldBeNil)
+		ctx := context.Background()
+		So(ss.certMgr.HostPolicy(ctx, "yourdomain.io"), ShouldBeNil)
+		So(ss.certMgr.HostPolicy(ctx, "acme.customer.yourdomain.io"), ShouldBeNil)
+		So(ss.certMgr.HostPolicy(ctx, "acme.yourdomain.io"), ShouldNotBeNil)
+		Convey("Test Invalid Pattern Fails Construction", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:      http.NotFoundHandler(),
+				Hostnames:    []string{"yourdomain.io"},
+				HostPatterns: []string{"[.yourdomain.io"},
+			})
+			So(ss, ShouldBeNil)
+			So(errors.Is(err, ErrInvalidHostPattern), ShouldBeTrue)
+		})
+	})
+	Convey("Test RequireHostHeader", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:           http.NotFoundHandler(),
+			Hostnames:         []string{"yourdomain.io"},
+			HostPatterns:      []string{"*.yourdomain.io"},
+			RequireHostHeader: true,
+		})
+		So(err, ShouldBeNil)
+		serve := func(host string, proto string) int {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = host
+			if proto == "HTTP/1.0" {
+				req.Proto, req.ProtoMajor, req.ProtoMinor = proto, 1, 0
+			}
+			rec := httptest.NewRecorder()
+			ss.server.Handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+		Convey("Test Missing Host Is Rejected", func() {
+			So(serve("", "HTTP/1.0"), ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Test Empty Host Is Rejected", func() {
+			So(serve("", "HTTP/1.1"), ShouldEqual, http.StatusBadRequest)
+			So(serve(":443", "HTTP/1.1"), ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Test Mismatched Host Is Rejected", func() {
+			So(serve("notmydomain.io", "HTTP/1.1"), ShouldEqual, http.StatusBadRequest)
+			So(serve("a.b.yourdomain.io", "HTTP/1.1"), ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Test Configured Hosts Are Served", func() {
+			So(serve("yourdomain.io", "HTTP/1.1"), ShouldEqual, http.StatusNotFound)
+			So(serve("YourDomain.io:8443", "HTTP/1.1"), ShouldEqual, http.StatusNotFound)
+			So(serve("api.yourdomain.io", "HTTP/1.1"), ShouldEqual, http.StatusNotFound)
+		})
+	})
+	Convey("Test RequireHostHeader Without Hostnames", t, func() {
+		serve := func(c ServerConfig, host string) int {
+			c.Handler = http.NotFoundHandler()
+			c.RequireHostHeader = true
+			ss, err := NewServer(c)
+			So(err, ShouldBeNil)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = host
+			rec := httptest.NewRecorder()
+			ss.server.Handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+		Convey("Test The Names Of The Certificates Are Allowed", func() {
+			c := ServerConfig{Certificates: testTLSConfig("yourdomain.io").Certificates}
+			So(serve(c, "yourdomain.io:8443"), ShouldEqual, http.StatusNotFound)
+			So(serve(c, "notmydomain.io"), ShouldEqual, http.StatusBadRequest)
+		})
+		Convey("Test The Names Of The DevMode Certificate Are Allowed", func() {
+			c := ServerConfig{DevMode: true}
+			So(serve(c, "localhost:8443"), ShouldEqual, http.StatusNotFound)
+			So(serve(c, "[::1]:8443"), ShouldEqual, http.StatusNotFound)
+			So(serve(c, "127.0.0.1"), ShouldEqual, http.StatusNotFound)
+			So(serve(c, "notmydomain.io"), ShouldEqual, http.StatusBadRequest)
+		})
+	})
+	Convey("Test OnHostPolicyCheck", t, func() {
+		logger := &testLogger{}
+		decisions := make(map[string]bool)
+		ss, err := NewServer(ServerConfig{
+			Handler:      http.NotFoundHandler(),
+			Hostnames:    []string{"yourdomain.io"},
+			HostPatterns: []string{"*.yourdomain.io"},
+			Logger:       logger,
+			OfflineMode:  true,
+			CertCache:    newMemCache(),
+			OnHostPolicyCheck: func(ctx context.Context, host string, allowed bool) {
+				decisions[host] = allowed
+			},
+		})
+		So(err, ShouldBeNil)
+		for _, host := range []string{"yourdomain.io", "api.yourdomain.io", "otherdomain.io"} {
+			ss.getCertificate(&tls.ClientHelloInfo{ServerName: host})
+		}
+		So(decisions, ShouldResemble, map[string]bool{
+			"yourdomain.io":     true,
+			"api.yourdomain.io": true,
+			"otherdomain.io":    false,
+		})
+		So(logger.String(), ShouldContainSubstring, "host policy rejected otherdomain.io")
+	})
+}
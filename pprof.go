@@ -0,0 +1,63 @@
+package sslmgr
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// defaultPprofPrefix is the path under which pprof endpoints are
+// mounted when no PprofPrefix is configured
+const defaultPprofPrefix = "/debug/pprof/"
+
+// BasicAuth holds the credentials required by a basic-auth guarded endpoint
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+// allows reports whether the request carries the expected credentials
+func (ba *BasicAuth) allows(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(ba.Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(ba.Password)) == 1
+	return userOK && passOK
+}
+
+// withPprof serves net/http/pprof's endpoints under prefix for requests
+// received over TLS, and passes every other request on to h
+func withPprof(h http.Handler, prefix string, auth *BasicAuth) http.Handler {
+	if prefix == "" {
+		prefix = defaultPprofPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	// pprof.Index only resolves profiles under its canonical path
+	mux := http.NewServeMux()
+	mux.HandleFunc(defaultPprofPrefix, pprof.Index)
+	mux.HandleFunc(defaultPprofPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(defaultPprofPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(defaultPprofPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(defaultPprofPrefix+"trace", pprof.Trace)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || !strings.HasPrefix(r.URL.Path, prefix) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if auth != nil && !auth.allows(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="pprof"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = defaultPprofPrefix + strings.TrimPrefix(r.URL.Path, prefix)
+		r2.URL.RawPath = ""
+		mux.ServeHTTP(w, r2)
+	})
+}
@@ -0,0 +1,46 @@
+package sslmgr
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPprof(t *testing.T) {
+	Convey("Test withPprof()", t, func() {
+		h := withPprof(http.NotFoundHandler(), "/admin/pprof", &BasicAuth{Username: "admin", Password: "secret"})
+		Convey("Test Authorized TLS Request Is Served", func() {
+			req := httptest.NewRequest(http.MethodGet, "/admin/pprof/", nil)
+			req.TLS = &tls.ConnectionState{}
+			req.SetBasicAuth("admin", "secret")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+		})
+		Convey("Test Unauthorized TLS Request Is Rejected", func() {
+			req := httptest.NewRequest(http.MethodGet, "/admin/pprof/", nil)
+			req.TLS = &tls.ConnectionState{}
+			req.SetBasicAuth("admin", "wrong")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+		Convey("Test Plain HTTP Request Falls Through", func() {
+			req := httptest.NewRequest(http.MethodGet, "/admin/pprof/", nil)
+			req.SetBasicAuth("admin", "secret")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Test Other Paths Fall Through", func() {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.TLS = &tls.ConnectionState{}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,162 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ForceRenew obtains a new certificate for host from the CA, replacing
+// the cached one (e.g. to rotate its key). Handshakes keep being served
+// the current certificate until the new one is obtained, and the new one
+// from then on, while a failed renewal leaves the cached certificate in
+// place. A certificate is also obtained with an RSA key if one was
+// cached for host. Issuance carries on in the background if ctx is done
+// first. ForceRenew fails with ErrRenewalDisabled whenever certificates
+// are not obtained from the CA, i.e. with Certificates, OfflineMode or a
+// ReadOnlyCache
+func (ss *SecureServer) ForceRenew(ctx context.Context, host string) error {
+	if err := ss.checkRenewable(); err != nil {
+		return err
+	}
+	return ss.forceRenew(ctx, ss.newRenewer(), host)
+}
+
+// RenewAll force renews the certificates of every one of the Hostnames,
+// as ForceRenew does, up to PrimeConcurrency at a time and paced by the
+// PrimeRateLimit. It returns the errors encountered, keyed by hostname,
+// along with ctx's error if it was done before every hostname was renewed
+func (ss *SecureServer) RenewAll(ctx context.Context) (map[string]error, error) {
+	if err := ss.checkRenewable(); err != nil {
+		return nil, err
+	}
+	renewer := ss.newRenewer()
+	failures := ss.forEachHost(ctx, func(ctx context.Context, host string) error {
+		return ss.forceRenew(ctx, renewer, host)
+	})
+	return failures, ctx.Err()
+}
+
+// checkRenewable reports whether certificates are obtained from the CA
+func (ss *SecureServer) checkRenewable() error {
+	if ss.certificates != nil || ss.offline || ss.readOnlyCache {
+		return ErrRenewalDisabled
+	}
+	return nil
+}
+
+// newRenewalManager returns a certificate manager sharing the server's
+// configuration and cache, but none of the certificates it holds in
+// memory, and to which the cached certificates being renewed appear
+// missing, so that it requests new ones. ACME challenges are answered for
+// it through the shared cache
+func (ss *SecureServer) newRenewalManager() certFetcher {
+	m := &autocert.Manager{
+		Prompt:                 ss.certMgr.Prompt,
+		Cache:                  &renewalCache{Cache: ss.certMgr.Cache, renewing: &ss.renewing},
+		HostPolicy:             ss.certMgr.HostPolicy,
+		RenewBefore:            ss.certMgr.RenewBefore,
+		Client:                 ss.certMgr.Client,
+		Email:                  ss.certMgr.Email,
+		ExtraExtensions:        ss.certMgr.ExtraExtensions,
+		ExternalAccountBinding: ss.certMgr.ExternalAccountBinding,
+	}
+	if ss.http01 {
+		m.HTTPHandler(nil) // offers HTTP-01 challenges
+	}
+	return m
+}
+
+// renewalCache hides the cached certificates being renewed, keyed by their
+// cache key in renewing, from reads. Writes go through, overwriting them
+// once their renewal succeeded, while a failed renewal leaves them be
+type renewalCache struct {
+	autocert.Cache
+	renewing *sync.Map
+}
+
+func (rc *renewalCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if _, renewing := rc.renewing.Load(key); renewing {
+		return nil, autocert.ErrCacheMiss
+	}
+	return rc.Cache.Get(ctx, key)
+}
+
+// forceRenew obtains new certificates for host through renewer, to which
+// the cached ones appear missing until they are overwritten
+func (ss *SecureServer) forceRenew(ctx context.Context, renewer certFetcher, host string) error {
+	host = normalizeHost(host)
+	if err := ss.certMgr.HostPolicy(ctx, host); err != nil {
+		return err
+	}
+	hellos := []*tls.ClientHelloInfo{ecdsaHello(host)}
+	keys := []string{host}
+	if _, err := ss.certMgr.Cache.Get(ctx, host+"+rsa"); err == nil {
+		hellos = append(hellos, &tls.ClientHelloInfo{ServerName: host})
+		keys = append(keys, host+"+rsa")
+	}
+	for _, key := range keys {
+		ss.renewing.Store(key, true)
+	}
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			for _, key := range keys {
+				ss.renewing.Delete(key)
+			}
+		}()
+		for i, hello := range hellos {
+			cert, err := renewer.GetCertificate(hello)
+			if err != nil {
+				result <- err
+				return
+			}
+			ss.renewed.Store(keys[i], cert)
+		}
+		result <- nil
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// freshestCert returns the most recently issued of cert and the force
+// renewed certificates of hello's host which hello supports, as the
+// server's own manager keeps serving the certificates it holds in memory
+func (ss *SecureServer) freshestCert(hello *tls.ClientHelloInfo, cert *tls.Certificate) *tls.Certificate {
+	host := normalizeHost(hello.ServerName)
+	freshest, issued := cert, issuedAt(cert)
+	for _, key := range []string{host, host + "+rsa"} {
+		v, ok := ss.renewed.Load(key)
+		if !ok {
+			continue
+		}
+		renewed := v.(*tls.Certificate)
+		if at := issuedAt(renewed); at.After(issued) && hello.SupportsCertificate(renewed) == nil {
+			freshest, issued = renewed, at
+		}
+	}
+	return freshest
+}
+
+// issuedAt returns the time from which cert is valid
+func issuedAt(cert *tls.Certificate) time.Time {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return time.Time{}
+		}
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return time.Time{}
+		}
+	}
+	return leaf.NotBefore
+}
@@ -0,0 +1,137 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// certFetcherFunc adapts a function to the certFetcher interface
+type certFetcherFunc func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+
+func (f certFetcherFunc) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return f(hello)
+}
+
+func TestRenew(t *testing.T) {
+	now := time.Now()
+	ecdsaHello := func(host string) *tls.ClientHelloInfo {
+		return &tls.ClientHelloInfo{
+			ServerName:        host,
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			SupportedCurves:   []tls.CurveID{tls.CurveP256},
+			CipherSuites:      []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			SupportedVersions: []uint16{tls.VersionTLS13},
+		}
+	}
+	Convey("Test RenewAll()", t, func() {
+		cache := newMemCache()
+		cache.Put(context.Background(), "a.yourdomain.io", testCacheEntry("a.yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+		cache.Put(context.Background(), "a.yourdomain.io+rsa", testCacheEntry("a.yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+		cache.Put(context.Background(), "b.yourdomain.io", testCacheEntry("b.yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+		newServer := func(c ServerConfig) *SecureServer {
+			c.Handler = http.NotFoundHandler()
+			c.Hostnames = []string{"a.yourdomain.io", "b.yourdomain.io"}
+			c.CertCache = cache
+			ss, err := NewServer(c)
+			So(err, ShouldBeNil)
+			return ss
+		}
+		Convey("Test Every Hostname Is Renewed", func() {
+			fake := &fakeCertManager{}
+			ss := withCertManager(newServer(ServerConfig{PrimeConcurrency: 2}), fake)
+			failures, err := ss.RenewAll(context.Background())
+			So(err, ShouldBeNil)
+			So(failures, ShouldBeEmpty)
+			So(fake.issued, ShouldHaveLength, 3)
+			So(fake.issued, ShouldContain, "a.yourdomain.io")
+			So(fake.issued, ShouldContain, "b.yourdomain.io")
+			So(cache.data, ShouldHaveLength, 3) // only overwritten by the CA's certificates
+		})
+		Convey("Test Failures Are Reported Per Hostname", func() {
+			ss := newServer(ServerConfig{})
+			ss.newRenewer = func() certFetcher {
+				return certFetcherFunc(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+					if hello.ServerName == "b.yourdomain.io" {
+						return nil, ErrRenewalDisabled
+					}
+					return &tls.Certificate{}, nil
+				})
+			}
+			failures, err := ss.RenewAll(context.Background())
+			So(err, ShouldBeNil)
+			So(failures, ShouldHaveLength, 1)
+			So(failures["b.yourdomain.io"], ShouldEqual, ErrRenewalDisabled)
+		})
+		Convey("Test Canceled Renewal Returns The Context's Error", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			ss := withCertManager(newServer(ServerConfig{}), &fakeCertManager{})
+			failures, err := ss.RenewAll(ctx)
+			So(err, ShouldEqual, context.Canceled)
+			So(failures, ShouldNotBeEmpty)
+		})
+		Convey("Test Renewal Is Disabled Without A CA", func() {
+			for _, c := range []ServerConfig{{OfflineMode: true}, {ReadOnlyCache: true}} {
+				failures, err := newServer(c).RenewAll(context.Background())
+				So(err, ShouldEqual, ErrRenewalDisabled)
+				So(failures, ShouldBeNil)
+				So(newServer(c).ForceRenew(context.Background(), "a.yourdomain.io"), ShouldEqual, ErrRenewalDisabled)
+			}
+		})
+	})
+	Convey("Test ForceRenew()", t, func() {
+		certPEM, keyPEM := testCertPEM("a.yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour))
+		current, err := tls.X509KeyPair(certPEM, keyPEM)
+		So(err, ShouldBeNil)
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"a.yourdomain.io", "b.yourdomain.io"},
+			CertCache: newMemCache(),
+		})
+		So(err, ShouldBeNil)
+		ss = withCertManager(ss, certFetcherFunc(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &current, nil
+		}))
+		ss.newRenewer = func() certFetcher { return &fakeCertManager{} }
+		Convey("Test Renewed Certificate Is Served", func() {
+			So(ss.ForceRenew(context.Background(), "A.yourdomain.io."), ShouldBeNil)
+			cert, err := ss.getCertificate(ecdsaHello("a.yourdomain.io"))
+			So(err, ShouldBeNil)
+			So(cert, ShouldNotEqual, &current)
+			So(issuedAt(cert).After(issuedAt(&current)), ShouldBeTrue)
+		})
+		Convey("Test Other Hostnames Are Unaffected", func() {
+			So(ss.ForceRenew(context.Background(), "a.yourdomain.io"), ShouldBeNil)
+			cert, err := ss.getCertificate(ecdsaHello("b.yourdomain.io"))
+			So(err, ShouldBeNil)
+			So(cert, ShouldEqual, &current)
+		})
+		Convey("Test Hostnames Not Allowed By The HostPolicy Are Rejected", func() {
+			So(ss.ForceRenew(context.Background(), "c.yourdomain.io"), ShouldNotBeNil)
+		})
+	})
+	Convey("Test Failed Renewal Keeps The Cached Certificate", t, func() {
+		cache := newMemCache()
+		entry := testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour))
+		cache.Put(context.Background(), "yourdomain.io", entry)
+		ss, err := NewServer(ServerConfig{
+			Handler:   http.NotFoundHandler(),
+			Hostnames: []string{"yourdomain.io"},
+			CertCache: cache,
+		})
+		So(err, ShouldBeNil)
+		ss.certMgr.Client = unreachableCA()
+		// the renewal manager requests a new certificate, ignoring the cached one
+		So(ss.ForceRenew(context.Background(), "yourdomain.io"), ShouldNotBeNil)
+		data, err := cache.Get(context.Background(), "yourdomain.io")
+		So(err, ShouldBeNil)
+		So(data, ShouldResemble, entry)
+		_, err = ss.certMgr.Cache.Get(context.Background(), "yourdomain.io")
+		So(err, ShouldBeNil)
+	})
+}
@@ -0,0 +1,72 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+)
+
+// internalRoots returns the roots trusted by the server's connections to
+// itself: the InternalRootCAs along with, in DevMode, the self-signed
+// certificate. A nil pool, for the system roots, is returned when neither
+// is set
+func internalRoots(roots *x509.CertPool, devCert *x509.Certificate) *x509.CertPool {
+	if devCert == nil {
+		return roots
+	}
+	if roots == nil {
+		roots = x509.NewCertPool()
+	} else {
+		roots = roots.Clone()
+	}
+	roots.AddCert(devCert)
+	return roots
+}
+
+// servedHTTPSAddr returns the address to dial to reach the HTTPS listener
+// currently served, if any
+func (ss *SecureServer) servedHTTPSAddr() (string, bool) {
+	ss.stateMu.Lock()
+	defer ss.stateMu.Unlock()
+	if ss.httpsListener == nil {
+		return "", false
+	}
+	return loopbackAddr(ss.httpsListener.Addr()), true
+}
+
+// loopbackAddr returns the address to dial to reach a listener bound to
+// addr from the same host
+func loopbackAddr(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return addr.String()
+	}
+	host := "localhost"
+	if tcpAddr.IP != nil && !tcpAddr.IP.IsUnspecified() {
+		host = tcpAddr.IP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(tcpAddr.Port))
+}
+
+// verifyServedCert completes a TLS handshake for host with the server
+// itself, confirming the certificate it serves for host verifies. No
+// request is sent, so neither the middleware nor the Handler are involved.
+// Nothing is verified while the server does not serve HTTPS, nor when it
+// requires client certificates, which it has none of to offer itself
+func (ss *SecureServer) verifyServedCert(ctx context.Context, host string) error {
+	if ss.clientAuth == tls.RequireAnyClientCert || ss.clientAuth == tls.RequireAndVerifyClientCert {
+		return nil
+	}
+	addr, ok := ss.servedHTTPSAddr()
+	if !ok {
+		return nil
+	}
+	d := &tls.Dialer{Config: &tls.Config{ServerName: host, RootCAs: ss.internalRootCAs}}
+	conn, err := d.DialContext(ctx, ss.network, addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,90 @@
+package sslmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSelfCheck(t *testing.T) {
+	Convey("Test VerifyPrimedCerts In DevMode", t, func() {
+		for _, network := range []string{"", "tcp4"} {
+			ss, err := NewServer(ServerConfig{
+				Handler:           http.NotFoundHandler(),
+				Hostnames:         []string{"yourdomain.io"},
+				HTTPPort:          freePort(),
+				HTTPSPort:         freePort(),
+				Network:           network,
+				DevMode:           true,
+				VerifyPrimedCerts: true,
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			So(ss.PrimeCerts(context.Background()), ShouldBeNil)
+			ss.close()
+		}
+	})
+	Convey("Test VerifyPrimedCerts", t, func() {
+		certs := testTLSConfig("yourdomain.io").Certificates
+		leaf, err := x509.ParseCertificate(certs[0].Certificate[0])
+		So(err, ShouldBeNil)
+		var requests atomic.Int32
+		newServer := func(roots *x509.CertPool, clientAuth tls.ClientAuthType) *SecureServer {
+			ss, err := NewServer(ServerConfig{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					requests.Add(1)
+					w.WriteHeader(http.StatusInternalServerError)
+				}),
+				Hostnames:         []string{"yourdomain.io"},
+				HTTPPort:          freePort(),
+				HTTPSPort:         freePort(),
+				Certificates:      certs,
+				VerifyPrimedCerts: true,
+				InternalRootCAs:   roots,
+				ClientAuth:        clientAuth,
+			})
+			So(err, ShouldBeNil)
+			return ss
+		}
+		Convey("Test Untrusted Certificates Are Reported", func() {
+			ss := newServer(nil, tls.NoClientCert)
+			So(ss.Start(), ShouldBeNil)
+			defer ss.close()
+			err := ss.PrimeCerts(context.Background())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "yourdomain.io")
+			var unknown x509.UnknownAuthorityError
+			So(errors.As(err, &unknown), ShouldBeTrue)
+		})
+		Convey("Test InternalRootCAs Are Trusted", func() {
+			roots := x509.NewCertPool()
+			roots.AddCert(leaf)
+			ss := newServer(roots, tls.NoClientCert)
+			So(ss.Start(), ShouldBeNil)
+			defer ss.close()
+			So(ss.PrimeCerts(context.Background()), ShouldBeNil)
+			So(requests.Load(), ShouldEqual, 0) // only a handshake is made
+		})
+		Convey("Test Nothing Is Verified Before Serving", func() {
+			So(newServer(nil, tls.NoClientCert).PrimeCerts(context.Background()), ShouldBeNil)
+		})
+		Convey("Test Nothing Is Verified When Client Certificates Are Required", func() {
+			ss := newServer(nil, tls.RequireAnyClientCert)
+			So(ss.Start(), ShouldBeNil)
+			defer ss.close()
+			So(ss.PrimeCerts(context.Background()), ShouldBeNil)
+		})
+	})
+	Convey("Test loopbackAddr()", t, func() {
+		So(loopbackAddr(&net.TCPAddr{Port: 8443}), ShouldEqual, "localhost:8443")
+		So(loopbackAddr(&net.TCPAddr{IP: net.IPv6unspecified, Port: 8443}), ShouldEqual, "localhost:8443")
+		So(loopbackAddr(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 8443}), ShouldEqual, "127.0.0.2:8443")
+	})
+}
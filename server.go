@@ -2,18 +2,25 @@ package sslmgr
 
 import (
 	"context"
+	"crypto"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 )
 
@@ -21,13 +28,94 @@ import (
 // certificate manager and server configuration
 type SecureServer struct {
 	server                     *http.Server
+	httpServer                 *http.Server
+	logger                     Logger
+	hostnames                  []string
+	handler                    *swappableHandler
+	httpHandler                http.Handler
+	plainHTTPMode              PlainHTTPMode
+	redirectWhenCertReady      bool
+	certReady                  atomic.Bool
 	certMgr                    *autocert.Manager
 	serveSSLFunc               func() bool
 	httpsPort                  string
 	httpPort                   string
 	gracefulnessTimeout        time.Duration
+	shutdownAttempts           int
+	shutdownRetryDelay         time.Duration
 	gracefulShutdownErrHandler func(error)
-	testing                    bool
+	confirmCertsOnStart        bool
+	sslRequired                bool
+	serveContentOverHTTP       func()
+	certStartTimeout           time.Duration
+	primeConcurrency           int
+	primeRateLimit             time.Duration
+	servingSSL                 bool
+	onHTTPSListening           func(string)
+	certSelector               func(*tls.ClientHelloInfo) (*tls.Certificate, bool)
+	shutdown                   chan string
+	abort                      chan struct{}
+	stopping                   context.Context
+	stop                       context.CancelFunc
+	done                       chan struct{}
+	offline                    bool
+	readOnlyCache              bool
+	certificates               []tls.Certificate
+	devCert                    *x509.Certificate
+	internalRootCAs            *x509.CertPool
+	verifyPrimedCerts          bool
+	originCertFile             string
+	originKeyFile              string
+	origin                     atomic.Pointer[tls.Certificate]
+	certFetcher                certFetcher
+	newRenewer                 func() certFetcher
+	renewed                    sync.Map // force renewed *tls.Certificate by cache key
+	renewing                   sync.Map // cache keys of the certificates being force renewed
+	challenges                 *challengeTracker
+	listenConfig               *net.ListenConfig
+	network                    string
+	tcpKeepAlive               time.Duration
+	stateMu                    sync.Mutex
+	state                      ServerState
+	startedAt                  time.Time
+	conns                      connTracker
+	onDrainTimeout             func(int)
+	onDrainHijacked            func(int)
+	renewals                   failureTracker
+	onRenewalFailure           func(string, error, int)
+	onServingStaleCert         func(string, time.Duration)
+	expiryWarningThreshold     time.Duration
+	signalStrategies           map[os.Signal]ShutdownStrategy
+	logCertFingerprints        bool
+	loggedCerts                sync.Map // fingerprint -> struct{}
+	onExpiryWarning            func(string, time.Duration)
+	tlsHandshakeTimeout        time.Duration
+	challengeServer            *http.Server
+	httpChallengePort          string
+	tlsALPNFallback            bool
+	http01                     bool
+	shutdownOrder              ShutdownOrder
+	drainMode                  DrainMode
+	httpDrainTimeout           time.Duration
+	httpsDrainTimeout          time.Duration
+	clientAuth                 tls.ClientAuthType
+	clientCAs                  *x509.CertPool
+	clientCertVerifier         func([][]byte, [][]*x509.Certificate) error
+	getConfigForClient         func(*tls.ClientHelloInfo) (*tls.Config, error)
+	hostTLSOverrides           map[string]TLSOptions
+	restrictChallengeALPN      bool
+	onStart                    func(context.Context) error
+	ready                      chan struct{}
+	background                 []func(context.Context) error
+	backgroundWG               sync.WaitGroup
+	tlsALPN01                  bool
+	socketActivation           bool
+	inherited                  map[string]net.Listener
+	httpListener               net.Listener
+	httpsListener              net.Listener
+	listeners                  []ListenerConfig
+	rebindMu                   sync.Mutex
+	closeIdleFirst             bool
 }
 
 // ServerConfig holds configuration to initialize a SecureServer.
@@ -37,16 +125,54 @@ type ServerConfig struct {
 	// Hostnames for which the server is allowed to serve HTTPS.
 	// If the server receives an https request through a DNS name or IP
 	// not contained in this list, the request will be denied
-	// (REQUIRED)
+	// (REQUIRED unless Certificates are provided)
 	Hostnames []string
 
 	// The server's http handler
 	// (REQUIRED)
 	Handler http.Handler
 
+	// HTTPHandler, when set, serves the plain HTTP listener while Handler
+	// serves HTTPS only (i.e. to only redirect on the insecure port).
+	// ACME challenges are answered before requests reach it. This is
+	// only used while serving HTTPS with the ServeContent PlainHTTPMode,
+	// otherwise Handler serves plain HTTP
+	// Default behavior is to serve Handler on both listeners
+	HTTPHandler http.Handler
+
+	// PlainHTTPMode determines what the plain HTTP listener (along with
+	// the HTTPChallengePort's, if any) serves besides ACME challenges
+	// while serving HTTPS. ChallengeOnly responds 404 Not Found to every
+	// other request, so that nothing is exposed over plain HTTP
+	// Default value is ServeContent
+	PlainHTTPMode PlainHTTPMode
+
+	// RedirectWhenCertReady holds off redirecting plain HTTP requests to
+	// HTTPS (with the RedirectToHTTPS PlainHTTPMode, or on the
+	// HTTPChallengePort) until a valid certificate for one of the
+	// Hostnames is cached, responding 503 Service Unavailable until then.
+	// This keeps an instance coming up during a rolling deploy from
+	// sending clients to an HTTPS listener it has no certificate for yet
+	// Default behavior is to redirect as soon as the server starts
+	RedirectWhenCertReady bool
+
+	// GRPCHandler, when set, serves the gRPC requests (HTTP/2 requests with
+	// an application/grpc content type) received over HTTPS, e.g. a
+	// *grpc.Server, while Handler serves all others. gRPC requests go
+	// through request IDs, client IP filtering (AllowedCIDRs and
+	// DeniedCIDRs) and host checks, but are routed before the rest of the
+	// middleware, whose responses (e.g. compressed bodies, 503s over the
+	// MaxConcurrentRequests or 500s from panic recovery) would break the
+	// gRPC protocol. Concurrency, timeouts and body sizes are thus left to
+	// the GRPCHandler, as are recovering from its panics and the user's
+	// Middlewares
+	// Default behavior is to serve gRPC requests with Handler
+	GRPCHandler http.Handler
+
 	// ServeSSLFunc is called to determine whether to serve HTTPS
 	// or not. This function's enables users to purpusely disable
-	// HTTPS i.e. for local development.
+	// HTTPS i.e. for local development. See ServeSSLFromEnv, ServeSSLAlways
+	// and ServeSSLNever for ready-made implementations.
 	// Default behavior is to serve HTTPS
 	ServeSSLFunc func() bool
 
@@ -56,6 +182,45 @@ type ServerConfig struct {
 	// Default behavior is to store at "." in the file system
 	CertCache autocert.Cache
 
+	// Certificates, when provided, are served over HTTPS instead of
+	// certificates obtained through ACME, which is then never used (e.g.
+	// for certificates issued by a corporate CA). The certificate served
+	// is chosen by SNI as described for tls.Config.Certificates, after
+	// the CertSelector (if any) falls through. Hostnames are optional
+	// in this mode, and the CertCache is left unused
+	// Default behavior is to obtain certificates through ACME
+	Certificates []tls.Certificate
+
+	// OriginCertFile and OriginKeyFile are the paths to the PEM encoded
+	// certificate (chain) and key to serve over HTTPS, such as the origin
+	// certificate of a CDN terminating TLS in front of the server (where
+	// HTTP-01 challenges could not be answered anyway). The certificate
+	// is served as if it were one of the Certificates, so ACME is never
+	// used, and must be valid for every one of the Hostnames. Both files
+	// are read again whenever the process receives a SIGUSR1 while
+	// serving, so that a rotated certificate is served without a restart.
+	// Should the new files be invalid, the current certificate is kept
+	// being served
+	// Default behavior is to obtain certificates through ACME
+	OriginCertFile string
+	OriginKeyFile  string
+
+	// DevMode serves a self-signed certificate, generated by NewServer for
+	// the Hostnames (or for localhost if there are none), instead of
+	// obtaining certificates through ACME, for local development and
+	// integration tests. Clients trust it through the ClientTLSConfig
+	// Default value is false
+	DevMode bool
+
+	// ChallengeTokenStore, when set, stores the tokens of pending HTTP-01
+	// challenges instead of the CertCache. Every instance of a fleet
+	// sharing a token store (e.g. one backed by Redis) can then answer the
+	// challenges of certificates requested by any of them, even when
+	// their CertCaches are not shared. Tokens are stored at keys ending
+	// in "+http-01", and only for as long as their challenge is pending
+	// Default behavior is to store tokens in the CertCache
+	ChallengeTokenStore autocert.Cache
+
 	// Default value is ":443"
 	HTTPSPort string
 
@@ -65,22 +230,738 @@ type ServerConfig struct {
 	// Default value is 5 seconds
 	ReadTimeout time.Duration
 
+	// WriteTimeout bounds the time spent writing responses. Handlers
+	// streaming long-lived responses may extend it with ExtendWriteDeadline,
+	// or stream server-sent events with NewSSEWriter
 	// Default value is 5 seconds
 	WriteTimeout time.Duration
 
 	// Default value is 25 seconds
 	IdleTimeout time.Duration
 
+	// HTTPTimeouts override the ReadTimeout, WriteTimeout and IdleTimeout
+	// on the plain HTTP listener (along with the HTTPChallengePort's, if
+	// any), e.g. to keep them aggressive where requests are only redirected
+	// or answer ACME challenges
+	// Default behavior is to use the shared timeouts
+	HTTPTimeouts Timeouts
+
+	// HTTPSTimeouts override the ReadTimeout, WriteTimeout and IdleTimeout
+	// on the HTTPS listener
+	// Default behavior is to use the shared timeouts
+	HTTPSTimeouts Timeouts
+
 	// Default value is 5 seconds
 	GracefulnessTimeout time.Duration
 
+	// ShutdownAttempts is the number of times draining connections is
+	// attempted during a graceful shutdown, each attempt within the
+	// GracefulnessTimeout (or drain timeouts), before giving up. Stragglers
+	// on a busy server get more chances to finish in between, and once
+	// every attempt timed out the remaining connections are closed.
+	// Each failed attempt is logged through the Logger
+	// Default value is 1, leaving connections open after a timeout
+	ShutdownAttempts int
+
+	// ShutdownRetryDelay is the time waited between ShutdownAttempts
+	// Default behavior is to retry right away
+	ShutdownRetryDelay time.Duration
+
+	// DrainMode determines how the GracefulnessTimeout (and the HTTP and
+	// HTTPS drain timeouts) bound a graceful shutdown. With DrainProgressBased,
+	// they bound the time without any request completing rather than the
+	// time spent draining, so that long but active requests are waited for
+	// while a stalled drain is still given up on
+	// Default value is DrainFixedTimeout
+	DrainMode DrainMode
+
 	// GracefulShutdownErrHandler is called to handle the event of an error during
 	// a graceful shutdown (accept no more connections, and wait for existing
 	// ones to finish within the GracefulnessTimeout)
 	// Default value is a NOP
 	GracefulShutdownErrHandler func(error)
+
+	// OfflineMode restricts the server to certificates already present
+	// in the CertCache. The ACME server is never contacted, which makes
+	// behavior predictable in air-gapped deployments. Handshakes for
+	// hostnames without a valid cached certificate fail with
+	// ErrOfflineCacheMiss
+	// Default value is false
+	OfflineMode bool
+
+	// ReadOnlyCache makes the server only read certificates from the
+	// CertCache, never requesting or writing any itself. It is meant for
+	// fleets sharing a CertCache in which a single leader instance obtains
+	// certificates: handshakes for hostnames the leader has not yet cached
+	// fail with ErrReadOnlyCacheMiss, while HTTP-01 challenges for the
+	// leader's orders are still answered from the shared cache
+	// Default value is false
+	ReadOnlyCache bool
+
+	// OnHTTPSListening is called once the HTTPS listener has been bound,
+	// with the address it is bound to. This is a reliable signal that
+	// the server is ready to accept TLS connections
+	// Default value is a NOP
+	OnHTTPSListening func(addr string)
+
+	// RequireCertsOnStart makes Start fail, instead of serving, whenever
+	// no certificate could be obtained for any of the Hostnames within the
+	// CertStartTimeout. This prevents a server which fails every HTTPS
+	// request from silently coming up
+	// Default value is false
+	RequireCertsOnStart bool
+
+	// ConfirmCertsOnStart makes Start confirm that a certificate can be
+	// obtained for any of the Hostnames within the CertStartTimeout before
+	// it returns, like RequireCertsOnStart does. When none can, the server
+	// fails to start if SSLRequired is set, and otherwise stops serving
+	// HTTPS, logging a warning, and serves the Handler over plain HTTP only
+	// as if the ServeSSLFunc had returned false
+	// Default value is false
+	ConfirmCertsOnStart bool
+
+	// SSLRequired makes Start fail with ErrNoCertificates, rather than fall
+	// back to plain HTTP, when ConfirmCertsOnStart finds no certificate
+	// can be obtained. ConfirmCertsOnStart with SSLRequired set is the same
+	// as RequireCertsOnStart
+	// Default value is false
+	SSLRequired bool
+
+	// Default value is 1 minute
+	CertStartTimeout time.Duration
+
+	// PrimeConcurrency bounds the number of hostnames certificates are
+	// obtained for in parallel by PrimeCerts (and RequireCertsOnStart)
+	// and renewed in parallel by RenewAll
+	// Default value is 1, obtaining certificates one hostname at a time
+	PrimeConcurrency int
+
+	// PrimeRateLimit is the minimum interval between PrimeCerts (and
+	// RequireCertsOnStart) or RenewAll starting to obtain the certificates
+	// of two hostnames, which paces requests to the CA to keep clear of its
+	// rate limits
+	// Default behavior is not to pace requests
+	PrimeRateLimit time.Duration
+
+	// VerifyPrimedCerts has PrimeCerts (and RequireCertsOnStart) complete a
+	// TLS handshake for each hostname with the server itself once its
+	// certificate was obtained, reporting hostnames whose certificate does
+	// not verify against the InternalRootCAs. No request is sent, so the
+	// Handler is not involved. Nothing is verified while the server does
+	// not serve HTTPS, nor when its ClientAuth requires a certificate
+	// Default value is false
+	VerifyPrimedCerts bool
+
+	// InternalRootCAs are the roots the server's connections to itself
+	// (such as those of VerifyPrimedCerts) verify certificates against. In
+	// DevMode the self-signed certificate is trusted as well
+	// Default behavior is to use the system roots
+	InternalRootCAs *x509.CertPool
+
+	// Logger receives every message the server logs, including those of
+	// net/http (e.g. TLS handshake errors) and superfluous WriteHeader
+	// calls made by handlers, along with the request they were made for
+	// Default behavior is to log through the standard library's log package
+	Logger Logger
+
+	// CacheObserver, when set, is notified of every operation performed
+	// against the CertCache along with its duration
+	// Default behavior is not to observe the cache
+	CacheObserver CacheObserver
+
+	// OnCacheWriteError is called with the key and error of every failed
+	// write to the CertCache (e.g. a full disk or missing permissions),
+	// which otherwise goes unnoticed while certificates keep being issued
+	// again. Failed writes are also logged through the Logger
+	// Default behavior is to only log failed writes
+	OnCacheWriteError func(key string, err error)
+
+	// CacheWriteFailureLimit, when set, is the number of consecutive failed
+	// writes to the CertCache after which certificate issuance is suspended
+	// (failing with ErrCacheWriteFailing) for ten minutes, to keep clear of
+	// the CA's rate limits. Certificates already obtained are still served
+	// Default behavior is never to suspend issuance
+	CacheWriteFailureLimit int
+
+	// RequestIDHeader is the name of the header (i.e. "X-Request-ID") from
+	// which each request's ID is read, or generated if absent. The ID is
+	// echoed back in the response header and can be retrieved by handlers
+	// with RequestIDFromContext
+	// Default behavior is not to assign request IDs
+	RequestIDHeader string
+
+	// CertSelector is consulted first on every TLS handshake, allowing
+	// certificates to be chosen based on any attribute of the ClientHello
+	// (i.e. ALPN protocols or supported signature schemes). Returning
+	// (cert, true) serves cert, while (nil, false) falls through to the
+	// regular certificate management flow
+	// Default behavior is to always fall through
+	CertSelector func(hello *tls.ClientHelloInfo) (*tls.Certificate, bool)
+
+	// EnablePprof mounts net/http/pprof's profiling endpoints under the
+	// PprofPrefix. These are only ever served to requests received over
+	// HTTPS, and it is strongly recommended to guard them with PprofAuth
+	// Default value is false
+	EnablePprof bool
+
+	// Default value is "/debug/pprof/"
+	PprofPrefix string
+
+	// PprofAuth, when set, requires basic-auth credentials for every
+	// request to the pprof endpoints
+	// Default behavior is no authentication
+	PprofAuth *BasicAuth
+
+	// AllowEarlyData requests acceptance of TLS 1.3 early data (0-RTT).
+	// Early data can be replayed by an attacker, so handlers receiving it
+	// must only perform idempotent operations. Go's crypto/tls never
+	// accepts early data, so the server always behaves safely and setting
+	// this field makes NewServer fail with ErrEarlyDataUnsupported rather
+	// than silently ignoring it
+	// Default value is false
+	AllowEarlyData bool
+
+	// ListenConfig is used to create the server's listeners, allowing
+	// socket options (i.e. SO_REUSEPORT) to be applied through its
+	// Control function
+	// Default behavior is to use a zero valued net.ListenConfig
+	ListenConfig *net.ListenConfig
+
+	// Network is the network the server's listeners are bound on: "tcp4"
+	// or "tcp6" to only bind IPv4 or IPv6 addresses, or "tcp" for both.
+	// Inherited listeners (see SocketActivation) are used as they are
+	// Default value is "tcp"
+	Network string
+
+	// TCPKeepAlive is the period between TCP keep-alive probes on accepted
+	// connections, which detect dead peers behind stateful firewalls
+	// silently dropping idle flows. A negative value disables keep-alive
+	// probes. It applies to every listener, including inherited ones, and
+	// takes precedence over the ListenConfig's KeepAlive
+	// Default behavior is to use Go's default keep-alive period
+	TCPKeepAlive time.Duration
+
+	// Compression configures transparent gzip/deflate compression of
+	// responses based on the request's Accept-Encoding header
+	// Default behavior is not to compress responses
+	Compression CompressionConfig
+
+	// NotFoundHandler, when set, serves the 404 Not Found responses of the
+	// Handler which have an empty body or the body of http.NotFound (as
+	// written by http.ServeMux for unmatched routes), e.g. for a branded
+	// not found page. Responses it writes default to 404 Not Found
+	// Default behavior is to leave not found responses alone
+	NotFoundHandler http.Handler
+
+	// RobotsTxt and SecurityTxt, when set, are served as plain text at
+	// /robots.txt and /.well-known/security.txt respectively, ahead of the
+	// Handler. Only those exact paths are served, leaving the rest of
+	// /.well-known/ (e.g. ACME challenges) to the Handler
+	// Default behavior is to leave these paths to the Handler
+	RobotsTxt   string
+	SecurityTxt string
+
+	// EnableSecurityHeaders adds the SecurityHeaders to every response
+	// served over HTTPS. Headers set by the handler are never overwritten
+	// Default value is false
+	EnableSecurityHeaders bool
+
+	// Default value sets X-Content-Type-Options, X-Frame-Options,
+	// Referrer-Policy and a Content-Security-Policy forbidding framing
+	SecurityHeaders map[string]string
+
+	// OnDrainTimeout is called whenever the GracefulnessTimeout elapses
+	// before all connections are drained during a graceful shutdown, with
+	// the number of connections still open. This allows slow draining
+	// deploys to be told apart from genuine shutdown failures, which are
+	// reported only through the GracefulShutdownErrHandler
+	// Default value is a NOP
+	OnDrainTimeout func(remaining int)
+
+	// DrainHijacked makes graceful shutdowns wait (within the
+	// GracefulnessTimeout) for hijacked connections, e.g. WebSockets, to be
+	// closed, which http.Server.Shutdown otherwise leaves to be cut off
+	// when the process exits. Hijacked connections still open once every
+	// ShutdownAttempts timed out are closed along with the rest
+	// Default value is false
+	DrainHijacked bool
+
+	// OnDrainHijacked is called as draining begins with DrainHijacked set,
+	// with the number of hijacked connections open, so that the
+	// application can close them gracefully (e.g. by sending WebSocket
+	// close frames). It is called again on every retry of ShutdownAttempts
+	// Default value is a NOP
+	OnDrainHijacked func(open int)
+
+	// OnRenewalFailure is called whenever obtaining a certificate for one
+	// of the Hostnames fails, with the number of consecutive failures for
+	// that hostname. The count is reset once a certificate is obtained.
+	// This enables escalation (i.e. page after N failures) which the CA's
+	// own expiry emails cannot provide
+	// Default value is a NOP
+	OnRenewalFailure func(host string, err error, consecutiveFailures int)
+
+	// OnServingStaleCert is called whenever a certificate could not be
+	// obtained for a handshake (e.g. the CA is down) and the still valid
+	// certificate cached for the hostname is served instead, with the time
+	// left until it expires. Handshakes only fail once it has expired
+	// Default behavior is to log the degraded mode through the Logger
+	OnServingStaleCert func(host string, expiresIn time.Duration)
+
+	// ExpiryWarningThreshold is the time before its expiry from which a
+	// certificate served during a TLS handshake is reported to the
+	// OnExpiryWarning hook. This is a safety net catching stuck renewals
+	// (autocert renews certificates 30 days before they expire), e.g.
+	// with a threshold of 7 days
+	// Default behavior is not to check the expiry of served certificates
+	ExpiryWarningThreshold time.Duration
+
+	// OnExpiryWarning is called on every TLS handshake serving a
+	// certificate which expires within the ExpiryWarningThreshold, with
+	// the requested hostname and the time left until the certificate
+	// expires (negative once it has)
+	// Default behavior is to log the warning through the Logger
+	OnExpiryWarning func(host string, expiresIn time.Duration)
+
+	// LogCertFingerprints logs the SHA-256 fingerprint, serial number and
+	// SANs of every certificate obtained through ACME (or read from the
+	// OriginCertFile) the first time it is served, as an audit trail to
+	// correlate with Certificate Transparency monitoring. Events go to the
+	// Logger, as CertEvents if it implements the CertLogger interface
+	// Default value is false
+	LogCertFingerprints bool
+
+	// CacheHealthCheckInterval, when set, is the interval at which the
+	// cached certificate of each of the Hostnames is read back and
+	// verified while the server is serving (starting as soon as it is
+	// Ready), surfacing unreadable entries or clock skew before they fail
+	// handshakes. Results are reported to OnCacheHealth
+	// Default behavior is not to check the cache
+	CacheHealthCheckInterval time.Duration
+
+	// OnCacheHealth is called with the outcome of checking each hostname's
+	// cached certificate, every CacheHealthCheckInterval
+	// Default behavior is to log the hostnames failing the check through
+	// the Logger
+	OnCacheHealth func(host string, ok bool, err error)
+
+	// PanicHandler is called to respond to requests whose handler panicked
+	// (i.e. with a branded error page), after the panic and its stack trace
+	// are logged through the Logger
+	// Default behavior is to respond with a 500 Internal Server Error
+	PanicHandler func(w http.ResponseWriter, r *http.Request, recovered interface{})
+
+	// OnPanicDumpGoroutines captures the stack traces of all goroutines
+	// when a handler panics, which helps diagnosing deadlocks and leaks.
+	// The dump is logged instead of the panicking goroutine's stack trace,
+	// and passed to the PanicHandler (see GoroutineDumpFromContext). As
+	// capturing it stops the world, it is best kept off unless needed
+	// Default value is false
+	OnPanicDumpGoroutines bool
+
+	// DisablePanicRecovery leaves panics in the handler to net/http, which
+	// abruptly closes the connection without logging through the Logger
+	// Default value is false
+	DisablePanicRecovery bool
+
+	// TLSHandshakeTimeout bounds the time a client may take to complete
+	// the TLS handshake, independently of the ReadTimeout which otherwise
+	// covers it. This keeps slow or malicious handshakes from holding on
+	// to connection resources
+	// Default behavior is to bound handshakes by the Read/WriteTimeout
+	TLSHandshakeTimeout time.Duration
+
+	// HostPatterns approves additional hostnames for HTTPS by pattern, such
+	// as "*.customer.example.com". Patterns are matched label by label, so
+	// a "*" label matches exactly one label of the hostname. Each label
+	// supports the syntax of path.Match. Patterns must end in a registrable
+	// domain free of wildcards, so "*.com" or "*.co.uk" are rejected
+	// Default behavior is to approve only the Hostnames
+	HostPatterns []string
+
+	// OnHostPolicyCheck, when set, is called with every decision of the
+	// host policy approving hostnames for certificates (the Hostnames and
+	// HostPatterns), which are also logged through the Logger. This makes
+	// handshakes failing for unapproved hostnames observable
+	// Default behavior is not to report host policy decisions
+	OnHostPolicyCheck func(ctx context.Context, host string, allowed bool)
+
+	// MaxRequestBodySize rejects requests with a 413 Request Entity Too
+	// Large whenever their declared Content-Length exceeds it, before the
+	// handler runs. Since net/http only answers "Expect: 100-continue" once
+	// the body is first read, such clients are rejected without ever being
+	// told to send their body. Bodies without a declared length are cut off
+	// once they exceed the limit, and in all cases the time spent receiving
+	// a body remains bounded by the ReadTimeout
+	// Default behavior is to not limit request bodies
+	MaxRequestBodySize int64
+
+	// HTTPChallengePort, when set, answers ACME HTTP-01 challenges on a
+	// listener of its own, leaving the HTTPPort to serve only the
+	// HTTPHandler (or Handler) on plain HTTP
+	// Default behavior is to answer challenges on the HTTPPort
+	HTTPChallengePort string
+
+	// TLSALPNFallback lets the server start without answering HTTP-01
+	// challenges whenever it lacks the privileges to bind the port they
+	// are answered on (e.g. ":80" when not running as root). Certificates
+	// are then obtained solely through TLS-ALPN-01 challenges, which are
+	// answered on the HTTPSPort
+	// Default value is false (i.e. failing to start)
+	TLSALPNFallback bool
+
+	// StaticDir, when set, serves the files in the given directory under
+	// the StaticPrefix, while all other requests reach the Handler
+	// Default behavior is to serve no static files
+	StaticDir string
+
+	// StaticPrefix is the URL path the StaticDir is served under
+	// Default value is "/static/"
+	StaticPrefix string
+
+	// StaticSPAFallback answers requests under the StaticPrefix which match
+	// no file with the StaticDir's index.html, as single page applications
+	// routing on the client side expect
+	// Default value is false
+	StaticSPAFallback bool
+
+	// SignalStrategies determines how the server shuts down on receipt of
+	// each of SIGTERM and SIGINT, e.g. ShutdownImmediate for SIGINT stops
+	// a local development server on Ctrl+C at once while SIGTERM still
+	// drains connections on deploys. Shutdowns through TriggerShutdown are
+	// always graceful
+	// Default behavior is ShutdownGraceful for both signals
+	SignalStrategies map[os.Signal]ShutdownStrategy
+
+	// ShutdownOrder determines the sequence in which the HTTP and HTTPS
+	// listeners stop accepting connections and drain on shutdown. e.g.
+	// ShutdownHTTPFirst lets a load balancer deregister the server on its
+	// HTTP health checks while HTTPS connections are still being served
+	// Default value is ShutdownConcurrently
+	ShutdownOrder ShutdownOrder
+
+	// HTTPDrainTimeout bounds the time the HTTP listener (along with the
+	// HTTPChallengePort's, if any) is given to drain its connections
+	// Default value is the GracefulnessTimeout
+	HTTPDrainTimeout time.Duration
+
+	// HTTPSDrainTimeout bounds the time the HTTPS listener is given to
+	// drain its connections
+	// Default value is the GracefulnessTimeout
+	HTTPSDrainTimeout time.Duration
+
+	// ACMEResolver resolves the hostnames the ACME client connects to,
+	// such as the CA's directory. This matters in split-horizon DNS
+	// environments, where the system resolver may not return the
+	// endpoints the ACME client should be talking to
+	// Default behavior is to use the system resolver
+	ACMEResolver *net.Resolver
+
+	// ACMEDialTimeout bounds the time the ACME client waits for a
+	// connection to the CA to be established
+	// Default value is 30 seconds
+	ACMEDialTimeout time.Duration
+
+	// ACMERequestTimeout bounds the time the ACME client waits for each
+	// request to the CA to complete, from connecting to reading the whole
+	// response, so that a hung CA does not stall issuance indefinitely
+	// Default behavior is not to time requests out
+	ACMERequestTimeout time.Duration
+
+	// ACMEDirectoryURL is the directory URL of the ACME CA certificates
+	// are obtained from, e.g. that of a private CA such as step-ca
+	// Default value is Let's Encrypt's production directory
+	ACMEDirectoryURL string
+
+	// ACMEServerRootCAs, when set, are the only roots trusted for the TLS
+	// connections of the ACME client, pinning those of the CA (e.g. a
+	// private CA whose endpoint certificate is not publicly trusted)
+	// Default behavior is to trust the system's root CAs
+	ACMEServerRootCAs *x509.CertPool
+
+	// ACMEExternalAccountBinding binds the ACME account to an account the
+	// CA already knows of, for CAs requiring it (see NewServerWithACME)
+	// Default behavior is not to bind the account
+	ACMEExternalAccountBinding *acme.ExternalAccountBinding
+
+	// Middlewares wrap the Handler (and HTTPHandler), the first listed
+	// being the outermost. They run after sslmgr's own middleware, which
+	// handles (in order) request IDs, client IP filtering, host checks,
+	// gRPC routing, CORS, concurrency limits, slow requests, request
+	// timeouts, body limits, pprof, server and security headers,
+	// compression, robots.txt and security.txt, not found pages and panic
+	// recovery. Static files are served after all middleware ran, while
+	// ACME challenges are answered before any does
+	// Default behavior is to apply no additional middleware
+	Middlewares []Middleware
+
+	// ConnIdleTimeout closes connections on which no request is received
+	// within the given time of them being accepted, including those which
+	// complete the TLS handshake and then send nothing. Unlike IdleTimeout,
+	// which only applies in between requests, it bounds the time a client
+	// may hold on to a connection without ever beginning a request
+	// Default behavior is to rely on the ReadTimeout alone
+	ConnIdleTimeout time.Duration
+
+	// RequestTimeout sets a deadline on the context of every request, which
+	// is canceled once it passes (or the client goes away), so that work
+	// done on behalf of the request can be abandoned. No response is forced
+	// on the client: handlers decide how to respond to a canceled context.
+	// Note that the WriteTimeout still cuts off responses written too late
+	// Default behavior is to only cancel contexts once clients go away
+	RequestTimeout time.Duration
+
+	// MaxConcurrentRequests caps the number of requests the Handler (and,
+	// separately, the HTTPHandler) serves at once, protecting downstream
+	// services requests fan out to. Requests over the limit wait for up to
+	// the ConcurrencyQueueTimeout for another to finish, and are responded
+	// to with 503 Service Unavailable if none does (or the client goes away)
+	// Default behavior is not to limit concurrent requests
+	MaxConcurrentRequests int
+
+	// ConcurrencyQueueTimeout is how long requests over the
+	// MaxConcurrentRequests wait for a slot to free up before they are
+	// responded to with 503 Service Unavailable
+	// Default behavior is to respond 503 Service Unavailable at once
+	ConcurrencyQueueTimeout time.Duration
+
+	// SlowRequestThreshold is the latency above which requests are reported
+	// to the OnSlowRequest hook
+	// Default behavior is to not time requests
+	SlowRequestThreshold time.Duration
+
+	// OnSlowRequest is called with every request which took longer than
+	// the SlowRequestThreshold to serve, along with the time it took
+	// Default behavior is to log slow requests through the Logger
+	OnSlowRequest func(r *http.Request, d time.Duration)
+
+	// AllowedCIDRs, when set, restricts the server to clients with an IP
+	// address in one of the given ranges (e.g. a corporate VPN), others
+	// being rejected with a 403 Forbidden before reaching the Handler.
+	// ACME challenges are answered regardless
+	// Default behavior is to allow every client
+	AllowedCIDRs []netip.Prefix
+
+	// DeniedCIDRs rejects clients with an IP address in any of the given
+	// ranges with a 403 Forbidden, even when they are in the AllowedCIDRs
+	// Default behavior is to deny no client
+	DeniedCIDRs []netip.Prefix
+
+	// TrustedProxies are the ranges of the reverse proxies (e.g. a load
+	// balancer) the server sits behind. For requests received from them,
+	// the AllowedCIDRs and DeniedCIDRs apply to the client IP address they
+	// forwarded in the X-Forwarded-For header instead
+	// Default behavior is to trust no proxy, using the peer's IP address
+	TrustedProxies []netip.Prefix
+
+	// RequireHostHeader rejects requests with a 400 Bad Request whenever
+	// their Host header is missing, empty, or names neither one of the
+	// Hostnames nor a hostname matching the HostPatterns. Without either,
+	// the names the Certificates (or DevMode's) are valid for are allowed
+	// instead, and nothing is checked when serving the OriginCertFile alone
+	// Default value is false
+	RequireHostHeader bool
+
+	// ClientAuth is the policy for TLS client certificates (mTLS). Note that
+	// policies requiring a certificate also apply to the CA's TLS-ALPN-01
+	// challenge connections, so certificates must be obtained over HTTP-01
+	// Default value is tls.NoClientCert
+	ClientAuth tls.ClientAuthType
+
+	// Listeners are additional HTTPS listeners, each with TLS settings
+	// (and optionally a handler) of its own, e.g. to require client
+	// certificates on an internal address only. They are served alongside
+	// the HTTPSPort while serving HTTPS
+	// Default behavior is to only listen at the HTTPSPort
+	Listeners []ListenerConfig
+
+	// ClientCAs are the certificate authorities client certificates are
+	// verified against
+	// Default behavior is to verify against the system's root CAs
+	ClientCAs *x509.CertPool
+
+	// ClientCertVerifier is called after a client certificate is verified
+	// against the ClientCAs, and fails the handshake by returning an error.
+	// It allows for checks plain verification doesn't make, such as
+	// revocation (see RevocationListVerifier)
+	// Default behavior is to make no additional checks
+	ClientCertVerifier func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
+	// GetConfigForClient, when set, is wired to the HTTPS listener's
+	// tls.Config.GetConfigForClient to vary TLS settings per connection
+	// (e.g. stricter cipher suites for some hostnames). Returning a nil
+	// config keeps sslmgr's. A returned config without a GetCertificate
+	// or Certificates is given sslmgr's, and one without NextProtos is
+	// given sslmgr's. It is not called for the handshakes of TLS-ALPN-01
+	// challenges
+	// Default behavior is to use the same config for every connection
+	GetConfigForClient func(hello *tls.ClientHelloInfo) (*tls.Config, error)
+
+	// HostTLSOverrides overrides TLS settings (e.g. the minimum version or
+	// cipher suites) for the handshakes of the given hostnames, matched by
+	// SNI, so that tenants with divergent compliance requirements can be
+	// served together. Certificates are obtained as for any other hostname,
+	// so each must be one of the Hostnames or match the HostPatterns, when
+	// set. A config returned by the GetConfigForClient takes precedence,
+	// the overrides only filling in the settings it leaves unset. On the
+	// Listeners, overrides apply on top of each listener's own settings,
+	// though never below its MinVersion
+	// Default behavior is to use the same settings for every hostname
+	HostTLSOverrides map[string]TLSOptions
+
+	// RestrictChallengeALPN limits negotiating the TLS-ALPN-01 challenge
+	// protocol (acme-tls/1) to handshakes for hostnames with a challenge
+	// pending, whether by this server or by another one sharing its
+	// CertCache, so that it is not exposed the rest of the time
+	// Default behavior is to negotiate it whenever TLS-ALPN-01 is enabled
+	RestrictChallengeALPN bool
+
+	// OnStart is run by Start once the listeners are serving and any
+	// certificates required by RequireCertsOnStart were obtained, to warm
+	// up (e.g. prime caches or establish connection pools) before the
+	// server is declared Ready. Its context is canceled if the server is
+	// shut down meanwhile. An error fails Start, stopping the server
+	// Default behavior is to declare the server Ready right away
+	OnStart func(ctx context.Context) error
+
+	// Background tasks (e.g. queue consumers) are run in their own
+	// goroutines once the server is Ready, with a context which is canceled
+	// when a graceful shutdown begins. The shutdown then waits for them to
+	// return, alongside connections draining and within the
+	// GracefulnessTimeout, failing with ErrBackgroundTimeout otherwise.
+	// Errors they return are logged through the Logger
+	// Default behavior is to run no background tasks
+	Background []func(ctx context.Context) error
+
+	// ChallengeTypes are the ACME challenge types certificates may be
+	// obtained through: ChallengeHTTP01 (answered on plain HTTP) and/or
+	// ChallengeTLSALPN01 (answered on the HTTPSPort). Challenges of an
+	// excluded type are never attempted, even when the CA offers them
+	// Default behavior is to allow both
+	ChallengeTypes []string
+
+	// SocketActivation serves on the listeners passed to the process by
+	// systemd (or any init system implementing its socket activation
+	// protocol, i.e. LISTEN_PID and LISTEN_FDS), rather than binding them.
+	// This allows serving on privileged ports without running as root.
+	// Inherited listeners are matched to the HTTPPort, HTTPSPort and
+	// HTTPChallengePort by the port they are bound to, and ports with no
+	// inherited listener are bound as usual
+	// Default value is false
+	SocketActivation bool
+
+	// CloseIdleFirst disables keep-alives on every listener as soon as a
+	// shutdown begins, closing idle connections right away. net/http only
+	// does so for each listener once it begins draining, so this speeds up
+	// shutdowns where listeners drain in sequence (see ShutdownOrder)
+	// Default value is false
+	CloseIdleFirst bool
+
+	// ServerHeader is set as the Server header of every response, replacing
+	// any set by the handler. Setting it to SuppressServerHeader instead
+	// removes any Server header set by the handler
+	// Default behavior is to leave the Server header to the handler
+	ServerHeader string
+
+	// AccountKey is the key of the ACME account certificates are obtained
+	// with. Sharing one key across a fleet of servers registers a single
+	// account for all of them, which keeps clear of the CA's rate limits on
+	// new accounts (and lets certificates be managed under one account)
+	// Default behavior is to create an account whose key is kept in the
+	// CertCache
+	AccountKey crypto.Signer
+
+	// Prompt is called with the URL of the CA's Terms of Service when an
+	// ACME account is registered, and must return true to accept them
+	// Default value is autocert.AcceptTOS
+	Prompt func(tosURL string) bool
+
+	// OnTOSAccepted is called whenever the Prompt accepts the CA's Terms of
+	// Service, with their URL and the time they were accepted at, to keep
+	// an audit trail of the acceptance. It is also logged through the
+	// Logger, along with the CA's directory URL
+	// Default value is a NOP
+	OnTOSAccepted func(tosURL string, at time.Time)
+
+	// MaxRequestsBeforeShutdown gracefully shuts the server down once it
+	// has served the given number of requests, for an orchestrator to
+	// restart it. This mitigates slow memory leaks in long-running processes
+	// Default behavior is to serve any number of requests
+	MaxRequestsBeforeShutdown int64
+
+	// CORS configures answering cross-origin (CORS) requests, including
+	// preflight OPTIONS requests
+	// Default behavior is to leave cross-origin requests to the handler
+	CORS CORSConfig
 }
 
+// PlainHTTPMode is what a server serves over plain HTTP besides ACME
+// challenges while serving HTTPS
+type PlainHTTPMode int
+
+const (
+	// ServeContent serves the HTTPHandler if set, or else the Handler
+	ServeContent PlainHTTPMode = iota
+	// RedirectToHTTPS redirects every request to HTTPS
+	RedirectToHTTPS
+	// ChallengeOnly responds 404 Not Found to every request
+	ChallengeOnly
+)
+
+// Timeouts override a server's shared timeouts on one of its listeners,
+// each zero valued timeout falling back to the shared one
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Idle  time.Duration
+}
+
+// apply overrides srv's timeouts with those set
+func (t Timeouts) apply(srv *http.Server) {
+	if t.Read != time.Duration(0) {
+		srv.ReadTimeout = t.Read
+	}
+	if t.Write != time.Duration(0) {
+		srv.WriteTimeout = t.Write
+	}
+	if t.Idle != time.Duration(0) {
+		srv.IdleTimeout = t.Idle
+	}
+}
+
+// DrainMode is the way a server bounds the time spent draining connections
+type DrainMode int
+
+const (
+	// DrainFixedTimeout gives up on draining once the drain timeout elapses
+	DrainFixedTimeout DrainMode = iota
+	// DrainProgressBased gives up on draining once the drain timeout
+	// elapses without any request completing
+	DrainProgressBased
+)
+
+// ShutdownStrategy is the way a server shuts down on receipt of a signal
+type ShutdownStrategy int
+
+const (
+	// ShutdownGraceful drains connections before closing the server
+	ShutdownGraceful ShutdownStrategy = iota
+	// ShutdownImmediate closes the server and every connection at once
+	ShutdownImmediate
+)
+
+// ShutdownOrder is the sequence in which a server's listeners are drained
+type ShutdownOrder int
+
+const (
+	// ShutdownConcurrently drains the HTTP and HTTPS listeners at once
+	ShutdownConcurrently ShutdownOrder = iota
+	// ShutdownHTTPFirst drains the HTTP listener before the HTTPS one
+	ShutdownHTTPFirst
+	// ShutdownHTTPSFirst drains the HTTPS listener before the HTTP one
+	ShutdownHTTPSFirst
+)
+
 var (
 	// ErrNoHostname is returned whenever a user calls NewSecureServer
 	// without any hostnames in the config
@@ -93,8 +974,131 @@ var (
 	// ErrNotAnInteger is returned whenever a user calls NewSecureServer with
 	// port definitions which do not correspont to integers. i.e. "not a number"
 	ErrNotAnInteger = errors.New("port number must be a numerical string")
+
+	// ErrInvalidHostPattern is returned whenever a user calls NewServer
+	// with a malformed or overly broad pattern in the HostPatterns
+	ErrInvalidHostPattern = errors.New("invalid host pattern")
+
+	// ErrNoCertificates is returned by Start whenever RequireCertsOnStart
+	// is set and no certificate could be obtained for any hostname
+	ErrNoCertificates = errors.New("no certificate could be obtained for any hostname")
+
+	// ErrEarlyDataUnsupported is returned whenever a user calls NewServer
+	// with AllowEarlyData set, since TLS 1.3 early data cannot be accepted
+	ErrEarlyDataUnsupported = errors.New("tls 1.3 early data (0-RTT) is not supported")
+
+	// ErrInvalidChallengeTypes is returned whenever a user calls NewServer
+	// with ChallengeTypes selecting no known challenge type
+	ErrInvalidChallengeTypes = errors.New("challenge types must be a non-empty list of http-01 and/or tls-alpn-01")
+
+	// ErrInvalidCORSConfig is returned whenever a user calls NewServer
+	// with an enabled but malformed CORS config
+	ErrInvalidCORSConfig = errors.New("invalid cors config")
+
+	// ErrInvalidOriginCert is returned whenever a user calls NewServer
+	// with an origin certificate which cannot be loaded or is not valid
+	// for the Hostnames
+	ErrInvalidOriginCert = errors.New("invalid origin certificate")
+
+	// ErrCacheWriteFailing is returned whenever a certificate would be
+	// issued while issuance is suspended after CacheWriteFailureLimit
+	// consecutive failed writes to the CertCache
+	ErrCacheWriteFailing = errors.New("certificate issuance suspended after repeated cache write failures")
+
+	// ErrInvalidACMEConfig is returned whenever a user calls
+	// NewServerWithACME with an incomplete or conflicting ACMEConfig
+	ErrInvalidACMEConfig = errors.New("invalid acme config")
+
+	// ErrInvalidListenerConfig is returned whenever a user calls NewServer
+	// with a malformed ListenerConfig in the Listeners
+	ErrInvalidListenerConfig = errors.New("invalid listener config")
+
+	// ErrInvalidTLSOptions is returned whenever a user calls NewServer with
+	// invalid TLSOptions in the HostTLSOverrides
+	ErrInvalidTLSOptions = errors.New("invalid tls options")
+
+	// ErrInvalidCIDR is returned whenever a user calls NewServer with an
+	// invalid prefix in the AllowedCIDRs, DeniedCIDRs or TrustedProxies
+	ErrInvalidCIDR = errors.New("invalid cidr")
+
+	// ErrInvalidNetwork is returned whenever a user calls NewServer with a
+	// Network other than "tcp", "tcp4" or "tcp6"
+	ErrInvalidNetwork = errors.New("invalid network")
+
+	// ErrPortPermission is returned by Start whenever a port could not be
+	// bound for lack of privileges
+	ErrPortPermission = errors.New("insufficient privileges to bind port: ports below 1024 require running as root or with the CAP_NET_BIND_SERVICE capability")
+
+	// ErrStartCanceled is returned by Start whenever a shutdown signal
+	// is received before the server finished starting up
+	ErrStartCanceled = errors.New("server startup canceled by shutdown")
+
+	// ErrNotServing is returned by Rebind whenever the server is not
+	// serving, i.e. it has not started or is shutting down
+	ErrNotServing = errors.New("server is not serving")
+
+	// ErrRenewalDisabled is returned by ForceRenew and RenewAll whenever
+	// certificates are not obtained from the CA, i.e. with Certificates,
+	// OfflineMode or a ReadOnlyCache
+	ErrRenewalDisabled = errors.New("certificate renewal is disabled")
+
+	// ErrBackgroundTimeout is returned during a graceful shutdown whenever
+	// Background tasks did not return within the GracefulnessTimeout
+	ErrBackgroundTimeout = errors.New("background tasks did not return in time")
+
+	// ErrOfflineCacheMiss is returned during a TLS handshake whenever the
+	// server is in OfflineMode and no valid certificate for the requested
+	// hostname is present in the cache
+	ErrOfflineCacheMiss = errors.New("no valid cached certificate (offline mode)")
+
+	// ErrReadOnlyCacheMiss is returned during a TLS handshake whenever the
+	// server has a ReadOnlyCache and no valid certificate for the requested
+	// hostname is present in the cache
+	ErrReadOnlyCacheMiss = errors.New("no valid cached certificate (read-only cache)")
+
+	// ErrReadOnlyCache is returned whenever a write to a ReadOnlyCache
+	// is attempted, e.g. by ImportCert
+	ErrReadOnlyCache = errors.New("certificate cache is read-only")
+
+	// ErrCacheDecryption is returned whenever a value read from a cache
+	// returned by NewEncryptedCache cannot be decrypted
+	ErrCacheDecryption = errors.New("certificate cache entry could not be decrypted")
+
+	// ErrInvalidCacheKey is returned whenever NewEncryptedCache is given
+	// a key which is not 32 bytes long
+	ErrInvalidCacheKey = errors.New("encrypted cache key must be 32 bytes")
 )
 
+// default values applied to a ServerConfig's zero valued fields
+const (
+	defaultHTTPPort            = ":80"
+	defaultHTTPSPort           = ":443"
+	defaultReadTimeout         = 5 * time.Second
+	defaultWriteTimeout        = 5 * time.Second
+	defaultIdleTimeout         = 25 * time.Second
+	defaultGracefulnessTimeout = 5 * time.Second
+	defaultCertStartTimeout    = time.Minute
+)
+
+// DefaultConfig returns a ServerConfig populated with every default value
+// NewServer would otherwise apply. Callers are expected to set the required
+// Hostnames and Handler fields, and may override any of the defaults
+func DefaultConfig() ServerConfig {
+	return ServerConfig{
+		ServeSSLFunc:               ServeSSLAlways,
+		CertCache:                  autocert.DirCache("."),
+		HTTPSPort:                  defaultHTTPSPort,
+		HTTPPort:                   defaultHTTPPort,
+		ReadTimeout:                defaultReadTimeout,
+		WriteTimeout:               defaultWriteTimeout,
+		IdleTimeout:                defaultIdleTimeout,
+		GracefulnessTimeout:        defaultGracefulnessTimeout,
+		GracefulShutdownErrHandler: func(e error) { /* NOP */ },
+		CertStartTimeout:           defaultCertStartTimeout,
+		Logger:                     stdLogger{},
+	}
+}
+
 // NewSecureServer returns a SecureServer with default configuration
 func NewSecureServer(h http.Handler, hostnames ...string) (*SecureServer, error) {
 	return NewServer(ServerConfig{
@@ -105,83 +1109,398 @@ func NewSecureServer(h http.Handler, hostnames ...string) (*SecureServer, error)
 
 // NewServer returns a SecureServer with the given config applied
 func NewServer(c ServerConfig) (*SecureServer, error) {
+	var origin *tls.Certificate
+	if c.OriginCertFile != "" || c.OriginKeyFile != "" {
+		cert, err := loadOriginCert(c.OriginCertFile, c.OriginKeyFile, c.Hostnames)
+		if err != nil {
+			return nil, err
+		}
+		// the certificate is served from ss.origin, which is swapped as it
+		// is reloaded, and a non-nil Certificates keeps ACME from being used
+		if c.Certificates == nil {
+			c.Certificates = []tls.Certificate{}
+		}
+		origin = &cert
+	}
+	var devCert *x509.Certificate
+	if c.DevMode {
+		cert, err := selfSignedCert(c.Hostnames)
+		if err != nil {
+			return nil, err
+		}
+		devCert = cert.Leaf
+		c.Certificates = append(c.Certificates[:len(c.Certificates):len(c.Certificates)], cert)
+	}
 	// check required fields
-	if c.Hostnames == nil || len(c.Hostnames) < 1 {
+	if len(c.Hostnames) < 1 && len(c.Certificates) < 1 && origin == nil {
 		return nil, ErrNoHostname
 	}
 	if c.Handler == nil {
 		return nil, ErrNoHandler
 	}
+	if err := validateHostPatterns(c.HostPatterns); err != nil {
+		return nil, err
+	}
+	http01, tlsALPN01, err := parseChallengeTypes(c.ChallengeTypes)
+	if err != nil {
+		return nil, err
+	}
+	if c.AllowEarlyData {
+		return nil, ErrEarlyDataUnsupported
+	}
+	if err := c.CORS.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateListeners(c.Listeners); err != nil {
+		return nil, err
+	}
+	if err := validatePrefixes(c.AllowedCIDRs, c.DeniedCIDRs, c.TrustedProxies); err != nil {
+		return nil, err
+	}
+	var overridePolicy autocert.HostPolicy
+	if len(c.Hostnames) > 0 || len(c.HostPatterns) > 0 {
+		overridePolicy = hostPolicy(c.Hostnames, c.HostPatterns)
+	}
+	hostTLSOverrides, err := normalizeTLSOverrides(c.HostTLSOverrides, overridePolicy)
+	if err != nil {
+		return nil, err
+	}
+	switch c.Network {
+	case "":
+		c.Network = "tcp"
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidNetwork, c.Network)
+	}
 	// cache implementation cant be empty
 	if c.CertCache == nil {
 		c.CertCache = autocert.DirCache(".")
 	}
 	// serve SSL by default
 	if c.ServeSSLFunc == nil {
-		c.ServeSSLFunc = func() bool {
-			return true
-		}
+		c.ServeSSLFunc = ServeSSLAlways
 	}
 	// NOP if graceful shutdown fails
 	if c.GracefulShutdownErrHandler == nil {
 		c.GracefulShutdownErrHandler = func(e error) { /* NOP */ }
 	}
+	if c.CertStartTimeout == time.Duration(0) {
+		c.CertStartTimeout = defaultCertStartTimeout
+	}
+	if c.PrimeConcurrency < 1 {
+		c.PrimeConcurrency = 1
+	}
+	if c.ListenConfig == nil {
+		c.ListenConfig = &net.ListenConfig{}
+	}
+	if c.Logger == nil {
+		c.Logger = stdLogger{}
+	}
+	// hostnames are matched in their canonical form
+	var duplicates []string
+	c.Hostnames, duplicates = normalizeHostnames(c.Hostnames)
+	for _, host := range duplicates {
+		c.Logger.Printf("[sslmgr] ignoring duplicate hostname %q", host)
+	}
+	if c.ChallengeTokenStore != nil {
+		c.CertCache = &tokenStoreCache{Cache: c.CertCache, tokens: c.ChallengeTokenStore}
+	}
+	// NOP when writing to the cache fails
+	if c.OnCacheWriteError == nil {
+		c.OnCacheWriteError = func(key string, err error) { /* NOP */ }
+	}
+	writes := &writeBreaker{limit: c.CacheWriteFailureLimit, logger: c.Logger}
+	c.CertCache = &writeFailureCache{Cache: c.CertCache, breaker: writes, onError: c.OnCacheWriteError}
+	if c.ReadOnlyCache {
+		c.CertCache = &readOnlyCache{Cache: c.CertCache}
+	}
+	challenges := &challengeTracker{}
+	c.CertCache = &challengeTrackingCache{Cache: c.CertCache, tracker: challenges}
+	if c.CacheObserver != nil {
+		c.CertCache = &observedCache{Cache: c.CertCache, observer: c.CacheObserver}
+	}
+	// NOP when draining connections times out
+	if c.OnDrainTimeout == nil {
+		c.OnDrainTimeout = func(remaining int) { /* NOP */ }
+	}
+	// NOP when hijacked connections are to be drained
+	if c.OnDrainHijacked == nil {
+		c.OnDrainHijacked = func(open int) { /* NOP */ }
+	}
+	// NOP when obtaining a certificate fails
+	if c.OnRenewalFailure == nil {
+		c.OnRenewalFailure = func(host string, err error, consecutiveFailures int) { /* NOP */ }
+	}
+	// NOP when the HTTPS listener is bound
+	if c.OnHTTPSListening == nil {
+		c.OnHTTPSListening = func(addr string) { /* NOP */ }
+	}
+	// NOP warmup on start
+	if c.OnStart == nil {
+		c.OnStart = func(ctx context.Context) error { return nil }
+	}
+	// log requests slower than the SlowRequestThreshold
+	if c.OnSlowRequest == nil {
+		logger := c.Logger
+		c.OnSlowRequest = func(r *http.Request, d time.Duration) {
+			logger.Printf("[sslmgr] slow request: %s %s took %s", r.Method, r.URL.Path, d)
+		}
+	}
+	// log stale cached certificates being served
+	if c.OnServingStaleCert == nil {
+		logger := c.Logger
+		c.OnServingStaleCert = func(host string, expiresIn time.Duration) {
+			logger.Printf("[sslmgr] could not obtain a certificate for %s, serving the cached one which expires in %s", host, expiresIn)
+		}
+	}
+	// log cached certificates failing their health check
+	if c.OnCacheHealth == nil {
+		logger := c.Logger
+		c.OnCacheHealth = func(host string, ok bool, err error) {
+			if !ok {
+				logger.Printf("[sslmgr] cached certificate for %s failed health check: %s", host, err)
+			}
+		}
+	}
+	// log certificates expiring within the ExpiryWarningThreshold
+	if c.OnExpiryWarning == nil {
+		logger := c.Logger
+		c.OnExpiryWarning = func(host string, expiresIn time.Duration) {
+			logger.Printf("[sslmgr] certificate served for %s expires in %s", host, expiresIn)
+		}
+	}
+	// accept the CA's Terms of Service
+	if c.Prompt == nil {
+		c.Prompt = autocert.AcceptTOS
+	}
+	// NOP when the Terms of Service are accepted
+	if c.OnTOSAccepted == nil {
+		c.OnTOSAccepted = func(tosURL string, at time.Time) { /* NOP */ }
+	}
+	client := acmeClient(c)
+	if c.CacheWriteFailureLimit > 0 {
+		client = writes.guard(client)
+	}
+	if !tlsALPN01 && c.Certificates == nil {
+		client = withoutTLSALPN01(client)
+	}
+	directoryURL := autocert.DefaultACMEDirectory
+	if client != nil {
+		directoryURL = client.DirectoryURL
+	}
+	handler := newSwappableHandler(c.Handler)
+	var main http.Handler = handler
+	if c.StaticDir != "" {
+		main = withStatic(handler, c.StaticDir, c.StaticPrefix, c.StaticSPAFallback)
+	}
+	if len(c.Listeners) > 0 {
+		main = byListener(main)
+	}
 	ss := &SecureServer{
-		server: &http.Server{Handler: c.Handler},
+		server:    &http.Server{Handler: wrapHandler(main, c), ErrorLog: errorLog(c.Logger)},
+		logger:    c.Logger,
+		hostnames: c.Hostnames,
+		handler:   handler,
 		certMgr: &autocert.Manager{
-			Prompt:     autocert.AcceptTOS,
-			HostPolicy: autocert.HostWhitelist(c.Hostnames...),
-			Cache:      c.CertCache,
+			Prompt:                 tosPrompt(c.Prompt, directoryURL, c.Logger, c.OnTOSAccepted),
+			HostPolicy:             observedHostPolicy(hostPolicy(c.Hostnames, c.HostPatterns), c.Logger, c.OnHostPolicyCheck),
+			Cache:                  c.CertCache,
+			Client:                 client,
+			ExternalAccountBinding: c.ACMEExternalAccountBinding,
 		},
 		serveSSLFunc:               c.ServeSSLFunc,
+		plainHTTPMode:              c.PlainHTTPMode,
+		redirectWhenCertReady:      c.RedirectWhenCertReady,
 		gracefulShutdownErrHandler: c.GracefulShutdownErrHandler,
+		confirmCertsOnStart:        c.RequireCertsOnStart || c.ConfirmCertsOnStart,
+		sslRequired:                c.RequireCertsOnStart || c.SSLRequired,
+		certStartTimeout:           c.CertStartTimeout,
+		primeConcurrency:           c.PrimeConcurrency,
+		primeRateLimit:             c.PrimeRateLimit,
+		onHTTPSListening:           c.OnHTTPSListening,
+		certSelector:               c.CertSelector,
+		shutdown:                   make(chan string, 1),
+		abort:                      make(chan struct{}),
+		done:                       make(chan struct{}),
+		offline:                    c.OfflineMode,
+		readOnlyCache:              c.ReadOnlyCache,
+		challenges:                 challenges,
+		certificates:               c.Certificates,
+		devCert:                    devCert,
+		internalRootCAs:            internalRoots(c.InternalRootCAs, devCert),
+		verifyPrimedCerts:          c.VerifyPrimedCerts,
+		originCertFile:             c.OriginCertFile,
+		originKeyFile:              c.OriginKeyFile,
+		listenConfig:               c.ListenConfig,
+		network:                    c.Network,
+		tcpKeepAlive:               c.TCPKeepAlive,
+		onDrainTimeout:             c.OnDrainTimeout,
+		onDrainHijacked:            c.OnDrainHijacked,
+		onRenewalFailure:           c.OnRenewalFailure,
+		onServingStaleCert:         c.OnServingStaleCert,
+		expiryWarningThreshold:     c.ExpiryWarningThreshold,
+		signalStrategies:           c.SignalStrategies,
+		logCertFingerprints:        c.LogCertFingerprints,
+		onExpiryWarning:            c.OnExpiryWarning,
+		tlsHandshakeTimeout:        c.TLSHandshakeTimeout,
+		tlsALPNFallback:            c.TLSALPNFallback,
+		http01:                     http01 && !c.OfflineMode && c.Certificates == nil,
+		tlsALPN01:                  tlsALPN01 && c.Certificates == nil,
+		shutdownOrder:              c.ShutdownOrder,
+		drainMode:                  c.DrainMode,
+		listeners:                  c.Listeners,
+		shutdownAttempts:           c.ShutdownAttempts,
+		shutdownRetryDelay:         c.ShutdownRetryDelay,
+		httpDrainTimeout:           c.HTTPDrainTimeout,
+		clientAuth:                 c.ClientAuth,
+		clientCAs:                  c.ClientCAs,
+		clientCertVerifier:         c.ClientCertVerifier,
+		getConfigForClient:         c.GetConfigForClient,
+		hostTLSOverrides:           hostTLSOverrides,
+		restrictChallengeALPN:      c.RestrictChallengeALPN,
+		onStart:                    c.OnStart,
+		ready:                      make(chan struct{}),
+		background:                 c.Background,
+		socketActivation:           c.SocketActivation,
+		closeIdleFirst:             c.CloseIdleFirst,
+		httpsDrainTimeout:          c.HTTPSDrainTimeout,
+	}
+	if c.CacheHealthCheckInterval > 0 {
+		check := ss.cacheHealthCheck(c.CacheHealthCheckInterval, c.OnCacheHealth)
+		ss.background = append(c.Background[:len(c.Background):len(c.Background)], check)
 	}
-	if err := ss.setPorts(c.HTTPPort, c.HTTPSPort); err != nil {
+	ss.certFetcher = ss.certMgr
+	ss.newRenewer = ss.newRenewalManager
+	ss.conns.idleTimeout = c.ConnIdleTimeout
+	ss.conns.trackHijacked = c.DrainHijacked
+	ss.server.ConnState = ss.conns.trackConnState
+	ss.server.BaseContext = ss.baseContext
+	if len(c.Listeners) > 0 {
+		ss.server.ConnContext = listenerConnContext
+	}
+	ss.stopping, ss.stop = context.WithCancel(context.Background())
+	ss.origin.Store(origin)
+	if c.HTTPHandler != nil {
+		ss.httpHandler = wrapHandler(c.HTTPHandler, c)
+	}
+	if c.MaxRequestsBeforeShutdown > 0 {
+		limit := withRequestLimit(c.MaxRequestsBeforeShutdown, func() {
+			ss.TriggerShutdown(fmt.Sprintf("served %d requests", c.MaxRequestsBeforeShutdown))
+		})
+		ss.server.Handler = limit(ss.server.Handler)
+		if ss.httpHandler != nil {
+			ss.httpHandler = limit(ss.httpHandler)
+		}
+	}
+	if err := ss.setPorts(c.HTTPPort, c.HTTPSPort, c.HTTPChallengePort); err != nil {
 		return nil, err
 	}
 	ss.setTimeouts(c.ReadTimeout, c.WriteTimeout, c.IdleTimeout, c.GracefulnessTimeout)
+	// plain HTTP is served separately so that it can be drained on its own
+	ss.httpServer = &http.Server{
+		Handler:      ss.server.Handler,
+		BaseContext:  ss.baseContext,
+		ConnState:    ss.conns.trackConnState,
+		ErrorLog:     ss.server.ErrorLog,
+		ReadTimeout:  ss.server.ReadTimeout,
+		WriteTimeout: ss.server.WriteTimeout,
+		IdleTimeout:  ss.server.IdleTimeout,
+	}
+	if ss.http01 && ss.httpChallengePort != "" {
+		// non-challenge requests are redirected to HTTPS, unless only
+		// challenges are to be answered. Note that autocert attempts
+		// HTTP-01 challenges as soon as its HTTPHandler is built
+		var fallback http.Handler
+		switch {
+		case c.PlainHTTPMode == ChallengeOnly:
+			fallback = http.NotFoundHandler()
+		case c.RedirectWhenCertReady:
+			fallback = ss.whenCertReady(http.HandlerFunc(redirectToHTTPS))
+		}
+		ss.challengeServer = &http.Server{
+			Handler:      ss.challenges.observe(ss.certMgr.HTTPHandler(fallback)),
+			BaseContext:  ss.baseContext,
+			ErrorLog:     ss.server.ErrorLog,
+			ReadTimeout:  ss.server.ReadTimeout,
+			WriteTimeout: ss.server.WriteTimeout,
+			IdleTimeout:  ss.server.IdleTimeout,
+		}
+		c.HTTPTimeouts.apply(ss.challengeServer)
+	}
+	c.HTTPTimeouts.apply(ss.httpServer)
+	c.HTTPSTimeouts.apply(ss.server)
 	return ss, nil
 }
 
-// setPorts sets the http and https ports on the server
-// Note: port definitions cannot be empty nor non numerical strings
-func (ss *SecureServer) setPorts(httpPort, httpsPort string) error {
-	if httpsPort == "" {
-		httpsPort = ":443"
+// SetHandler atomically replaces the server's http handler. Requests
+// accepted after the call are served by h, while in-flight requests
+// finish on the handler they started with. ACME challenge handling
+// remains in place regardless of the handler in use
+func (ss *SecureServer) SetHandler(h http.Handler) {
+	if h == nil {
+		panic("sslmgr: nil handler")
 	}
-	if _, err := strconv.Atoi(strings.TrimPrefix(httpsPort, ":")); err != nil {
-		return ErrNotAnInteger
+	ss.handler.store(h)
+}
+
+// setPorts sets the http, https and (optional) challenge ports on the server
+// Note: port definitions cannot be non numerical strings
+func (ss *SecureServer) setPorts(httpPort, httpsPort, challengePort string) error {
+	var err error
+	if ss.httpPort, ss.httpsPort, err = parsePorts(httpPort, httpsPort); err != nil {
+		return err
 	}
-	if !strings.HasPrefix(httpsPort, ":") {
-		httpsPort = fmt.Sprintf(":%s", httpsPort)
+	if challengePort != "" {
+		if ss.httpChallengePort, err = normalizePort(challengePort); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePorts validates the HTTP and HTTPS port definitions, defaulting
+// those left empty
+func parsePorts(httpPort, httpsPort string) (string, string, error) {
+	if httpsPort == "" {
+		httpsPort = defaultHTTPSPort
 	}
 	if httpPort == "" {
-		httpPort = ":80"
+		httpPort = defaultHTTPPort
 	}
-	if _, err := strconv.Atoi(strings.TrimPrefix(httpPort, ":")); err != nil {
-		return ErrNotAnInteger
+	var err error
+	if httpsPort, err = normalizePort(httpsPort); err != nil {
+		return "", "", err
 	}
-	if !strings.HasPrefix(httpPort, ":") {
-		httpPort = fmt.Sprintf(":%s", httpPort)
+	if httpPort, err = normalizePort(httpPort); err != nil {
+		return "", "", err
 	}
-	ss.httpPort = httpPort
-	ss.httpsPort = httpsPort
-	return nil
+	return httpPort, httpsPort, nil
+}
+
+// normalizePort validates a port definition and prefixes it with ":"
+func normalizePort(port string) (string, error) {
+	if _, err := strconv.Atoi(strings.TrimPrefix(port, ":")); err != nil {
+		return "", ErrNotAnInteger
+	}
+	if !strings.HasPrefix(port, ":") {
+		port = fmt.Sprintf(":%s", port)
+	}
+	return port, nil
 }
 
 // setTimeouts sets server operation and shutdown timeouts
 func (ss *SecureServer) setTimeouts(read, write, idle, gracefulness time.Duration) {
 	if read == time.Duration(0) {
-		read = 5 * time.Second
+		read = defaultReadTimeout
 	}
 	if write == time.Duration(0) {
-		write = 5 * time.Second
+		write = defaultWriteTimeout
 	}
 	if idle == time.Duration(0) {
-		idle = 25 * time.Second
+		idle = defaultIdleTimeout
 	}
 	if gracefulness == time.Duration(0) {
-		gracefulness = 5 * time.Second
+		gracefulness = defaultGracefulnessTimeout
 	}
 	ss.server.ReadTimeout = read
 	ss.server.WriteTimeout = write
@@ -189,49 +1508,578 @@ func (ss *SecureServer) setTimeouts(read, write, idle, gracefulness time.Duratio
 	ss.gracefulnessTimeout = gracefulness
 }
 
-// ListenAndServe starts the secure server
+// ListenAndServe starts the secure server and blocks until it is shut down
 func (ss *SecureServer) ListenAndServe() {
+	if err := ss.Start(); err != nil && err != ErrStartCanceled {
+		log.Fatalf("[sslmgr] Start() failed with %s", err)
+	}
+	<-ss.done
+}
+
+// Start binds the server's listeners and serves on them in the background.
+// Failure to bind either the HTTP or HTTPS port is returned synchronously
+// (describing both when neither could be bound),
+// as are ErrNoCertificates when RequireCertsOnStart is set and unmet, and
+// any error returned by the OnStart hook.
+// A shutdown signal received while starting up cancels startup, in which
+// case ErrStartCanceled is returned once the server is stopped
+func (ss *SecureServer) Start() error {
+	// handle shutdown signals from the very beginning, so that one received
+	// while starting up cancels startup rather than racing against it
 	ss.startGracefulStopHandler(ss.gracefulnessTimeout, ss.gracefulShutdownErrHandler)
 
-	if ss.serveSSLFunc() {
-		ss.serveHTTPS()
+	if ss.socketActivation {
+		inherited, err := inheritedListeners(listenFDsStart)
+		if err != nil {
+			close(ss.abort)
+			return err
+		}
+		ss.inherited = inherited
+	}
+	defer ss.closeUnclaimed() // every listener is bound by the time Start returns
+	ss.stateMu.Lock()
+	ss.servingSSL = ss.serveSSLFunc()
+	ss.stateMu.Unlock()
+	httpListener, err := ss.listen(ss.httpPort)
+	if err != nil && (ss.httpChallengePort != "" || !ss.fallBackToALPN(err)) {
+		err = bindError("http", ss.httpPort, err)
+		if ss.servingSSL {
+			// report whether the HTTPS port is taken as well
+			if l, httpsErr := ss.listen(ss.httpsPort); httpsErr != nil {
+				err = errors.Join(err, bindError("https", ss.httpsPort, httpsErr))
+			} else {
+				l.Close()
+			}
+		}
+		close(ss.abort)
+		return err
+	}
+	if ss.servingSSL {
+		if err := ss.serveHTTPS(); err != nil {
+			if httpListener != nil {
+				httpListener.Close()
+			}
+			close(ss.abort)
+			return err
+		}
+	}
+
+	if httpListener != nil {
+		ss.serveHTTP(httpListener)
+	}
+	if !ss.setState(StateServing) {
+		return ss.startCanceled()
+	}
+
+	if ss.servingSSL && ss.confirmCertsOnStart {
+		err := ss.requireCerts()
+		if err != nil && ss.stopping.Err() != nil {
+			return ss.startCanceled()
+		}
+		if err != nil && !ss.sslRequired && httpListener != nil {
+			err = ss.fallBackToHTTP()
+		}
+		if err != nil {
+			ss.close()
+			ss.setState(StateStopped)
+			close(ss.abort)
+			return err
+		}
+	}
+	if err := ss.onStart(ss.stopping); err != nil {
+		if ss.stopping.Err() != nil {
+			return ss.startCanceled()
+		}
+		ss.close()
+		ss.setState(StateStopped)
+		close(ss.abort)
+		return fmt.Errorf("OnStart failed: %w", err)
+	}
+	if ss.origin.Load() != nil {
+		ss.reloadOriginCertOnSignal()
+	}
+	ss.runBackground()
+	close(ss.ready)
+	return nil
+}
+
+// runBackground runs the Background tasks until the server shuts down,
+// unless it already began to
+func (ss *SecureServer) runBackground() {
+	ss.stateMu.Lock()
+	defer ss.stateMu.Unlock()
+	if ss.state != StateServing {
+		return
+	}
+	for i, task := range ss.background {
+		ss.backgroundWG.Add(1)
+		go func(i int, task func(context.Context) error) {
+			defer ss.backgroundWG.Done()
+			if err := task(ss.stopping); err != nil {
+				ss.logger.Printf("[sslmgr] background task %d failed: %s", i, err)
+			}
+		}(i, task)
+	}
+}
+
+// awaitBackground waits for the Background tasks to return until deadline
+func (ss *SecureServer) awaitBackground(deadline time.Time) error {
+	done := make(chan struct{})
+	go func() {
+		ss.backgroundWG.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return ErrBackgroundTimeout
+	}
+}
+
+// Ready returns a channel which is closed once Start completes
+// successfully, i.e. once the server is serving, holds any certificates
+// required by RequireCertsOnStart, and has run the OnStart hook
+func (ss *SecureServer) Ready() <-chan struct{} {
+	return ss.ready
+}
+
+// startCanceled waits for a shutdown which began during startup to
+// complete, and returns ErrStartCanceled
+func (ss *SecureServer) startCanceled() error {
+	<-ss.done
+	return ErrStartCanceled
+}
+
+// requireCerts returns ErrNoCertificates if no certificate can be
+// obtained for any hostname within the certStartTimeout
+func (ss *SecureServer) requireCerts() error {
+	if ss.certificates != nil {
+		return nil
+	}
+	ctx, cncl := context.WithTimeout(ss.stopping, ss.certStartTimeout)
+	defer cncl()
+	failures := ss.primeCerts(ctx)
+	if len(failures) < len(ss.hostnames) {
+		return nil
+	}
+	for host, err := range failures {
+		ss.logger.Printf("[sslmgr] could not obtain certificate for %s: %s", host, err)
+	}
+	return ErrNoCertificates
+}
+
+// fallBackToHTTP stops serving HTTPS, serving the Handler over plain HTTP
+// instead, after no certificate could be obtained on start
+func (ss *SecureServer) fallBackToHTTP() error {
+	ss.logger.Printf("[sslmgr] WARNING: %s within %s, falling back to serving plain HTTP only", ErrNoCertificates, ss.certStartTimeout)
+	ss.stateMu.Lock()
+	ss.servingSSL = false
+	ss.httpsListener = nil
+	ss.stateMu.Unlock()
+	ss.serveContentOverHTTP()
+	return ss.server.Close()
+}
+
+// listen binds a TCP listener to addr with the server's ListenConfig and
+// Network, unless a listener for addr was inherited through socket
+// activation
+func (ss *SecureServer) listen(addr string) (net.Listener, error) {
+	l, ok := ss.inherited[addr]
+	if ok {
+		delete(ss.inherited, addr)
+	} else {
+		var err error
+		if l, err = ss.listenConfig.Listen(context.Background(), ss.network, addr); err != nil {
+			if errors.Is(err, os.ErrPermission) {
+				return nil, fmt.Errorf("%w (%s): %s", ErrPortPermission, addr, err)
+			}
+			return nil, err
+		}
+	}
+	if ss.tcpKeepAlive != time.Duration(0) {
+		l = &keepAliveListener{Listener: l, period: ss.tcpKeepAlive}
 	}
+	if ss.conns.trackHijacked {
+		l = &hijackableListener{Listener: l, conns: &ss.conns}
+	}
+	return l, nil
+}
+
+// bindError describes the failure to bind the named listener at addr
+func bindError(name, addr string, err error) error {
+	return fmt.Errorf("failed to bind %s listener at %s: %w", name, addr, err)
+}
 
-	ss.server.Addr = ss.httpPort
-	log.Printf("[sslmgr] serving http at %s", ss.httpPort)
-	if err := ss.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("[sslmgr] ListenAndServe() failed with %s", err)
+// fallBackToALPN reports whether the server may carry on without
+// answering HTTP-01 challenges after failing to bind the port they are
+// answered on with err, in which case it stops offering them
+func (ss *SecureServer) fallBackToALPN(err error) bool {
+	if !ss.tlsALPNFallback || !ss.tlsALPN01 || !ss.servingSSL || !errors.Is(err, ErrPortPermission) {
+		return false
 	}
+	ss.logger.Printf("[sslmgr] %s, falling back to TLS-ALPN-01 challenges", err)
+	ss.http01 = false
+	return true
 }
 
-func (ss *SecureServer) serveHTTPS() {
-	ss.server.Addr = ss.httpsPort
-	ss.server.TLSConfig = &tls.Config{GetCertificate: ss.certMgr.GetCertificate}
+// serveChallenges answers ACME HTTP-01 challenges on the challenge port
+func (ss *SecureServer) serveChallenges() error {
+	challengeListener, err := ss.listen(ss.httpChallengePort)
+	if err != nil {
+		if ss.fallBackToALPN(err) {
+			return nil
+		}
+		return bindError("acme challenge", ss.httpChallengePort, err)
+	}
 	go func() {
-		log.Printf("[sslmgr] serving https at %s", ss.httpsPort)
-		if err := ss.server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("[sslmgr] ListendAndServeTLS() failed with %s", err)
+		ss.logger.Printf("[sslmgr] serving acme challenges at %s", challengeListener.Addr())
+		if err := ss.challengeServer.Serve(challengeListener); !servingStopped(err) {
+			log.Fatalf("[sslmgr] Serve() failed with %s", err)
 		}
 	}()
+	return nil
+}
+
+func (ss *SecureServer) serveHTTPS() error {
+	httpsListener, err := ss.listen(ss.httpsPort)
+	if err != nil {
+		return bindError("https", ss.httpsPort, err)
+	}
+	listeners := []net.Listener{httpsListener}
+	closeListeners := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for _, lc := range ss.listeners {
+		l, err := ss.listen(lc.Addr)
+		if err != nil {
+			closeListeners()
+			return bindError("https", lc.Addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+	if ss.http01 && ss.httpChallengePort != "" {
+		if err := ss.serveChallenges(); err != nil {
+			closeListeners()
+			return err
+		}
+	}
+	ss.server.TLSConfig = ss.tlsConfig()
+	plain := ss.server.Handler
+	switch {
+	case ss.plainHTTPMode == RedirectToHTTPS:
+		plain = http.HandlerFunc(redirectToHTTPS)
+		if ss.redirectWhenCertReady {
+			plain = ss.whenCertReady(plain)
+		}
+	case ss.plainHTTPMode == ChallengeOnly:
+		plain = http.NotFoundHandler()
+	case ss.httpHandler != nil:
+		plain = ss.httpHandler
+	}
 	// allow autocert handler Let's Encrypt auth callbacks over HTTP
-	ss.server.Handler = ss.certMgr.HTTPHandler(ss.server.Handler)
-	// some time for OS scheduler to start SSL thread (before changing http.Server port)
-	time.Sleep(time.Millisecond * 50)
+	// (there are none to answer when certificates are never requested)
+	if ss.http01 && ss.httpChallengePort == "" {
+		plain = ss.challenges.observe(ss.certMgr.HTTPHandler(plain))
+	}
+	content, secure := ss.server.Handler, ss.server.Handler
+	if ss.confirmCertsOnStart && !ss.sslRequired {
+		// plain HTTP serves the Handler should HTTPS be given up on
+		fallback := newSwappableHandler(plain)
+		ss.serveContentOverHTTP = func() { fallback.store(content) }
+		plain = fallback
+	}
+	ss.server.Handler = byScheme(secure, plain)
+	ss.httpServer.Handler = ss.server.Handler
+	ss.serveTLS(httpsListener)
+	for i, lc := range ss.listeners {
+		ss.serveListener(listeners[i+1], lc)
+	}
+	return nil
+}
+
+// serveHTTP serves plain HTTP on l in the background
+func (ss *SecureServer) serveHTTP(l net.Listener) {
+	ss.stateMu.Lock()
+	ss.httpListener = l
+	ss.stateMu.Unlock()
+	go func() {
+		ss.logger.Printf("[sslmgr] serving http at %s", l.Addr())
+		if err := ss.httpServer.Serve(l); !servingStopped(err) {
+			log.Fatalf("[sslmgr] Serve() failed with %s", err)
+		}
+	}()
+}
+
+// serveTLS serves HTTPS on l in the background
+func (ss *SecureServer) serveTLS(l net.Listener) {
+	served := l
+	serve := func() error { return ss.server.ServeTLS(l, "", "") }
+	if ss.tlsHandshakeTimeout > 0 {
+		// handshakes are completed by the listener rather than net/http
+		tlsListener := newHandshakeListener(l, ss.server.TLSConfig, ss.tlsHandshakeTimeout)
+		served = tlsListener
+		serve = func() error { return ss.server.Serve(tlsListener) }
+	}
+	ss.stateMu.Lock()
+	ss.httpsListener = served
+	ss.stateMu.Unlock()
+	go func() {
+		addr := l.Addr().String()
+		ss.onHTTPSListening(addr)
+		ss.logger.Printf("[sslmgr] serving https at %s", addr)
+		if err := serve(); !servingStopped(err) {
+			log.Fatalf("[sslmgr] ServeTLS() failed with %s", err)
+		}
+	}()
+}
+
+// servingStopped reports whether err, as returned by an http.Server's
+// Serve, results from the server shutting down or from Rebind closing
+// the listener it served
+func servingStopped(err error) bool {
+	return err == http.ErrServerClosed || errors.Is(err, net.ErrClosed)
+}
+
+// Rebind moves the server to the given HTTP and HTTPS ports without a
+// restart, e.g. to :443 once privileges to bind it were acquired. New
+// listeners are bound and served first, and only then are the old ones
+// closed, while connections accepted on them are served until they go
+// idle. Ports are validated and defaulted as in the ServerConfig, and only
+// the listeners being served (e.g. not HTTPS while ServeSSLFunc disabled
+// it) are moved. A listener whose port is unchanged is kept as it is, so
+// that either port may be moved on its own. Should binding either port
+// fail, the server carries on on its current ports. Rebind fails with
+// ErrNotServing unless the server is serving
+func (ss *SecureServer) Rebind(httpPort, httpsPort string) error {
+	httpPort, httpsPort, err := parsePorts(httpPort, httpsPort)
+	if err != nil {
+		return err
+	}
+	ss.rebindMu.Lock()
+	defer ss.rebindMu.Unlock()
+	ss.stateMu.Lock()
+	serving := ss.state == StateServing
+	oldHTTP, oldHTTPS := ss.httpListener, ss.httpsListener
+	ss.stateMu.Unlock()
+	if !serving {
+		return ErrNotServing
+	}
+	var httpListener, httpsListener net.Listener
+	if oldHTTP != nil && httpPort != ss.httpPort {
+		if httpListener, err = ss.listen(httpPort); err != nil {
+			return err
+		}
+	}
+	if oldHTTPS != nil && httpsPort != ss.httpsPort {
+		if httpsListener, err = ss.listen(httpsPort); err != nil {
+			if httpListener != nil {
+				httpListener.Close()
+			}
+			return err
+		}
+	}
+	if httpListener != nil {
+		ss.serveHTTP(httpListener)
+		oldHTTP.Close()
+		ss.httpPort = httpPort
+	}
+	if httpsListener != nil {
+		ss.serveTLS(httpsListener)
+		oldHTTPS.Close()
+		ss.httpsPort = httpsPort
+	}
+	return nil
+}
+
+// TriggerShutdown initiates the same graceful shutdown a SIGTERM would,
+// logging the given reason. This allows application level health logic
+// to stop the server without synthesizing an OS signal
+func (ss *SecureServer) TriggerShutdown(reason string) {
+	select {
+	case ss.shutdown <- reason:
+	default:
+		// a shutdown has already been triggered
+	}
+}
+
+// IsShuttingDown reports whether the server a request is being served by
+// has begun shutting down, given the request's context. Handlers may use
+// it to avoid starting long operations which would be cut off by the end
+// of the drain
+func IsShuttingDown(ctx context.Context) bool {
+	stopping, ok := ctx.Value(shutdownKey).(context.Context)
+	return ok && stopping.Err() != nil
+}
+
+// baseContext is the http.Server.BaseContext hook used by the server,
+// marking every request's context with the server's shutdown state
+func (ss *SecureServer) baseContext(net.Listener) context.Context {
+	return context.WithValue(context.Background(), shutdownKey, ss.stopping)
 }
 
 func (ss *SecureServer) startGracefulStopHandler(timeout time.Duration, errHandler func(error)) {
-	gracefulStop := make(chan os.Signal)
+	gracefulStop := make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGTERM, syscall.SIGINT)
 
 	go func() {
-		<-gracefulStop
-		log.Print("[sslmgr] shutdown signal received, draining existing connections...")
-		ctx, cncl := context.WithTimeout(context.Background(), timeout)
-		defer cncl()
-		if err := ss.server.Shutdown(ctx); err != nil {
-			log.Printf("[sslmgr] server could not be shutdown gracefully: %s", err)
+		defer signal.Stop(gracefulStop)
+		select {
+		case sig := <-gracefulStop:
+			if ss.signalStrategies[sig] == ShutdownImmediate {
+				ss.closeImmediately(sig, timeout, errHandler)
+				return
+			}
+			ss.logShutdown(ShutdownEvent{Phase: ShutdownDraining, Signal: sig})
+		case reason := <-ss.shutdown:
+			ss.logShutdown(ShutdownEvent{Phase: ShutdownDraining, Reason: reason})
+		case <-ss.abort:
+			return // the server failed to start
+		}
+		ss.stop()
+		if ss.closeIdleFirst {
+			ss.disableKeepAlives()
+		}
+		ss.setState(StateDraining)
+		deadline := time.Now().Add(timeout)
+		if ss.shutdownAttempts > 1 {
+			// background tasks get as long as every attempt may take
+			retries := time.Duration(ss.shutdownAttempts - 1)
+			deadline = deadline.Add(retries * (timeout + ss.shutdownRetryDelay))
+		}
+		if err := errors.Join(ss.drainWithRetries(timeout), ss.awaitBackground(deadline)); err != nil {
+			ss.logShutdown(ShutdownEvent{Phase: ShutdownFailed, Err: err})
+			if errors.Is(err, context.DeadlineExceeded) {
+				ss.onDrainTimeout(ss.conns.openConns() + ss.conns.hijackedConns())
+			}
 			errHandler(err)
 		}
-		log.Print("[sslmgr] server was closed successfully with no service interruptions")
+		ss.logShutdown(ShutdownEvent{Phase: ShutdownComplete})
+		ss.setState(StateStopped)
+		close(ss.done)
 	}()
 }
+
+// closeImmediately closes the server along with every connection on
+// receipt of sig, as configured by the ShutdownImmediate strategy, only
+// waiting (for up to timeout) for the Background tasks to return
+func (ss *SecureServer) closeImmediately(sig os.Signal, timeout time.Duration, errHandler func(error)) {
+	ss.logShutdown(ShutdownEvent{Phase: ShutdownClosing, Signal: sig})
+	ss.stop()
+	err := ss.close()
+	ss.conns.closeHijacked()
+	if err = errors.Join(err, ss.awaitBackground(time.Now().Add(timeout))); err != nil {
+		ss.logShutdown(ShutdownEvent{Phase: ShutdownFailed, Err: err})
+		errHandler(err)
+	}
+	ss.logShutdown(ShutdownEvent{Phase: ShutdownForced})
+	ss.setState(StateStopped)
+	close(ss.done)
+}
+
+// drainWithRetries drains connections up to ShutdownAttempts times for as
+// long as draining times out, closing the remaining connections once
+// every attempt has
+func (ss *SecureServer) drainWithRetries(timeout time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		err := ss.drain(timeout)
+		if err == nil || !errors.Is(err, context.DeadlineExceeded) || ss.shutdownAttempts < 2 {
+			return err
+		}
+		remaining := ss.conns.openConns() + ss.conns.hijackedConns()
+		if attempt == ss.shutdownAttempts {
+			ss.logger.Printf("[sslmgr] graceful shutdown attempt %d of %d timed out, closing %d remaining connections", attempt, ss.shutdownAttempts, remaining)
+			ss.close()
+			ss.conns.closeHijacked()
+			return err
+		}
+		ss.logger.Printf("[sslmgr] graceful shutdown attempt %d of %d timed out with %d connections open, retrying in %s", attempt, ss.shutdownAttempts, remaining, ss.shutdownRetryDelay)
+		time.Sleep(ss.shutdownRetryDelay)
+	}
+}
+
+// drain gracefully shuts down the HTTP and HTTPS servers in the order
+// configured, each within its own drain timeout if set or else timeout
+func (ss *SecureServer) drain(timeout time.Duration) (err error) {
+	within := func(d time.Duration) (context.Context, context.CancelFunc) {
+		if d == time.Duration(0) {
+			d = timeout
+		}
+		if ss.drainMode == DrainProgressBased {
+			return ss.conns.untilStalled(d)
+		}
+		return context.WithTimeout(context.Background(), d)
+	}
+	drainHTTP := func() error {
+		ctx, cncl := within(ss.httpDrainTimeout)
+		defer cncl()
+		var err error
+		if ss.challengeServer != nil {
+			err = ss.challengeServer.Shutdown(ctx)
+		}
+		return shutdownErr(ctx, errors.Join(err, ss.httpServer.Shutdown(ctx)))
+	}
+	drainHTTPS := func() error {
+		ctx, cncl := within(ss.httpsDrainTimeout)
+		defer cncl()
+		return shutdownErr(ctx, ss.server.Shutdown(ctx))
+	}
+	if ss.conns.trackHijacked {
+		// hijacked connections are drained alongside the listeners
+		ctx, cncl := within(0)
+		defer cncl()
+		hijacked := make(chan error, 1)
+		ss.onDrainHijacked(ss.conns.hijackedConns())
+		go func() { hijacked <- shutdownErr(ctx, ss.conns.awaitHijacked(ctx)) }()
+		defer func() { err = errors.Join(err, <-hijacked) }()
+	}
+	switch ss.shutdownOrder {
+	case ShutdownHTTPFirst:
+		return errors.Join(drainHTTP(), drainHTTPS())
+	case ShutdownHTTPSFirst:
+		return errors.Join(drainHTTPS(), drainHTTP())
+	default:
+		var httpErr error
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			httpErr = drainHTTP()
+		}()
+		httpsErr := drainHTTPS()
+		wg.Wait()
+		return errors.Join(httpErr, httpsErr)
+	}
+}
+
+// shutdownErr replaces the error of a shutdown given up on through ctx
+// with the reason ctx was canceled, e.g. a stalled drain
+func shutdownErr(ctx context.Context, err error) error {
+	if err != nil && errors.Is(err, ctx.Err()) {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+// disableKeepAlives disables keep-alives on every listener, closing
+// their idle connections
+func (ss *SecureServer) disableKeepAlives() {
+	ss.httpServer.SetKeepAlivesEnabled(false)
+	ss.server.SetKeepAlivesEnabled(false)
+	if ss.challengeServer != nil {
+		ss.challengeServer.SetKeepAlivesEnabled(false)
+	}
+}
+
+// close immediately closes all of the server's listeners and connections
+func (ss *SecureServer) close() error {
+	var err error
+	if ss.challengeServer != nil {
+		err = ss.challengeServer.Close()
+	}
+	return errors.Join(err, ss.httpServer.Close(), ss.server.Close())
+}
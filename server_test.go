@@ -1,13 +1,23 @@
 package sslmgr
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 func TestSecureServer(t *testing.T) {
@@ -18,6 +28,33 @@ func TestSecureServer(t *testing.T) {
 			So(err, ShouldBeNil)
 		})
 	})
+	Convey("Test DefaultConfig()", t, func() {
+		Convey("Test Defaults Are Populated", func() {
+			c := DefaultConfig()
+			So(c.HTTPPort, ShouldEqual, ":80")
+			So(c.HTTPSPort, ShouldEqual, ":443")
+			So(c.ReadTimeout, ShouldEqual, 5*time.Second)
+			So(c.WriteTimeout, ShouldEqual, 5*time.Second)
+			So(c.IdleTimeout, ShouldEqual, 25*time.Second)
+			So(c.GracefulnessTimeout, ShouldEqual, 5*time.Second)
+			So(c.CertCache, ShouldNotBeNil)
+			So(c.ServeSSLFunc(), ShouldEqual, true)
+			So(c.GracefulShutdownErrHandler, ShouldNotBeNil)
+		})
+		Convey("Test Overridden Defaults Are Applied", func() {
+			c := DefaultConfig()
+			c.Hostnames = []string{"yourdomain.io"}
+			c.Handler = http.NotFoundHandler()
+			c.HTTPSPort = ":8443"
+			c.ReadTimeout = 10 * time.Second
+			ss, err := NewServer(c)
+			So(err, ShouldBeNil)
+			So(ss.httpPort, ShouldEqual, ":80")
+			So(ss.httpsPort, ShouldEqual, ":8443")
+			So(ss.server.ReadTimeout, ShouldEqual, 10*time.Second)
+			So(ss.server.WriteTimeout, ShouldEqual, 5*time.Second)
+		})
+	})
 	Convey("Test NewServer()", t, func() {
 		Convey("Test Required Field - Hostnames nil", func() {
 			ss, err := NewServer(ServerConfig{
@@ -49,6 +86,15 @@ func TestSecureServer(t *testing.T) {
 			So(ss, ShouldNotBeNil)
 			So(err, ShouldBeNil)
 		})
+		Convey("Test Early Data Is Refused", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:        http.NotFoundHandler(),
+				Hostnames:      []string{"yourdomain.io"},
+				AllowEarlyData: true,
+			})
+			So(ss, ShouldBeNil)
+			So(err, ShouldEqual, ErrEarlyDataUnsupported)
+		})
 		Convey("Test Default Values Are Applied", func() {
 			ss, err := NewServer(ServerConfig{
 				Handler:   http.NotFoundHandler(),
@@ -64,12 +110,34 @@ func TestSecureServer(t *testing.T) {
 			So(ss.server.ReadTimeout, ShouldEqual, 5*time.Second)
 			So(ss.server.IdleTimeout, ShouldEqual, 25*time.Second)
 			So(ss.server.WriteTimeout, ShouldEqual, 5*time.Second)
+			So(ss.httpServer.ReadTimeout, ShouldEqual, 5*time.Second)
+			So(ss.httpServer.IdleTimeout, ShouldEqual, 25*time.Second)
+			So(ss.httpServer.WriteTimeout, ShouldEqual, 5*time.Second)
 			So(ss.gracefulnessTimeout, ShouldEqual, 5*time.Second)
 			So(ss.gracefulShutdownErrHandler, ShouldNotBeNil)
 			So(func() {
 				ss.gracefulShutdownErrHandler(errors.New("Hello World"))
 			}, ShouldNotPanic)
 		})
+		Convey("Test Per Listener Timeouts", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:           http.NotFoundHandler(),
+				Hostnames:         []string{"yourdomain.io"},
+				HTTPChallengePort: ":0",
+				WriteTimeout:      time.Minute,
+				HTTPTimeouts:      Timeouts{Read: time.Second, Write: 2 * time.Second},
+				HTTPSTimeouts:     Timeouts{Idle: time.Hour},
+			})
+			So(err, ShouldBeNil)
+			for _, srv := range []*http.Server{ss.httpServer, ss.challengeServer} {
+				So(srv.ReadTimeout, ShouldEqual, time.Second)
+				So(srv.WriteTimeout, ShouldEqual, 2*time.Second)
+				So(srv.IdleTimeout, ShouldEqual, 25*time.Second)
+			}
+			So(ss.server.ReadTimeout, ShouldEqual, 5*time.Second)
+			So(ss.server.WriteTimeout, ShouldEqual, time.Minute)
+			So(ss.server.IdleTimeout, ShouldEqual, time.Hour)
+		})
 		Convey("Test Port Address Correction", func() {
 			ss, err := NewServer(ServerConfig{
 				Handler:   http.NotFoundHandler(),
@@ -115,20 +183,757 @@ func TestSecureServer(t *testing.T) {
 			}, ShouldNotPanic)
 		})
 	})
+	Convey("Test TriggerShutdown()", t, func() {
+		Convey("Test TriggerShutdown Stops The Server", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:      http.NotFoundHandler(),
+				Hostnames:    []string{"yourdomain.io"},
+				HTTPPort:     ":0",
+				ServeSSLFunc: func() bool { return false },
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			ss.TriggerShutdown("dependency unhealthy")
+			ss.TriggerShutdown("dependency still unhealthy")
+			select {
+			case <-ss.done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("server was not shut down")
+			}
+		})
+	})
+	Convey("Test Shutdown Ordering", t, func() {
+		Convey("Test IsShuttingDown Reports Draining To Handlers", func() {
+			inFlight, release := make(chan struct{}), make(chan struct{})
+			shuttingDown := make(chan bool, 2)
+			port := freePort()
+			ss, err := NewServer(ServerConfig{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					shuttingDown <- IsShuttingDown(r.Context())
+					close(inFlight)
+					<-release
+					shuttingDown <- IsShuttingDown(r.Context())
+				}),
+				Hostnames:    []string{"yourdomain.io"},
+				HTTPPort:     port,
+				ServeSSLFunc: ServeSSLNever,
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			go http.Get("http://localhost" + port)
+			<-inFlight
+			ss.TriggerShutdown("test")
+			for ss.Status().State != StateDraining {
+				time.Sleep(10 * time.Millisecond)
+			}
+			close(release)
+			So(<-shuttingDown, ShouldBeFalse)
+			So(<-shuttingDown, ShouldBeTrue)
+			<-ss.done
+			So(IsShuttingDown(context.Background()), ShouldBeFalse)
+		})
+		Convey("Test CloseIdleFirst Disables Keep-Alives On Every Listener", func() {
+			for _, closeIdleFirst := range []bool{false, true} {
+				inFlight, release := make(chan struct{}), make(chan struct{})
+				httpPort, httpsPort := freePort(), freePort()
+				serverCert := testTLSConfig("yourdomain.io").Certificates[0]
+				ss, err := NewServer(ServerConfig{
+					Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						if r.TLS == nil {
+							close(inFlight)
+							<-release
+						}
+					}),
+					Hostnames: []string{"yourdomain.io"},
+					HTTPPort:  httpPort,
+					HTTPSPort: httpsPort,
+					CertSelector: func(*tls.ClientHelloInfo) (*tls.Certificate, bool) {
+						return &serverCert, true
+					},
+					OfflineMode:    true,
+					ShutdownOrder:  ShutdownHTTPFirst,
+					CloseIdleFirst: closeIdleFirst,
+				})
+				So(err, ShouldBeNil)
+				So(ss.Start(), ShouldBeNil)
+				go http.Get("http://localhost" + httpPort)
+				<-inFlight
+				ss.TriggerShutdown("test")
+				for ss.Status().State != StateDraining {
+					time.Sleep(10 * time.Millisecond)
+				}
+				client := &http.Client{Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				}}
+				resp, err := client.Get("https://localhost" + httpsPort)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.Close, ShouldEqual, closeIdleFirst)
+				close(release)
+				<-ss.done
+			}
+		})
+		Convey("Test ShutdownHTTPFirst Keeps HTTPS Accepting", func() {
+			inFlight, release := make(chan struct{}), make(chan struct{})
+			defer close(release)
+			shutdownErrs := make(chan error, 1)
+			httpPort, httpsPort := freePort(), freePort()
+			ss, err := NewServer(ServerConfig{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					close(inFlight)
+					<-release
+				}),
+				Hostnames:                  []string{"yourdomain.io"},
+				HTTPPort:                   httpPort,
+				HTTPSPort:                  httpsPort,
+				OfflineMode:                true,
+				ShutdownOrder:              ShutdownHTTPFirst,
+				HTTPDrainTimeout:           time.Second,
+				GracefulShutdownErrHandler: func(err error) { shutdownErrs <- err },
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			go http.Get("http://localhost" + httpPort)
+			<-inFlight
+			ss.TriggerShutdown("deregistering")
+			// the http listener closes first, while https still accepts
+			for {
+				conn, err := net.Dial("tcp", "localhost"+httpPort)
+				if err != nil {
+					break
+				}
+				conn.Close()
+				time.Sleep(10 * time.Millisecond)
+			}
+			conn, err := net.Dial("tcp", "localhost"+httpsPort)
+			So(err, ShouldBeNil)
+			conn.Close()
+			select {
+			case err := <-shutdownErrs:
+				So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+			case <-time.After(10 * time.Second):
+				t.Fatal("server was not shut down")
+			}
+			<-ss.done
+		})
+	})
 	Convey("Test serveHTTPS()", t, func() {
 		Convey("Test serveHTTPS Does Not Panic", func() {
+			listening := make(chan string, 1)
 			ss, err := NewServer(ServerConfig{
 				Handler:   http.NotFoundHandler(),
 				Hostnames: []string{"yourdomain.io"},
+				HTTPSPort: ":0",
+				OnHTTPSListening: func(addr string) {
+					listening <- addr
+				},
 			})
 			So(ss, ShouldNotBeNil)
 			So(err, ShouldBeNil)
+			defer ss.server.Close()
 			So(func() {
-				ss.testing = true
-				ss.serveHTTPS()
+				So(ss.serveHTTPS(), ShouldBeNil)
 				syscall.Signal(syscall.SIGINT).Signal()
 			}, ShouldNotPanic)
-			So(ss.server.Addr, ShouldEqual, ":443")
+			So(<-listening, ShouldNotBeEmpty)
+		})
+		Convey("Test serveHTTPS Completes Handshakes", func() {
+			port := freePort()
+			ss, err := NewServer(ServerConfig{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprint(w, r.TLS.ServerName)
+				}),
+				Hostnames: []string{"yourdomain.io"},
+				HTTPSPort: port,
+			})
+			So(err, ShouldBeNil)
+			fake := &fakeCertManager{}
+			withCertManager(ss, fake)
+			So(ss.serveHTTPS(), ShouldBeNil)
+			defer ss.close()
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{ServerName: "yourdomain.io", InsecureSkipVerify: true},
+			}}
+			resp, err := client.Get("https://localhost" + port)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			So(resp.TLS.PeerCertificates[0].DNSNames, ShouldResemble, []string{"yourdomain.io"})
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "yourdomain.io")
+			So(fake.issued, ShouldResemble, []string{"yourdomain.io"})
+		})
+		Convey("Test serveHTTPS Bind Failure Is Returned", func() {
+			l, err := net.Listen("tcp", ":0")
+			So(err, ShouldBeNil)
+			defer l.Close()
+			ss, err := NewServer(ServerConfig{
+				Handler:   http.NotFoundHandler(),
+				Hostnames: []string{"yourdomain.io"},
+				HTTPSPort: portOf(l),
+			})
+			So(err, ShouldBeNil)
+			So(ss.serveHTTPS(), ShouldNotBeNil)
 		})
 	})
+	Convey("Test Start()", t, func() {
+		Convey("Test Start Serves HTTP", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:      http.NotFoundHandler(),
+				Hostnames:    []string{"yourdomain.io"},
+				HTTPPort:     ":0",
+				ServeSSLFunc: func() bool { return false },
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			So(ss.close(), ShouldBeNil)
+		})
+		Convey("Test Start Uses Network", func() {
+			var networks []string
+			ss, err := NewServer(ServerConfig{
+				Handler:   http.NotFoundHandler(),
+				Hostnames: []string{"yourdomain.io"},
+				HTTPPort:  ":0",
+				HTTPSPort: ":0",
+				Network:   "tcp4",
+				ListenConfig: &net.ListenConfig{
+					Control: func(network, address string, c syscall.RawConn) error {
+						networks = append(networks, network)
+						return nil
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			So(ss.close(), ShouldBeNil)
+			So(networks, ShouldResemble, []string{"tcp4", "tcp4"})
+			Convey("Test Unknown Network Is Rejected", func() {
+				_, err := NewServer(ServerConfig{
+					Handler:   http.NotFoundHandler(),
+					Hostnames: []string{"yourdomain.io"},
+					Network:   "udp",
+				})
+				So(errors.Is(err, ErrInvalidNetwork), ShouldBeTrue)
+			})
+		})
+		Convey("Test Start Uses ListenConfig", func() {
+			var controlled []string
+			ss, err := NewServer(ServerConfig{
+				Handler:   http.NotFoundHandler(),
+				Hostnames: []string{"yourdomain.io"},
+				HTTPPort:  ":0",
+				HTTPSPort: ":0",
+				ListenConfig: &net.ListenConfig{
+					Control: func(network, address string, c syscall.RawConn) error {
+						controlled = append(controlled, address)
+						return nil
+					},
+				},
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			So(ss.close(), ShouldBeNil)
+			So(controlled, ShouldHaveLength, 2)
+		})
+		Convey("Test Start HTTP Bind Failure Is Returned", func() {
+			l, err := net.Listen("tcp", ":0")
+			So(err, ShouldBeNil)
+			defer l.Close()
+			ss, err := NewServer(ServerConfig{
+				Handler:   http.NotFoundHandler(),
+				Hostnames: []string{"yourdomain.io"},
+				HTTPPort:  portOf(l),
+				HTTPSPort: ":0",
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldNotBeNil)
+		})
+		Convey("Test Start Describes Both Bind Failures", func() {
+			httpTaken, err := net.Listen("tcp", ":0")
+			So(err, ShouldBeNil)
+			defer httpTaken.Close()
+			httpsTaken, err := net.Listen("tcp", ":0")
+			So(err, ShouldBeNil)
+			defer httpsTaken.Close()
+			ss, err := NewServer(ServerConfig{
+				Handler:   http.NotFoundHandler(),
+				Hostnames: []string{"yourdomain.io"},
+				HTTPPort:  portOf(httpTaken),
+				HTTPSPort: portOf(httpsTaken),
+			})
+			So(err, ShouldBeNil)
+			err = ss.Start()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "failed to bind http listener at "+portOf(httpTaken))
+			So(err.Error(), ShouldContainSubstring, "failed to bind https listener at "+portOf(httpsTaken))
+		})
+		Convey("Test Start HTTPS Bind Failure Is Returned", func() {
+			l, err := net.Listen("tcp", ":0")
+			So(err, ShouldBeNil)
+			defer l.Close()
+			ss, err := NewServer(ServerConfig{
+				Handler:   http.NotFoundHandler(),
+				Hostnames: []string{"yourdomain.io"},
+				HTTPPort:  ":0",
+				HTTPSPort: portOf(l),
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldNotBeNil)
+		})
+		Convey("Test Start Reports Missing Privileges", func() {
+			httpPort := freePort()
+			denied := &net.ListenConfig{
+				Control: func(network, address string, c syscall.RawConn) error {
+					if strings.HasSuffix(address, httpPort) {
+						return syscall.EACCES
+					}
+					return nil
+				},
+			}
+			ss, err := NewServer(ServerConfig{
+				Handler:      http.NotFoundHandler(),
+				Hostnames:    []string{"yourdomain.io"},
+				HTTPPort:     httpPort,
+				HTTPSPort:    ":0",
+				ListenConfig: denied,
+			})
+			So(err, ShouldBeNil)
+			err = ss.Start()
+			So(errors.Is(err, ErrPortPermission), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, "CAP_NET_BIND_SERVICE")
+			Convey("Test TLSALPNFallback Starts Without HTTP-01", func() {
+				ss, err := NewServer(ServerConfig{
+					Handler:         http.NotFoundHandler(),
+					Hostnames:       []string{"yourdomain.io"},
+					HTTPPort:        httpPort,
+					HTTPSPort:       ":0",
+					ListenConfig:    denied,
+					TLSALPNFallback: true,
+				})
+				So(err, ShouldBeNil)
+				So(ss.Start(), ShouldBeNil)
+				defer ss.close()
+				So(ss.http01, ShouldBeFalse)
+				So(ss.server.TLSConfig.NextProtos, ShouldContain, acme.ALPNProto)
+			})
+		})
+		Convey("Test HTTPChallengePort Answers Challenges", func() {
+			httpPort, challengePort := freePort(), freePort()
+			ss, err := NewServer(ServerConfig{
+				Handler:           http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) }),
+				Hostnames:         []string{"yourdomain.io"},
+				HTTPPort:          httpPort,
+				HTTPSPort:         ":0",
+				HTTPChallengePort: challengePort,
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			defer ss.close()
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}}
+			resp, err := client.Get("http://localhost" + httpPort + "/.well-known/acme-challenge/token")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusTeapot)
+			resp, err = client.Get("http://localhost" + challengePort + "/")
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusFound)
+			So(resp.Header.Get("Location"), ShouldStartWith, "https://")
+		})
+		Convey("Test Invalid HTTPChallengePort", func() {
+			_, err := NewServer(ServerConfig{
+				Handler:           http.NotFoundHandler(),
+				Hostnames:         []string{"yourdomain.io"},
+				HTTPChallengePort: "not a number",
+			})
+			So(err, ShouldEqual, ErrNotAnInteger)
+		})
+		Convey("Test OnStart Runs Before Ready", func() {
+			var readyDuringOnStart bool
+			var ss *SecureServer
+			ss, err := NewServer(ServerConfig{
+				Handler:      http.NotFoundHandler(),
+				Hostnames:    []string{"yourdomain.io"},
+				HTTPPort:     ":0",
+				ServeSSLFunc: func() bool { return false },
+				OnStart: func(ctx context.Context) error {
+					select {
+					case <-ss.Ready():
+						readyDuringOnStart = true
+					default:
+					}
+					return nil
+				},
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			defer ss.close()
+			So(readyDuringOnStart, ShouldBeFalse)
+			select {
+			case <-ss.Ready():
+			default:
+				t.Fatal("server was not ready")
+			}
+		})
+		Convey("Test OnStart Failure Fails Start", func() {
+			warmupErr := errors.New("warmup failed")
+			ss, err := NewServer(ServerConfig{
+				Handler:      http.NotFoundHandler(),
+				Hostnames:    []string{"yourdomain.io"},
+				HTTPPort:     ":0",
+				ServeSSLFunc: func() bool { return false },
+				OnStart:      func(ctx context.Context) error { return warmupErr },
+			})
+			So(err, ShouldBeNil)
+			So(errors.Is(ss.Start(), warmupErr), ShouldBeTrue)
+			So(ss.Status().State, ShouldEqual, StateStopped)
+			select {
+			case <-ss.Ready():
+				t.Fatal("server should not be ready")
+			default:
+			}
+		})
+		Convey("Test Start Stops On SIGTERM", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:      http.NotFoundHandler(),
+				Hostnames:    []string{"yourdomain.io"},
+				HTTPPort:     ":0",
+				ServeSSLFunc: func() bool { return false },
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			So(syscall.Kill(syscall.Getpid(), syscall.SIGTERM), ShouldBeNil)
+			select {
+			case <-ss.done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("server was not shut down")
+			}
+			So(ss.Status().State, ShouldEqual, StateStopped)
+		})
+		Convey("Test SignalStrategies", func() {
+			port := freePort()
+			logger := &shutdownLogger{events: make(chan ShutdownEvent, 10)}
+			ss, err := NewServer(ServerConfig{
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					time.Sleep(300 * time.Millisecond)
+				}),
+				Hostnames:           []string{"yourdomain.io"},
+				HTTPPort:            port,
+				ServeSSLFunc:        func() bool { return false },
+				Logger:              logger,
+				GracefulnessTimeout: 5 * time.Second,
+				SignalStrategies:    map[os.Signal]ShutdownStrategy{syscall.SIGINT: ShutdownImmediate},
+			})
+			So(err, ShouldBeNil)
+			So(ss.Start(), ShouldBeNil)
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			inFlight := make(chan error, 1)
+			go func() {
+				resp, err := client.Get("http://localhost" + port)
+				if err == nil {
+					resp.Body.Close()
+				}
+				inFlight <- err
+			}()
+			time.Sleep(100 * time.Millisecond)
+			signalAndWait := func(sig syscall.Signal) {
+				So(syscall.Kill(syscall.Getpid(), sig), ShouldBeNil)
+				select {
+				case <-ss.done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("server was not shut down")
+				}
+				So(ss.Status().State, ShouldEqual, StateStopped)
+			}
+			Convey("Test SIGINT Closes Connections Immediately", func() {
+				start := time.Now()
+				signalAndWait(syscall.SIGINT)
+				So(time.Since(start), ShouldBeLessThan, 150*time.Millisecond)
+				So(<-inFlight, ShouldNotBeNil)
+				So((<-logger.events).Phase, ShouldEqual, ShutdownClosing)
+				So((<-logger.events).Phase, ShouldEqual, ShutdownForced)
+			})
+			Convey("Test SIGTERM Drains Connections", func() {
+				signalAndWait(syscall.SIGTERM)
+				So(<-inFlight, ShouldBeNil)
+			})
+		})
+		Convey("Test SIGTERM During Startup Cancels Start", func() {
+			release := make(chan struct{})
+			defer close(release)
+			ss, err := NewServer(ServerConfig{
+				Handler:   http.NotFoundHandler(),
+				Hostnames: []string{"yourdomain.io"},
+				HTTPPort:  ":0",
+				HTTPSPort: ":0",
+				CertCache: newMemCache(),
+				CertSelector: func(hello *tls.ClientHelloInfo) (*tls.Certificate, bool) {
+					<-release
+					return nil, false
+				},
+				OfflineMode:         true,
+				RequireCertsOnStart: true,
+				CertStartTimeout:    10 * time.Second,
+			})
+			So(err, ShouldBeNil)
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+			}()
+			start := time.Now()
+			So(ss.Start(), ShouldEqual, ErrStartCanceled)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+			So(ss.Status().State, ShouldEqual, StateStopped)
+		})
+	})
+	Convey("Test Background", t, func() {
+		var errs []error
+		newServer := func(task func(context.Context) error) *SecureServer {
+			ss, err := NewServer(ServerConfig{
+				Handler:             http.NotFoundHandler(),
+				Hostnames:           []string{"yourdomain.io"},
+				HTTPPort:            freePort(),
+				ServeSSLFunc:        ServeSSLNever,
+				GracefulnessTimeout: 200 * time.Millisecond,
+				GracefulShutdownErrHandler: func(err error) {
+					errs = append(errs, err)
+				},
+				Background: []func(context.Context) error{task},
+			})
+			So(err, ShouldBeNil)
+			return ss
+		}
+		Convey("Test Tasks Run Until Shutdown", func() {
+			started, stopped := make(chan struct{}), make(chan struct{})
+			ss := newServer(func(ctx context.Context) error {
+				close(started)
+				<-ctx.Done()
+				time.Sleep(50 * time.Millisecond)
+				close(stopped)
+				return nil
+			})
+			So(ss.Start(), ShouldBeNil)
+			<-started
+			ss.TriggerShutdown("test")
+			<-ss.done
+			select {
+			case <-stopped:
+			default:
+				t.Fatal("shutdown did not wait for the background task")
+			}
+			So(errs, ShouldBeEmpty)
+		})
+		Convey("Test Tasks Which Do Not Return Fail The Shutdown", func() {
+			ss := newServer(func(ctx context.Context) error {
+				select {}
+			})
+			So(ss.Start(), ShouldBeNil)
+			ss.TriggerShutdown("test")
+			<-ss.done
+			So(errs, ShouldHaveLength, 1)
+			So(errors.Is(errs[0], ErrBackgroundTimeout), ShouldBeTrue)
+		})
+	})
+	Convey("Test Rebind()", t, func() {
+		httpPort, httpsPort := freePort(), freePort()
+		released := make(chan struct{})
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow" {
+					<-released
+				}
+				fmt.Fprint(w, "ok")
+			}),
+			HTTPPort:     httpPort,
+			HTTPSPort:    httpsPort,
+			Certificates: testTLSConfig("yourdomain.io").Certificates,
+		})
+		So(err, ShouldBeNil)
+		So(ss.Rebind(freePort(), freePort()), ShouldEqual, ErrNotServing)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		}}
+		Convey("Test Invalid Ports Are Rejected", func() {
+			So(ss.Rebind("http", httpsPort), ShouldEqual, ErrNotAnInteger)
+		})
+		Convey("Test Server Moves To New Ports", func() {
+			slow := make(chan error, 1)
+			go func() {
+				resp, err := client.Get("https://localhost" + httpsPort + "/slow")
+				if err == nil {
+					resp.Body.Close()
+				}
+				slow <- err
+			}()
+			time.Sleep(100 * time.Millisecond)
+			newHTTPPort, newHTTPSPort := freePort(), freePort()
+			So(ss.Rebind(newHTTPPort, newHTTPSPort), ShouldBeNil)
+			for _, url := range []string{"http://localhost" + newHTTPPort, "https://localhost" + newHTTPSPort} {
+				resp, err := client.Get(url)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			}
+			for _, url := range []string{"http://localhost" + httpPort, "https://localhost" + httpsPort} {
+				_, err := client.Get(url)
+				So(err, ShouldNotBeNil)
+			}
+			close(released)
+			So(<-slow, ShouldBeNil)
+		})
+		Convey("Test HTTPS Port Moves On Its Own", func() {
+			newHTTPSPort := freePort()
+			So(ss.Rebind(httpPort, newHTTPSPort), ShouldBeNil)
+			for _, url := range []string{"http://localhost" + httpPort, "https://localhost" + newHTTPSPort} {
+				resp, err := client.Get(url)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			}
+			_, err := client.Get("https://localhost" + httpsPort)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Test Failure To Bind Keeps Current Ports", func() {
+			taken, err := net.Listen("tcp", ":0")
+			So(err, ShouldBeNil)
+			defer taken.Close()
+			So(ss.Rebind(freePort(), portOf(taken)), ShouldNotBeNil)
+			resp, err := client.Get("https://localhost" + httpsPort)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+		})
+	})
+	Convey("Test getCertificate()", t, func() {
+		Convey("Test CertSelector Short Circuits", func() {
+			selected := &tls.Certificate{}
+			ss, err := NewServer(ServerConfig{
+				Handler:   http.NotFoundHandler(),
+				Hostnames: []string{"yourdomain.io"},
+				CertSelector: func(hello *tls.ClientHelloInfo) (*tls.Certificate, bool) {
+					return selected, hello.ServerName == "yourdomain.io"
+				},
+				OfflineMode: true,
+			})
+			So(err, ShouldBeNil)
+			cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+			So(err, ShouldBeNil)
+			So(cert, ShouldEqual, selected)
+			Convey("Test CertSelector Falls Through", func() {
+				cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "otherdomain.io"})
+				So(cert, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+		Convey("Test Certificates Are Served Without ACME", func() {
+			certs := []tls.Certificate{
+				testTLSConfig("yourdomain.io").Certificates[0],
+				testTLSConfig("otherdomain.io").Certificates[0],
+			}
+			port := freePort()
+			ss, err := NewServer(ServerConfig{
+				Handler:      http.NotFoundHandler(),
+				HTTPPort:     freePort(),
+				HTTPSPort:    port,
+				Certificates: certs,
+			})
+			So(err, ShouldBeNil)
+			So(ss.http01, ShouldBeFalse)
+			So(ss.tlsConfig().NextProtos, ShouldNotContain, acme.ALPNProto)
+			So(ss.Start(), ShouldBeNil)
+			defer ss.close()
+			for _, host := range []string{"yourdomain.io", "otherdomain.io"} {
+				conn, err := tls.Dial("tcp", "localhost"+port, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+				So(err, ShouldBeNil)
+				So(conn.ConnectionState().PeerCertificates[0].DNSNames, ShouldResemble, []string{host})
+				conn.Close()
+			}
+		})
+		Convey("Test OfflineMode Serves From Cache", func() {
+			now := time.Now()
+			cache := newMemCache()
+			cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+			ss, err := NewServer(ServerConfig{
+				Handler:     http.NotFoundHandler(),
+				Hostnames:   []string{"yourdomain.io"},
+				CertCache:   cache,
+				OfflineMode: true,
+			})
+			So(err, ShouldBeNil)
+			cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "YourDomain.io"})
+			So(err, ShouldBeNil)
+			So(cert, ShouldNotBeNil)
+		})
+		Convey("Test OfflineMode Cache Miss", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:     http.NotFoundHandler(),
+				Hostnames:   []string{"yourdomain.io"},
+				CertCache:   newMemCache(),
+				OfflineMode: true,
+			})
+			So(err, ShouldBeNil)
+			cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+			So(cert, ShouldBeNil)
+			So(errors.Is(err, ErrOfflineCacheMiss), ShouldBeTrue)
+		})
+		Convey("Test OfflineMode Respects Host Policy", func() {
+			ss, err := NewServer(ServerConfig{
+				Handler:     http.NotFoundHandler(),
+				Hostnames:   []string{"yourdomain.io"},
+				CertCache:   newMemCache(),
+				OfflineMode: true,
+			})
+			So(err, ShouldBeNil)
+			cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "otherdomain.io"})
+			So(cert, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(errors.Is(err, ErrOfflineCacheMiss), ShouldBeFalse)
+		})
+		Convey("Test ReadOnlyCache", func() {
+			now := time.Now()
+			cache := newMemCache()
+			ss, err := NewServer(ServerConfig{
+				Handler:       http.NotFoundHandler(),
+				Hostnames:     []string{"yourdomain.io"},
+				CertCache:     cache,
+				ReadOnlyCache: true,
+			})
+			So(err, ShouldBeNil)
+			Convey("Test Cache Miss Does Not Issue", func() {
+				cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+				So(cert, ShouldBeNil)
+				So(errors.Is(err, ErrReadOnlyCacheMiss), ShouldBeTrue)
+			})
+			Convey("Test Certificate Cached By Leader Is Served", func() {
+				cache.Put(context.Background(), "yourdomain.io", testCacheEntry("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour)))
+				cert, err := ss.getCertificate(&tls.ClientHelloInfo{ServerName: "yourdomain.io"})
+				So(err, ShouldBeNil)
+				So(cert, ShouldNotBeNil)
+			})
+			Convey("Test Writes Are Refused", func() {
+				certPEM, keyPEM := testCertPEM("yourdomain.io", now.Add(-time.Hour), now.Add(time.Hour))
+				err := ss.ImportCert(context.Background(), "yourdomain.io", certPEM, keyPEM)
+				So(err, ShouldEqual, ErrReadOnlyCache)
+				_, err = cache.Get(context.Background(), "yourdomain.io")
+				So(err, ShouldEqual, autocert.ErrCacheMiss)
+			})
+			Convey("Test Leader's HTTP-01 Challenges Are Answered", func() {
+				So(ss.http01, ShouldBeTrue)
+				cache.Put(context.Background(), "token+http-01", []byte("token.thumbprint"))
+				rec := httptest.NewRecorder()
+				ss.certMgr.HTTPHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://yourdomain.io/.well-known/acme-challenge/token", nil))
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				So(rec.Body.String(), ShouldEqual, "token.thumbprint")
+			})
+		})
+	})
+}
+
+// portOf returns the port a listener is bound to in ":port" form
+func portOf(l net.Listener) string {
+	return fmt.Sprintf(":%d", l.Addr().(*net.TCPAddr).Port)
 }
@@ -0,0 +1,32 @@
+package sslmgr
+
+import (
+	"os"
+	"strings"
+)
+
+// ServeSSLAlways is a ServeSSLFunc which always serves HTTPS
+func ServeSSLAlways() bool {
+	return true
+}
+
+// ServeSSLNever is a ServeSSLFunc which never serves HTTPS, e.g. for
+// local development
+func ServeSSLNever() bool {
+	return false
+}
+
+// ServeSSLFromEnv returns a ServeSSLFunc which serves HTTPS only if the
+// environment variable varName is set to trueValue (case insensitively),
+// as is common for toggling HTTPS between development and production.
+// An empty trueValue matches any non-empty value, so that HTTPS is never
+// served while varName is unset
+func ServeSSLFromEnv(varName, trueValue string) func() bool {
+	return func() bool {
+		value := os.Getenv(varName)
+		if trueValue == "" {
+			return value != ""
+		}
+		return strings.EqualFold(value, trueValue)
+	}
+}
@@ -0,0 +1,29 @@
+package sslmgr
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestServeSSL(t *testing.T) {
+	Convey("Test ServeSSLAlways() And ServeSSLNever()", t, func() {
+		So(ServeSSLAlways(), ShouldBeTrue)
+		So(ServeSSLNever(), ShouldBeFalse)
+	})
+	Convey("Test ServeSSLFromEnv()", t, func() {
+		serveSSL := ServeSSLFromEnv("SSLMGR_TEST_PROD", "true")
+		t.Setenv("SSLMGR_TEST_PROD", "TRUE")
+		So(serveSSL(), ShouldBeTrue)
+		t.Setenv("SSLMGR_TEST_PROD", "false")
+		So(serveSSL(), ShouldBeFalse)
+		t.Setenv("SSLMGR_TEST_PROD", "")
+		So(serveSSL(), ShouldBeFalse)
+		Convey("Test Empty trueValue Matches Any Non-Empty Value", func() {
+			serveSSL := ServeSSLFromEnv("SSLMGR_TEST_PROD", "")
+			So(serveSSL(), ShouldBeFalse)
+			t.Setenv("SSLMGR_TEST_PROD", "1")
+			So(serveSSL(), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,65 @@
+package sslmgr
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// SSEWriter streams server-sent events to a client, flushing each event
+// as soon as it is sent. It is safe for concurrent use, e.g. to send
+// heartbeats from a separate goroutine
+type SSEWriter struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// NewSSEWriter sets up w for streaming server-sent events: it sets the
+// event stream headers, removes the write deadline so that the stream
+// outlives the server's WriteTimeout, and sends the headers right away
+// (which also keeps the response from being held back for compression)
+func NewSSEWriter(w http.ResponseWriter) (*SSEWriter, error) {
+	if err := ExtendWriteDeadline(w, 0); err != nil {
+		return nil, fmt.Errorf("could not remove the write deadline: %w", err)
+	}
+	hdr := w.Header()
+	hdr.Set("Content-Type", "text/event-stream")
+	hdr.Set("Cache-Control", "no-cache")
+	hdr.Set("X-Accel-Buffering", "no") // keep reverse proxies from buffering
+	hdr.Del("Content-Length")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return nil, fmt.Errorf("could not flush the response: %w", err)
+	}
+	return &SSEWriter{w: w, rc: rc}, nil
+}
+
+// Send writes an event of the given type to the client and flushes it.
+// An empty event sends an unnamed event, which clients receive as a
+// "message". Multi-line data is split across data fields
+func (sw *SSEWriter) Send(event, data string) error {
+	var b strings.Builder
+	if event != "" {
+		b.WriteString("event: " + sseField(event) + "\n")
+	}
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + strings.ReplaceAll(line, "\r", "") + "\n")
+	}
+	b.WriteString("\n")
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if _, err := io.WriteString(sw.w, b.String()); err != nil {
+		return err
+	}
+	return sw.rc.Flush()
+}
+
+// sseField strips line breaks, which would otherwise end the field early
+func sseField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
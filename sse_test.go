@@ -0,0 +1,67 @@
+package sslmgr
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSSE(t *testing.T) {
+	Convey("Test NewSSEWriter()", t, func() {
+		port := freePort()
+		ss, err := NewServer(ServerConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sw, err := NewSSEWriter(w)
+				if err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				sw.Send("", "hello")
+				time.Sleep(150 * time.Millisecond)
+				sw.Send("update", "line one\nline two")
+				time.Sleep(150 * time.Millisecond)
+				sw.Send("bye\n", "")
+			}),
+			Hostnames:    []string{"yourdomain.io"},
+			HTTPPort:     port,
+			ServeSSLFunc: func() bool { return false },
+			WriteTimeout: 100 * time.Millisecond,
+			Compression:  CompressionConfig{Enabled: true},
+		})
+		So(err, ShouldBeNil)
+		So(ss.Start(), ShouldBeNil)
+		defer ss.close()
+		req, err := http.NewRequest(http.MethodGet, "http://localhost"+port, nil)
+		So(err, ShouldBeNil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		So(err, ShouldBeNil)
+		defer resp.Body.Close()
+		So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		So(resp.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+		So(resp.Header.Get("Cache-Control"), ShouldEqual, "no-cache")
+		So(resp.Header.Get("Content-Encoding"), ShouldBeEmpty)
+		Convey("Test Events Are Flushed As They Are Sent", func() {
+			buf := make([]byte, 64)
+			n, err := resp.Body.Read(buf)
+			So(err, ShouldBeNil)
+			So(string(buf[:n]), ShouldEqual, "data: hello\n\n")
+		})
+		Convey("Test Stream Outlives WriteTimeout", func() {
+			body, err := io.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "data: hello\n\n"+
+				"event: update\ndata: line one\ndata: line two\n\n"+
+				"event: bye\ndata: \n\n")
+		})
+	})
+	Convey("Test NewSSEWriter() Requires Deadline Control", t, func() {
+		_, err := NewSSEWriter(httptest.NewRecorder())
+		So(errors.Is(err, http.ErrNotSupported), ShouldBeTrue)
+	})
+}
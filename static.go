@@ -0,0 +1,60 @@
+package sslmgr
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// defaultStaticPrefix is the path static files are served under when a
+// StaticDir is configured without a StaticPrefix
+const defaultStaticPrefix = "/static/"
+
+// withStatic serves the files in dir for requests under prefix, handing
+// all other requests to h. With spa set, requests under prefix matching
+// no file are answered with the directory's index.html
+func withStatic(h http.Handler, dir, prefix string, spa bool) http.Handler {
+	if prefix == "" {
+		prefix = defaultStaticPrefix
+	}
+	prefix = "/" + strings.Trim(prefix, "/") + "/"
+	if prefix == "//" {
+		prefix = "/"
+	}
+	root := http.Dir(dir)
+	files := http.StripPrefix(strings.TrimSuffix(prefix, "/"), http.FileServer(root))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if spa {
+			name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, prefix))
+			if f, err := root.Open(name); errors.Is(err, fs.ErrNotExist) {
+				serveIndex(w, r, root)
+				return
+			} else if err == nil {
+				f.Close()
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// serveIndex answers with the index.html at the root of the file system
+func serveIndex(w http.ResponseWriter, r *http.Request, root http.FileSystem) {
+	f, err := root.Open("/index.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, "index.html", info.ModTime(), f)
+}
@@ -0,0 +1,47 @@
+package sslmgr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStatic(t *testing.T) {
+	Convey("Test withStatic()", t, func() {
+		dir := t.TempDir()
+		So(os.WriteFile(filepath.Join(dir, "index.html"), []byte("index"), 0o644), ShouldBeNil)
+		So(os.WriteFile(filepath.Join(dir, "app.js"), []byte("app"), 0o644), ShouldBeNil)
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+		get := func(h http.Handler, path string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			return rec
+		}
+		Convey("Test Files Are Served Under The Prefix", func() {
+			h := withStatic(next, dir, "", false)
+			rec := get(h, "/static/app.js")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldEqual, "app")
+			So(get(h, "/static/missing.js").Code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Test Other Paths Reach The Handler", func() {
+			h := withStatic(next, dir, "assets", false)
+			So(get(h, "/api/users").Code, ShouldEqual, http.StatusTeapot)
+			So(get(h, "/assets/app.js").Body.String(), ShouldEqual, "app")
+		})
+		Convey("Test SPA Fallback Serves Index", func() {
+			h := withStatic(next, dir, "/app/", true)
+			rec := get(h, "/app/users/42")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldEqual, "index")
+			So(get(h, "/app/app.js").Body.String(), ShouldEqual, "app")
+			So(get(h, "/api/users").Code, ShouldEqual, http.StatusTeapot)
+		})
+	})
+}
@@ -0,0 +1,78 @@
+package sslmgr
+
+import "time"
+
+// ServerState is a stage of the server's lifecycle
+type ServerState int
+
+const (
+	// StateNotStarted is the state of a server which has not been started
+	StateNotStarted ServerState = iota
+	// StateServing is the state of a server accepting connections
+	StateServing
+	// StateDraining is the state of a server which stopped accepting
+	// connections and is waiting for existing ones to finish
+	StateDraining
+	// StateStopped is the state of a server which is no longer serving
+	StateStopped
+)
+
+// String returns the name of the state
+func (s ServerState) String() string {
+	switch s {
+	case StateNotStarted:
+		return "not started"
+	case StateServing:
+		return "serving"
+	case StateDraining:
+		return "draining"
+	case StateStopped:
+		return "stopped"
+	default:
+		return "unknown"
+	}
+}
+
+// ServerStatus is a snapshot of the server's lifecycle state
+type ServerStatus struct {
+	State ServerState
+	// StartedAt is the time at which the server started serving,
+	// or the zero time if it never has
+	StartedAt time.Time
+	// Uptime is the time elapsed since StartedAt while serving or
+	// draining, and zero otherwise
+	Uptime time.Duration
+	// ServingSSL reports whether the server serves HTTPS
+	ServingSSL bool
+}
+
+// Status returns the server's current lifecycle state
+func (ss *SecureServer) Status() ServerStatus {
+	ss.stateMu.Lock()
+	defer ss.stateMu.Unlock()
+	status := ServerStatus{
+		State:      ss.state,
+		StartedAt:  ss.startedAt,
+		ServingSSL: ss.servingSSL,
+	}
+	if ss.state == StateServing || ss.state == StateDraining {
+		status.Uptime = time.Since(ss.startedAt)
+	}
+	return status
+}
+
+// setState transitions the server to the given lifecycle state. A server
+// may only start serving once, so the transition to StateServing is
+// refused (and false returned) if the server already began shutting down
+func (ss *SecureServer) setState(s ServerState) bool {
+	ss.stateMu.Lock()
+	defer ss.stateMu.Unlock()
+	if s == StateServing {
+		if ss.state != StateNotStarted {
+			return false
+		}
+		ss.startedAt = time.Now()
+	}
+	ss.state = s
+	return true
+}
@@ -0,0 +1,42 @@
+package sslmgr
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStatus(t *testing.T) {
+	Convey("Test Status()", t, func() {
+		ss, err := NewServer(ServerConfig{
+			Handler:      http.NotFoundHandler(),
+			Hostnames:    []string{"yourdomain.io"},
+			HTTPPort:     ":0",
+			ServeSSLFunc: func() bool { return false },
+		})
+		So(err, ShouldBeNil)
+		status := ss.Status()
+		So(status.State, ShouldEqual, StateNotStarted)
+		So(status.StartedAt.IsZero(), ShouldBeTrue)
+		So(status.Uptime, ShouldEqual, 0)
+
+		So(ss.Start(), ShouldBeNil)
+		status = ss.Status()
+		So(status.State, ShouldEqual, StateServing)
+		So(status.State.String(), ShouldEqual, "serving")
+		So(status.StartedAt.IsZero(), ShouldBeFalse)
+		So(status.ServingSSL, ShouldBeFalse)
+
+		ss.TriggerShutdown("test")
+		select {
+		case <-ss.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("server was not shut down")
+		}
+		status = ss.Status()
+		So(status.State, ShouldEqual, StateStopped)
+		So(status.Uptime, ShouldEqual, 0)
+	})
+}